@@ -0,0 +1,182 @@
+package test
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// APIM is injected into the spoke's exp subnet, module.spoke.module.subnet_exp
+const apimSubnetKey = "exp"
+
+// Inbound traffic an APIM subnet's NSG must admit: the control plane on the
+// management endpoint and the load balancer's health probes
+var apimInboundRequirements = []struct {
+	Tag  string
+	Addr netip.Addr
+	Port int
+}{
+	{"ApiManagement", apiManagementAddr, 3443},
+	{"AzureLoadBalancer", azureLoadBalancerAddr, 6390},
+}
+
+// Hostnames APIM serves, with the attribute holding each one's URL and the
+// record name prefix used when the URL is not known yet
+var apimHostnames = []struct {
+	Kind, URLAttr, Prefix string
+}{
+	{"gateway", "gateway_url", ""},
+	{"portal", "portal_url", "portal"},
+	{"management", "management_api_url", "management"},
+	{"developer", "developer_portal_url", "developer"},
+	{"scm", "scm_url", "scm"},
+}
+
+// virtual_network_type the environment deploys APIM with; README's model is Internal
+func (e *Expectations) apimVirtualNetworkType() string {
+	if e == nil || e.APIMVirtualNetworkType == "" {
+		return "Internal"
+	}
+	return e.APIMVirtualNetworkType
+}
+
+func expAPIMs(tfState *State) []ResourceInstance {
+	return findModuleInstancesByType(tfState, "module.exp", "azurerm_api_management")
+}
+
+// virtual_network_type matches the environment
+func checkAPIMNetworkType(apims []ResourceInstance, exp *Expectations) CheckResult {
+	return eachInstance(apims, func(ri ResourceInstance) CheckResult {
+		if problem := apimNetworkTypeProblem(ri, exp); problem != "" {
+			return fail(problem)
+		}
+		return pass(1)
+	})
+}
+
+func apimNetworkTypeProblem(ri ResourceInstance, exp *Expectations) string {
+	want := exp.apimVirtualNetworkType()
+	if got, _ := ri.Attr().String("virtual_network_type"); got != want {
+		return fmt.Sprintf("virtual_network_type is %q, expected %q", got, want)
+	}
+	return ""
+}
+
+// virtual_network_configuration.subnet_id is the exp subnet in state
+func checkAPIMSubnet(tfState *State) CheckResult {
+	subnets := map[string]ipamSubnet{}
+	for _, s := range subnetsOf(tfState) {
+		subnets[s.id] = s
+	}
+	return eachInstance(expAPIMs(tfState), func(ri ResourceInstance) CheckResult {
+		id, err := ri.Attr().String("virtual_network_configuration.0.subnet_id")
+		if err != nil || id == "" {
+			return fail("no virtual_network_configuration subnet_id")
+		}
+		subnet, ok := subnets[strings.ToLower(id)]
+		if !ok {
+			return fail(fmt.Sprintf("subnet_id %s does not resolve to a subnet in state", id))
+		}
+		if key := spokeSubnetKey(subnet.ResourceInstance); key != apimSubnetKey {
+			return fail(fmt.Sprintf("subnet_id resolves to %s, expected module.spoke.module.subnet_%s", subnet.Address(), apimSubnetKey))
+		}
+		return passWith(1, "injected into "+subnet.Address())
+	})
+}
+
+// The APIM subnet's NSG admits the control plane on 3443 and load balancer probes
+func checkAPIMSubnetNSG(tfState *State) CheckResult {
+	network := buildNetwork(tfState)
+	return eachInstance(expAPIMs(tfState), func(ri ResourceInstance) CheckResult {
+		id, _ := ri.Attr().String("virtual_network_configuration.0.subnet_id")
+		var subnet *netSubnet
+		for _, s := range network.subnets {
+			if s.id != "" && s.id == strings.ToLower(id) {
+				subnet = s
+			}
+		}
+		switch {
+		case subnet == nil:
+			return notApplicable("APIM subnet is not in state")
+		case subnet.nsg == "":
+			return fail(subnet.address + " has no NSG; APIM in a VNet needs one admitting ApiManagement and AzureLoadBalancer")
+		case len(subnet.prefixes) == 0:
+			return notApplicable("APIM subnet has no address prefix yet")
+		}
+		dst := firstHost(subnet.prefixes[0])
+		var problems, evidence []string
+		for _, req := range apimInboundRequirements {
+			rule := network.firstMatch(subnet.nsg, "Inbound", subnet.vnet, req.Addr, dst, "Tcp", req.Port)
+			line := fmt.Sprintf("%s -> Tcp/%d: %s rule %s (priority %d)", req.Tag, req.Port, rule.Access, rule.Name, rule.Priority)
+			if !strings.EqualFold(rule.Access, "Allow") {
+				problems = append(problems, line)
+			}
+			evidence = append(evidence, line)
+		}
+		if len(problems) > 0 {
+			return fail(fmt.Sprintf("NSG %s blocks %s", subnet.nsg, strings.Join(problems, "; ")))
+		}
+		return passWith(1, strings.Join(evidence, "; "))
+	})
+}
+
+// The FQDN of each hostname an APIM instance serves, keyed by kind
+func apimFQDNs(ri ResourceInstance) map[string]string {
+	name, _ := ri.Attr().String("name")
+	fqdns := map[string]string{}
+	for _, h := range apimHostnames {
+		url, _ := ri.Attr().String(h.URLAttr)
+		host := strings.TrimPrefix(strings.TrimPrefix(url, "https://"), "http://")
+		host = strings.TrimSuffix(host, "/")
+		if host == "" && name != "" {
+			host = name + ".azure-api.net"
+			if h.Prefix != "" {
+				host = name + "." + h.Prefix + ".azure-api.net"
+			}
+		}
+		if host != "" {
+			fqdns[h.Kind] = strings.ToLower(host)
+		}
+	}
+	return fqdns
+}
+
+// Every A record for an APIM hostname points at private_ip_addresses[0]
+func checkAPIMDNSRecords(tfState *State) CheckResult {
+	records := map[string]ResourceInstance{}
+	for _, rec := range tfState.InstancesByType("azurerm_private_dns_a_record") {
+		name, _ := rec.Attr().String("name")
+		zone, _ := rec.Attr().String("zone_name")
+		records[strings.ToLower(name+"."+zone)] = rec
+	}
+	return eachInstance(expAPIMs(tfState), func(ri ResourceInstance) CheckResult {
+		ip, err := ri.Attr().String("private_ip_addresses.0")
+		if err != nil || ip == "" {
+			return notApplicable("private_ip_addresses is not known until apply")
+		}
+		var problems, found, missing []string
+		for _, h := range apimHostnames {
+			fqdn, ok := apimFQDNs(ri)[h.Kind]
+			rec, exists := records[fqdn]
+			if !ok || !exists {
+				missing = append(missing, h.Kind)
+				continue
+			}
+			found = append(found, h.Kind)
+			if values, _ := rec.Attr().Strings("records"); len(values) != 1 || values[0] != ip {
+				problems = append(problems, fmt.Sprintf("%s (%s) points at %v, expected [%s]", rec.Address(), fqdn, values, ip))
+			}
+		}
+		switch {
+		case len(problems) > 0:
+			return fail(strings.Join(problems, "; "))
+		case len(found) == 0:
+			return fail("no private DNS A record for any APIM hostname")
+		}
+		msg := "records for " + strings.Join(found, ", ") + " point at " + ip
+		if len(missing) > 0 {
+			msg += "; no record for " + strings.Join(missing, ", ")
+		}
+		return passWith(1, msg)
+	})
+}
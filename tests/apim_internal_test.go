@@ -0,0 +1,94 @@
+package test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// An Internal APIM in the exp subnet whose NSG admits the control plane and then
+// denies everything else, with a management record left on an old address
+func apimInternalState(extraRules string) string {
+	return `{
+	"version": 4,
+	"resources": [
+		{"module": "module.spoke.module.spoke_vnet", "mode": "managed", "type": "azurerm_virtual_network", "name": "vnet",
+		 "instances": [{"attributes": {"id": "/s/vnets/spoke-vnet", "name": "spoke-vnet", "resource_group_name": "spk-rg", "address_space": ["10.110.0.0/16"]}}]},
+		{"module": "module.spoke.module.subnet_exp", "mode": "managed", "type": "azurerm_subnet", "name": "subnet",
+		 "instances": [{"attributes": {"id": "/s/exp-snet", "name": "exp-snet", "resource_group_name": "spk-rg", "virtual_network_name": "spoke-vnet", "address_prefixes": ["10.110.20.0/24"]}}]},
+		{"module": "module.spoke.module.subnet_sys", "mode": "managed", "type": "azurerm_subnet", "name": "subnet",
+		 "instances": [{"attributes": {"id": "/s/sys-snet", "name": "sys-snet", "resource_group_name": "spk-rg", "virtual_network_name": "spoke-vnet", "address_prefixes": ["10.110.40.0/24"]}}]},
+		{"module": "module.spoke.module.subnet_exp", "mode": "managed", "type": "azurerm_subnet_network_security_group_association", "name": "this",
+		 "instances": [{"attributes": {"subnet_id": "/s/exp-snet", "network_security_group_id": "/s/resourceGroups/spk-rg/providers/Microsoft.Network/networkSecurityGroups/exp-nsg"}}]},
+		{"module": "module.spoke.module.nsg_exp", "mode": "managed", "type": "azurerm_network_security_rule", "name": "rules",
+		 "instances": [
+		   {"index_key": "apim_management", "attributes": {"name": "apim_management", "resource_group_name": "spk-rg", "network_security_group_name": "exp-nsg",
+		     "priority": 100, "direction": "Inbound", "access": "Allow", "protocol": "Tcp", "source_port_range": "*", "destination_port_range": "3443",
+		     "source_address_prefix": "ApiManagement", "destination_address_prefix": "VirtualNetwork"}}` + extraRules + `
+		 ]},
+		{"module": "module.exp.module.apim", "mode": "managed", "type": "azurerm_api_management", "name": "this",
+		 "instances": [{"attributes": {"name": "dev-apim", "virtual_network_type": "Internal", "private_ip_addresses": ["10.110.20.5"],
+		   "gateway_url": "https://dev-apim.azure-api.net", "virtual_network_configuration": [{"subnet_id": "/s/exp-snet"}]}}]},
+		{"module": "module.exp.module.apim", "mode": "managed", "type": "azurerm_private_dns_a_record", "name": "apim_a_record",
+		 "instances": [{"attributes": {"name": "dev-apim", "zone_name": "azure-api.net", "records": ["10.110.20.5"]}}]},
+		{"module": "module.exp.module.apim", "mode": "managed", "type": "azurerm_private_dns_a_record", "name": "apim_dns_records",
+		 "instances": [
+		   {"index_key": "portal", "attributes": {"name": "dev-apim.portal", "zone_name": "azure-api.net", "records": ["10.110.20.5"]}},
+		   {"index_key": "management", "attributes": {"name": "dev-apim.management", "zone_name": "azure-api.net", "records": ["10.110.20.4"]}}
+		 ]}
+	]
+}`
+}
+
+const apimDenyAllInbound = `,
+		   {"index_key": "deny_all", "attributes": {"name": "deny_all", "resource_group_name": "spk-rg", "network_security_group_name": "exp-nsg",
+		     "priority": 4000, "direction": "Inbound", "access": "Deny", "protocol": "*", "source_port_range": "*", "destination_port_range": "*",
+		     "source_address_prefix": "*", "destination_address_prefix": "*"}}`
+
+func TestAPIMInternalChecks(t *testing.T) {
+	tfState, err := parseState([]byte(apimInternalState("")))
+	require.NoError(t, err)
+
+	assert.True(t, checkAPIMNetworkType(expAPIMs(tfState), nil).Pass, "Internal is the default expectation")
+	res := checkAPIMNetworkType(expAPIMs(tfState), &Expectations{APIMVirtualNetworkType: "External"})
+	assert.False(t, res.Pass)
+	assert.Equal(t, `virtual_network_type is "Internal", expected "External"`, res.Instances[0].Message)
+
+	res = checkAPIMSubnet(tfState)
+	assert.True(t, res.Pass, res.Message)
+	assert.Equal(t, "injected into module.spoke.module.subnet_exp.azurerm_subnet.subnet", res.Instances[0].Message)
+
+	res = checkAPIMSubnetNSG(tfState)
+	assert.True(t, res.Pass, res.Message)
+	assert.Equal(t, "ApiManagement -> Tcp/3443: Allow rule apim_management (priority 100); "+
+		"AzureLoadBalancer -> Tcp/6390: Allow rule AllowAzureLoadBalancerInBound (priority 65001)", res.Instances[0].Message)
+
+	res = checkAPIMDNSRecords(tfState)
+	assert.False(t, res.Pass)
+	assert.Equal(t, "module.exp.module.apim.azurerm_private_dns_a_record.apim_dns_records[\"management\"] (dev-apim.management.azure-api.net) points at [10.110.20.4], expected [10.110.20.5]",
+		res.Instances[0].Message)
+}
+
+func TestAPIMSubnetNSGBlockingProbes(t *testing.T) {
+	tfState, err := parseState([]byte(apimInternalState(apimDenyAllInbound)))
+	require.NoError(t, err)
+
+	res := checkAPIMSubnetNSG(tfState)
+	assert.False(t, res.Pass)
+	assert.Equal(t, "NSG spk-rg/exp-nsg blocks AzureLoadBalancer -> Tcp/6390: Deny rule deny_all (priority 4000)", res.Instances[0].Message)
+}
+
+func TestAPIMSubnetMustBeExp(t *testing.T) {
+	tfState, err := parseState([]byte(strings.Replace(apimInternalState(""), `[{"subnet_id": "/s/exp-snet"}]`, `[{"subnet_id": "/S/SYS-SNET"}]`, 1)))
+	require.NoError(t, err)
+
+	res := checkAPIMSubnet(tfState)
+	assert.False(t, res.Pass)
+	assert.Equal(t, "subnet_id resolves to module.spoke.module.subnet_sys.azurerm_subnet.subnet, expected module.spoke.module.subnet_exp", res.Instances[0].Message)
+	res = checkAPIMSubnetNSG(tfState)
+	assert.False(t, res.Pass)
+	assert.Equal(t, "module.spoke.module.subnet_sys.azurerm_subnet.subnet has no NSG; APIM in a VNet needs one admitting ApiManagement and AzureLoadBalancer",
+		res.Instances[0].Message, "the NSG check follows the subnet APIM is actually injected into")
+}
@@ -1,110 +1,98 @@
 package test
 
-import "strings"
+import (
+	"fmt"
+	"strings"
+)
 
-func RunBastionTests(tfState map[string]interface{}) []TestCase {
+func RunBastionTests(tfState *State, exp *Expectations) []TestCase {
 	tests := []GenericTest{
-		{"1._Verify_Spoke_VNet", "BastionInfraTests", func() (bool, string) {
+		{"1._Verify_Spoke_VNet", "BastionInfraTests", func() CheckResult {
 			for _, vnet := range findResourcesByType(tfState, "azurerm_virtual_network") {
 				if name, _ := vnet["name"].(string); strings.Contains(name, "spoke") {
 					if cidrs, ok := vnet["address_space"].([]interface{}); !ok || len(cidrs) == 0 {
-						return false, "Missing CIDR"
-					} else if cidr, _ := cidrs[0].(string); cidr != "10.110.0.0/16" {
-						return false, "Incorrect CIDR: " + cidr
+						return fail("Missing CIDR")
+					} else if cidr, _ := cidrs[0].(string); cidr != exp.SpokeVNetCIDR {
+						return fail("Incorrect CIDR: " + cidr)
 					}
-					return true, ""
+					return pass(1)
 				}
 			}
-			return false, "Spoke VNet not found"
+			return fail("Spoke VNet not found")
 		}},
-		{"2._Verify_Subnets_with_correct_CIDRs", "BastionInfraTests", func() (bool, string) {
-			for _, sn := range findResourcesByType(tfState, "azurerm_subnet") {
-				if cidrs, ok := sn["address_prefixes"].([]interface{}); !ok || len(cidrs) == 0 {
+		{"2._Verify_Subnets_with_correct_CIDRs", "BastionInfraTests", func() CheckResult {
+			return eachInstance(tfState.InstancesByType("azurerm_subnet"), func(ri ResourceInstance) CheckResult {
+				sn := ri.Attributes
+				cidrs, ok := sn["address_prefixes"].([]interface{})
+				if !ok || len(cidrs) == 0 {
 					name, _ := sn["name"].(string)
-					return false, "Subnet " + name + " missing CIDRs"
+					return fail("Subnet " + name + " missing CIDRs")
 				}
-			}
-			return true, ""
+				if want, ok := exp.SubnetCIDR(ri); ok && cidrs[0] != want {
+					return fail(fmt.Sprintf("Subnet has CIDR %v, expected %s", cidrs[0], want))
+				}
+				return pass(1)
+			})
 		}},
-		{"3._Verify_NSG_and_Rules", "BastionInfraTests", func() (bool, string) {
-			for _, nsg := range findResourcesByType(tfState, "azurerm_network_security_group") {
+		{"3._Verify_NSG_and_Rules", "BastionInfraTests", func() CheckResult {
+			return eachInstance(tfState.InstancesByType("azurerm_network_security_group"), func(ri ResourceInstance) CheckResult {
+				nsg := ri.Attributes
 				if rules, ok := nsg["security_rule"].([]interface{}); !ok || len(rules) == 0 {
 					name, _ := nsg["name"].(string)
-					return false, "NSG " + name + " has no rules"
+					return fail("NSG " + name + " has no rules")
 				}
-			}
-			return true, ""
+				return pass(1)
+			})
 		}},
-		{"4._Verify_Bastion_VM_Public_IP_and_Admin_User", "BastionInfraTests", func() (bool, string) {
-			for _, vm := range findResourcesByType(tfState, "azurerm_virtual_machine") {
-				name, _ := vm["name"].(string)
-				if strings.Contains(name, "bastion") {
-					if vm["public_ip_address"] == "" {
-						return false, "No public IP"
-					}
-					if vm["admin_username"] == "" {
-						return false, "No admin user"
-					}
+		// No public IP is required: the posture and reachability suites keep the bastion private
+		{"4._Verify_Bastion_VM_Admin_User", "BastionInfraTests", func() CheckResult {
+			return eachInstance(findModuleInstancesByType(tfState, "module.bastion", "azurerm_windows_virtual_machine"), func(ri ResourceInstance) CheckResult {
+				if user, _ := ri.Attr().String("admin_username"); user == "" {
+					return fail("No admin user on Bastion VM")
 				}
-			}
-			return true, ""
+				return pass(1)
+			})
 		}},
-		{"5._Verify_APIM_internal_network_and_DNS", "BastionInfraTests", func() (bool, string) {
-			apims := findResourcesByType(tfState, "azurerm_api_management")
-			for _, apim := range apims {
-				instances, ok := apim["instances"].([]interface{})
-				if !ok {
-					continue
+		{"5._Verify_APIM_internal_network_and_DNS", "BastionInfraTests", func() CheckResult {
+			return eachInstance(expAPIMs(tfState), func(ri ResourceInstance) CheckResult {
+				if problem := apimNetworkTypeProblem(ri, exp); problem != "" {
+					return fail(problem)
 				}
-				for _, inst := range instances {
-					attrs, ok := inst.(map[string]interface{})["attributes"].(map[string]interface{})
-					if !ok {
-						continue
-					}
-
-					internal, _ := attrs["internal"].(bool)
-
-					// The field might be named differently: "gateway_url" or "hostname_configuration"
-					dns, _ := attrs["gateway_url"].(string)
-
-					if !internal || dns == "" {
-						return false, "APIM not internal or missing DNS"
-					}
+				if dns, _ := ri.Attr().String("gateway_url"); dns == "" {
+					return fail("APIM has no DNS name")
 				}
-			}
-			return true, ""
+				return pass(1)
+			})
 		}},
-		{"6._Verify_VM_Size", "BastionInfraTests", func() (bool, string) {
-			for _, vm := range findResourcesByType(tfState, "azurerm_virtual_machine") {
-				if size, _ := vm["vm_size"].(string); size != "Standard_DS3_v2" {
-					return false, "Wrong VM size: " + size
+		{"6._Verify_VM_Size", "BastionInfraTests", func() CheckResult {
+			return eachInstance(tfState.InstancesByType("azurerm_windows_virtual_machine"), func(ri ResourceInstance) CheckResult {
+				vm := ri.Attributes
+				if size, _ := vm["size"].(string); size != exp.VMSize {
+					return fail("Wrong VM size: " + size)
 				}
-			}
-			return true, ""
+				return pass(1)
+			})
 		}},
-		{"7._Verify_Storage_Account_Replication", "BastionInfraTests", func() (bool, string) {
-			for _, sa := range findResourcesByType(tfState, "azurerm_storage_account") {
-				if tier, _ := sa["account_tier"].(string); tier != "Standard" {
-					return false, "Non-standard tier: " + tier
+		{"7._Verify_Storage_Account_Replication", "BastionInfraTests", func() CheckResult {
+			return eachInstance(tfState.InstancesByType("azurerm_storage_account"), func(ri ResourceInstance) CheckResult {
+				sa := ri.Attributes
+				if tier, _ := sa["account_tier"].(string); tier != exp.StorageAccountTier {
+					return fail("Non-standard tier: " + tier)
 				}
-			}
-			return true, ""
+				return pass(1)
+			})
 		}},
-		{"8._Verify_Managed_Identity", "BastionInfraTests", func() (bool, string) {
-			for _, id := range findResourcesByType(tfState, "azurerm_managed_identity") {
-				if id["client_id"] == nil {
-					return false, "Missing client ID"
-				}
-			}
-			return true, ""
+		{"8._Verify_Managed_Identity", "BastionInfraTests", func() CheckResult {
+			return eachInstance(findInstancesByTypes(tfState, identityTypes...), checkManagedIdentity)
 		}},
-		{"9._Verify_App_Service_Plan_Location", "BastionInfraTests", func() (bool, string) {
-			for _, plan := range findResourcesByType(tfState, "azurerm_app_service_plan") {
-				if loc, _ := plan["location"].(string); loc != "East US" {
-					return false, "Wrong location: " + loc
+		{"9._Verify_App_Service_Plan_Location", "BastionInfraTests", func() CheckResult {
+			return eachInstance(tfState.InstancesByType("azurerm_service_plan"), func(ri ResourceInstance) CheckResult {
+				plan := ri.Attributes
+				if loc, _ := plan["location"].(string); !sameLocation(loc, exp.Region) {
+					return fail("Wrong location: " + loc)
 				}
-			}
-			return true, ""
+				return pass(1)
+			})
 		}},
 	}
 
@@ -0,0 +1,244 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Prefix length the capacity report suggests free blocks for when the environment asks for none
+const defaultCapacityPrefixLength = 24
+
+// Free blocks of each requested size listed per VNet
+const nextFreeCount = 3
+
+// VNetCapacity is the address plan of one VNet: what is allocated, what is
+// left and where the next subnets of a given size would go
+type VNetCapacity struct {
+	VNet           string              `json:"vnet"`
+	Source         string              `json:"source"` // "state", a related state's name, "main.tf" or "expectations"
+	AddressSpace   []string            `json:"address_space"`
+	Subnets        []AllocatedSubnet   `json:"subnets"`
+	TotalAddresses float64             `json:"total_addresses"`
+	Allocated      float64             `json:"allocated_addresses"`
+	Utilization    float64             `json:"utilization_percent"`
+	FreeBlocks     []string            `json:"free_blocks"`           // maximal aligned free prefixes in address order
+	Fragmentation  float64             `json:"fragmentation_percent"` // share of free space outside the largest free block
+	NextFree       map[string][]string `json:"next_free"`             // keyed by "/24"
+}
+
+// AllocatedSubnet is one subnet prefix carved from a VNet
+type AllocatedSubnet struct {
+	Name   string `json:"name"`
+	Prefix string `json:"prefix"`
+}
+
+// Capacity of every VNet in a state, with its subnets; source names the state in the report
+func capacityFromState(tfState *State, source string, lengths []int) []VNetCapacity {
+	bySubnetVNet := map[string][]AllocatedSubnet{}
+	for _, s := range subnetsOf(tfState) {
+		for _, p := range s.prefixes {
+			bySubnetVNet[s.vnet] = append(bySubnetVNet[s.vnet], AllocatedSubnet{Name: s.Address(), Prefix: p.String()})
+		}
+	}
+	var report []VNetCapacity
+	for _, v := range vnetsOf(tfState, source) {
+		report = append(report, newVNetCapacity(v.address, source, v.space, bySubnetVNet[v.key], lengths))
+	}
+	return report
+}
+
+// Capacity of the spoke VNet as planned: spoke_vnet_cidr and the subnet CIDRs,
+// which come from the main.tf module inputs when the environment has one
+func capacityFromExpectations(exp *Expectations) []VNetCapacity {
+	if exp == nil || exp.SpokeVNetCIDR == "" {
+		return nil
+	}
+	source := "expectations"
+	if exp.Config != nil {
+		source = "main.tf"
+	}
+	var subnets []AllocatedSubnet
+	for key, prefix := range exp.SubnetCIDRs {
+		subnets = append(subnets, AllocatedSubnet{Name: "module.spoke.module.subnet_" + key, Prefix: prefix})
+	}
+	space := parsePrefixes([]string{exp.SpokeVNetCIDR})
+	return []VNetCapacity{newVNetCapacity("module.spoke (spoke_vnet_cidr)", source, space, subnets, exp.capacityPrefixLengths())}
+}
+
+func newVNetCapacity(name, source string, space []netip.Prefix, subnets []AllocatedSubnet, lengths []int) VNetCapacity {
+	sort.Slice(subnets, func(i, j int) bool {
+		a, _ := parsePrefix(subnets[i].Prefix)
+		b, _ := parsePrefix(subnets[j].Prefix)
+		return a.Addr().Less(b.Addr())
+	})
+	allocated := parsePrefixes(prefixStrings(subnets))
+
+	c := VNetCapacity{VNet: name, Source: source, Subnets: subnets, NextFree: map[string][]string{}}
+	var free []netip.Prefix
+	for _, p := range space {
+		c.AddressSpace = append(c.AddressSpace, p.String())
+		c.TotalAddresses += prefixSize(p)
+		free = append(free, freeBlocks(p, allocated)...)
+	}
+	var freeTotal, largest float64
+	for _, f := range free {
+		c.FreeBlocks = append(c.FreeBlocks, f.String())
+		freeTotal += prefixSize(f)
+		largest = math.Max(largest, prefixSize(f))
+	}
+	c.Allocated = c.TotalAddresses - freeTotal
+	if c.TotalAddresses > 0 {
+		c.Utilization = round1(100 * c.Allocated / c.TotalAddresses)
+	}
+	if freeTotal > 0 {
+		c.Fragmentation = round1(100 * (1 - largest/freeTotal))
+	}
+	for _, bits := range lengths {
+		var next []string
+		for _, p := range nextFree(free, bits, nextFreeCount) {
+			next = append(next, p.String())
+		}
+		c.NextFree[fmt.Sprintf("/%d", bits)] = next
+	}
+	return c
+}
+
+func prefixStrings(subnets []AllocatedSubnet) []string {
+	var out []string
+	for _, s := range subnets {
+		out = append(out, s.Prefix)
+	}
+	return out
+}
+
+func round1(f float64) float64 {
+	return math.Round(f*10) / 10
+}
+
+func prefixSize(p netip.Prefix) float64 {
+	return math.Ldexp(1, p.Addr().BitLen()-p.Bits())
+}
+
+// Maximal aligned prefixes inside p that no allocated prefix touches, in address order
+func freeBlocks(p netip.Prefix, allocated []netip.Prefix) []netip.Prefix {
+	touched := false
+	for _, a := range allocated {
+		if a.Bits() <= p.Bits() && a.Contains(p.Addr()) {
+			return nil // fully allocated
+		}
+		touched = touched || p.Overlaps(a)
+	}
+	if !touched {
+		return []netip.Prefix{p}
+	}
+	lo, hi := splitPrefix(p)
+	return append(freeBlocks(lo, allocated), freeBlocks(hi, allocated)...)
+}
+
+// The two halves of a prefix
+func splitPrefix(p netip.Prefix) (netip.Prefix, netip.Prefix) {
+	bits := p.Bits() + 1
+	lo := netip.PrefixFrom(p.Addr(), bits)
+	b := p.Addr().AsSlice()
+	b[p.Bits()/8] |= 0x80 >> (p.Bits() % 8)
+	addr, _ := netip.AddrFromSlice(b)
+	return lo, netip.PrefixFrom(addr, bits)
+}
+
+// The first n free prefixes of a given length, lowest address first
+func nextFree(free []netip.Prefix, bits, n int) []netip.Prefix {
+	var out []netip.Prefix
+	for _, f := range free {
+		if f.Bits() > bits {
+			continue
+		}
+		queue := []netip.Prefix{f}
+		for len(queue) > 0 && len(out) < n {
+			p := queue[0]
+			queue = queue[1:]
+			if p.Bits() == bits {
+				out = append(out, p)
+				continue
+			}
+			lo, hi := splitPrefix(p)
+			queue = append([]netip.Prefix{lo, hi}, queue...)
+		}
+		if len(out) >= n {
+			break
+		}
+	}
+	return out
+}
+
+// Human-readable form of the report, one block per VNet
+func formatCapacity(report []VNetCapacity) string {
+	var b strings.Builder
+	for _, c := range report {
+		fmt.Fprintf(&b, "%s (%s)\n", c.VNet, c.Source)
+		fmt.Fprintf(&b, "  address space: %s (%.0f addresses)\n", strings.Join(c.AddressSpace, ", "), c.TotalAddresses)
+		fmt.Fprintf(&b, "  subnets: %d, %.0f addresses allocated (%.1f%%)\n", len(c.Subnets), c.Allocated, c.Utilization)
+		for _, s := range c.Subnets {
+			fmt.Fprintf(&b, "    %-18s %s\n", s.Prefix, s.Name)
+		}
+		largest := "none"
+		if len(c.FreeBlocks) > 0 {
+			largest = largestBlock(c.FreeBlocks)
+		}
+		fmt.Fprintf(&b, "  free: %.0f addresses in %d block(s), largest %s, fragmentation %.1f%%\n",
+			c.TotalAddresses-c.Allocated, len(c.FreeBlocks), largest, c.Fragmentation)
+		sizes := make([]string, 0, len(c.NextFree))
+		for size := range c.NextFree {
+			sizes = append(sizes, size)
+		}
+		sort.Strings(sizes)
+		for _, size := range sizes {
+			next := "none left"
+			if len(c.NextFree[size]) > 0 {
+				next = strings.Join(c.NextFree[size], ", ")
+			}
+			fmt.Fprintf(&b, "  next free %s: %s\n", size, next)
+		}
+	}
+	return b.String()
+}
+
+func largestBlock(blocks []string) string {
+	best := blocks[0]
+	for _, s := range blocks[1:] {
+		p, _ := parsePrefix(s)
+		q, _ := parsePrefix(best)
+		if p.Bits() < q.Bits() {
+			best = s
+		}
+	}
+	return best
+}
+
+// Write the report as JSON for tooling and as text for people, next to the JUnit reports
+func writeCapacityReport(report []VNetCapacity, dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "address_capacity.json"), append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "address_capacity.txt"), []byte(formatCapacity(report)), 0644)
+}
+
+// Sizes of the next free prefixes to report, e.g. [24 27]
+func (e *Expectations) capacityPrefixLengths() []int {
+	if e == nil || len(e.CapacityPrefixLengths) == 0 {
+		return []int{defaultCapacityPrefixLength}
+	}
+	return e.CapacityPrefixLengths
+}
@@ -0,0 +1,81 @@
+package test
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The Dev spoke as main.tf plans it
+var devSpoke = &Expectations{
+	SpokeVNetCIDR: "10.110.0.0/16",
+	SubnetCIDRs: map[string]string{
+		"bastion": "10.110.10.0/24", "exp": "10.110.20.0/24", "proc": "10.110.30.0/24", "procfapp": "10.110.31.0/24",
+		"sys": "10.110.40.0/24", "sysfapp": "10.110.41.0/24", "srcs": "10.110.50.0/24", "epp": "10.110.60.0/24",
+	},
+	CapacityPrefixLengths: []int{24, 26},
+}
+
+func TestCapacityFromExpectations(t *testing.T) {
+	report := capacityFromExpectations(devSpoke)
+	require.Len(t, report, 1)
+	c := report[0]
+
+	assert.Equal(t, "expectations", c.Source)
+	assert.Equal(t, []string{"10.110.0.0/16"}, c.AddressSpace)
+	assert.Equal(t, float64(65536), c.TotalAddresses)
+	assert.Equal(t, float64(8*256), c.Allocated)
+	assert.Equal(t, 3.1, c.Utilization)
+	assert.Equal(t, "10.110.10.0/24", c.Subnets[0].Prefix, "subnets are listed in address order")
+	assert.Equal(t, []string{"10.110.0.0/24", "10.110.1.0/24", "10.110.2.0/24"}, c.NextFree["/24"])
+	assert.Equal(t, []string{"10.110.0.0/26", "10.110.0.64/26", "10.110.0.128/26"}, c.NextFree["/26"])
+	assert.Contains(t, c.FreeBlocks, "10.110.128.0/17")
+	assert.Equal(t, "10.110.0.0/21", c.FreeBlocks[0])
+	assert.InDelta(t, 48.4, c.Fragmentation, 0.1, "half of the free space lies outside 10.110.128.0/17")
+}
+
+func TestFreeBlocks(t *testing.T) {
+	space := netip.MustParsePrefix("10.0.0.0/24")
+	allocated := parsePrefixes([]string{"10.0.0.64/26", "10.0.0.192/27"})
+
+	free := freeBlocks(space, allocated)
+	var got []string
+	for _, p := range free {
+		got = append(got, p.String())
+	}
+	assert.Equal(t, []string{"10.0.0.0/26", "10.0.0.128/26", "10.0.0.224/27"}, got)
+
+	assert.Empty(t, freeBlocks(space, parsePrefixes([]string{"10.0.0.0/16"})), "a subnet covering the space leaves nothing")
+	assert.Empty(t, nextFree(free, 25, 1), "no aligned /25 is free")
+	assert.Equal(t, []netip.Prefix{netip.MustParsePrefix("10.0.0.224/27")}, nextFree(free, 27, 5)[4:])
+}
+
+func TestCapacityFromState(t *testing.T) {
+	tfState, err := parseState([]byte(ipamState))
+	require.NoError(t, err)
+
+	report := capacityFromState(tfState, "state", []int{24})
+	require.Len(t, report, 1)
+	c := report[0]
+	assert.Equal(t, "module.spoke.module.spoke_vnet.azurerm_virtual_network.vnet", c.VNet)
+	assert.Len(t, c.Subnets, 4)
+	// 10.111.30.0/24 lies outside the VNet and 10.110.20.128/25 inside exp's /24, so two /24s are used
+	assert.Equal(t, float64(512), c.Allocated)
+	assert.Equal(t, []string{"10.110.0.0/24"}, c.NextFree["/24"][:1])
+}
+
+func TestWriteCapacityReport(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, writeCapacityReport(capacityFromExpectations(devSpoke), dir))
+
+	text, err := os.ReadFile(filepath.Join(dir, "address_capacity.txt"))
+	require.NoError(t, err)
+	assert.Contains(t, string(text), "next free /24: 10.110.0.0/24, 10.110.1.0/24, 10.110.2.0/24")
+	assert.True(t, strings.HasPrefix(string(text), "module.spoke (spoke_vnet_cidr) (expectations)\n"))
+	assert.FileExists(t, filepath.Join(dir, "address_capacity.json"))
+}
@@ -0,0 +1,339 @@
+// Package config loads the settings for the infrastructure checks in layers:
+// built-in defaults, a JSON config file, a named profile from that file,
+// environment variables and finally command-line flags. It does not depend on
+// the testing package, so go test and a standalone CLI load settings the same way.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Config file read when neither -config nor TEST_CONFIG names one
+const DefaultPath = "config.json"
+
+// Config is the fully layered configuration
+type Config struct {
+	Profile        string     `json:"-"` // name of the profile applied, if any
+	Environment    string     `json:"environment,omitempty"`
+	RemoteStateURL string     `json:"remote_state_url,omitempty"`
+	StateFile      string     `json:"state_file,omitempty"`
+	PlanFile       string     `json:"plan_file,omitempty"`
+	Backend        *Backend   `json:"backend,omitempty"`
+	Expectations   string     `json:"expectations,omitempty"` // defaults to expectations/<environment>.json
+	Thresholds     Thresholds `json:"thresholds,omitempty"`
+	RelatedStates  []StateRef `json:"related_states,omitempty"` // e.g. the hub, for checks spanning states
+	Warnings       []string   `json:"-"`                        // e.g. a SAS token close to never expiring
+}
+
+// StateRef names another state some checks read alongside the environment's own,
+// such as the hub VNet's. Exactly one of the sources is set.
+type StateRef struct {
+	Name           string   `json:"name"`
+	StateFile      string   `json:"state_file,omitempty"`
+	RemoteStateURL string   `json:"remote_state_url,omitempty"`
+	Backend        *Backend `json:"backend,omitempty"`
+}
+
+// Backend mirrors the backend block of an environment, e.g. Environments/Dev/backend.tf.
+// Credentials never live here: they come from ARM_* or TF_HTTP_* environment variables.
+type Backend struct {
+	Type               string `json:"type"` // "azurerm" or "http"
+	StorageAccountName string `json:"storage_account_name,omitempty"`
+	ContainerName      string `json:"container_name,omitempty"`
+	Key                string `json:"key,omitempty"`
+	Address            string `json:"address,omitempty"`
+}
+
+// Thresholds fail a run once a report exceeds them; nil means not enforced
+type Thresholds struct {
+	MaxFailures *int `json:"max_failures,omitempty"`
+	MaxErrors   *int `json:"max_errors,omitempty"`
+}
+
+// file is the on-disk layout: top-level settings shared by every profile plus the profiles
+type file struct {
+	Config
+	DefaultProfile string            `json:"default_profile,omitempty"`
+	Profiles       map[string]Config `json:"profiles,omitempty"`
+}
+
+// Flags are the command-line overrides; empty values leave lower layers alone
+type Flags struct {
+	Config         string
+	Profile        string
+	Environment    string
+	RemoteStateURL string
+	StateFile      string
+	PlanFile       string
+	// Accept credentials embedded in the config file, e.g. for a local uncommitted copy
+	AllowCredentials bool
+}
+
+// RegisterFlags defines the configuration flags on fs, e.g. flag.CommandLine
+func RegisterFlags(fs *flag.FlagSet) *Flags {
+	f := &Flags{}
+	fs.StringVar(&f.Config, "config", "", "Config file (default config.json, or TEST_CONFIG)")
+	fs.StringVar(&f.Profile, "profile", "", "Profile from the config file (or TEST_PROFILE; defaults to the environment name)")
+	fs.StringVar(&f.Environment, "env", "", "Environment name (e.g. dev, prod)")
+	fs.StringVar(&f.RemoteStateURL, "remoteStateURL", "", "Remote Terraform state URL")
+	fs.StringVar(&f.StateFile, "stateFile", "", "Local .tfstate or `terraform show -json` output")
+	fs.StringVar(&f.PlanFile, "planFile", "", "Plan JSON from `terraform show -json tfplan.binary`")
+	fs.BoolVar(&f.AllowCredentials, "allowConfigCredentials", false, "Accept SAS signatures or passwords embedded in the config file")
+	return f
+}
+
+// Load layers defaults, the config file, the selected profile, environment
+// variables (read through getenv) and flags, then validates the result.
+// The profile is -profile, else TEST_PROFILE, else the profile named after the
+// environment from -env or TEST_ENV, else (with no environment chosen) the
+// file's default_profile.
+// A config file that embeds credentials is rejected unless flags.AllowCredentials
+// is set; they belong in environment variables, which are registered with Redact.
+func Load(flags *Flags, getenv func(string) string) (*Config, error) {
+	if flags == nil {
+		flags = &Flags{}
+	}
+	path := firstNonEmpty(flags.Config, getenv("TEST_CONFIG"))
+	f, err := readFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if path == "" {
+		path = DefaultPath
+	}
+	if creds := embeddedCredentials(f); len(creds) > 0 && !flags.AllowCredentials {
+		return nil, &CredentialError{Path: path, Settings: creds}
+	}
+	for _, name := range secretEnvVars {
+		RegisterSecrets(getenv(name))
+	}
+
+	cfg := f.Config
+	cfg.Backend = copyBackend(f.Backend)
+
+	profile := firstNonEmpty(flags.Profile, getenv("TEST_PROFILE"))
+	if profile == "" {
+		// A default profile for one environment must not leak into a run against another
+		switch env := firstNonEmpty(flags.Environment, getenv("TEST_ENV")); {
+		case hasProfile(f, env):
+			profile = env
+		case env == "":
+			profile = f.DefaultProfile
+		}
+	}
+	if profile != "" {
+		p, ok := f.Profiles[profile]
+		if !ok {
+			return nil, &UnknownProfileError{Path: path, Profile: profile, Known: profileNames(f)}
+		}
+		cfg.merge(p)
+		cfg.Profile = profile
+	}
+
+	cfg.merge(Config{
+		Environment:    getenv("TEST_ENV"),
+		RemoteStateURL: getenv("TF_REMOTE_STATE_URL"),
+		StateFile:      getenv("TF_STATE_FILE"),
+		PlanFile:       getenv("TF_PLAN_FILE"),
+	})
+	if address := getenv("TF_HTTP_ADDRESS"); address != "" && cfg.Backend != nil && cfg.Backend.Type == "http" {
+		cfg.Backend.Address = address
+	}
+
+	cfg.merge(Config{
+		Environment:    flags.Environment,
+		RemoteStateURL: flags.RemoteStateURL,
+		StateFile:      flags.StateFile,
+		PlanFile:       flags.PlanFile,
+	})
+
+	if cfg.Expectations == "" && cfg.Environment != "" {
+		cfg.Expectations = filepath.Join("expectations", cfg.Environment+".json")
+	}
+
+	if err := cfg.Validate(); err != nil {
+		var verr *ValidationError
+		if errors.As(err, &verr) {
+			verr.Path = path
+		}
+		return nil, err
+	}
+
+	for source, token := range map[string]string{"remote_state_url": cfg.RemoteStateURL, "ARM_SAS_TOKEN": getenv("ARM_SAS_TOKEN")} {
+		if w := sasWarning(source, token); w != "" {
+			cfg.Warnings = append(cfg.Warnings, w)
+		}
+	}
+	if cfg.Backend != nil {
+		if w := sasWarning("backend.address", cfg.Backend.Address); w != "" {
+			cfg.Warnings = append(cfg.Warnings, w)
+		}
+	}
+	for _, ref := range cfg.RelatedStates {
+		if w := sasWarning("related state "+ref.Name, ref.RemoteStateURL); w != "" {
+			cfg.Warnings = append(cfg.Warnings, w)
+		}
+	}
+	sort.Strings(cfg.Warnings)
+	return &cfg, nil
+}
+
+// Read the config file; only the implicit default file may be missing
+func readFile(path string) (*file, error) {
+	f := &file{}
+	explicit := path != ""
+	if !explicit {
+		path = DefaultPath
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && !explicit {
+		return f, nil
+	}
+	if err != nil {
+		return nil, &FileError{Path: path, Err: err}
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(f); err != nil {
+		return nil, &FileError{Path: path, Err: err}
+	}
+	return f, nil
+}
+
+// Overlay the non-empty settings of o
+func (c *Config) merge(o Config) {
+	c.Environment = firstNonEmpty(o.Environment, c.Environment)
+	c.RemoteStateURL = firstNonEmpty(o.RemoteStateURL, c.RemoteStateURL)
+	c.StateFile = firstNonEmpty(o.StateFile, c.StateFile)
+	c.PlanFile = firstNonEmpty(o.PlanFile, c.PlanFile)
+	c.Expectations = firstNonEmpty(o.Expectations, c.Expectations)
+	if o.Backend != nil {
+		c.Backend = copyBackend(o.Backend)
+	}
+	if o.RelatedStates != nil {
+		c.RelatedStates = o.RelatedStates
+	}
+	if o.Thresholds.MaxFailures != nil {
+		c.Thresholds.MaxFailures = o.Thresholds.MaxFailures
+	}
+	if o.Thresholds.MaxErrors != nil {
+		c.Thresholds.MaxErrors = o.Thresholds.MaxErrors
+	}
+}
+
+var environmentName = regexp.MustCompile(`^[a-z][a-z0-9-]*$`)
+
+// Validate checks the layered result against the config schema
+func (c *Config) Validate() error {
+	var problems []string
+	switch {
+	case c.Environment == "":
+		problems = append(problems, "environment is required (config file, TEST_ENV or -env)")
+	case !environmentName.MatchString(c.Environment):
+		problems = append(problems, fmt.Sprintf("environment %q must be lowercase letters, digits and dashes", c.Environment))
+	}
+
+	problems = append(problems, validateBackend("backend", c.Backend)...)
+
+	names := map[string]bool{}
+	for i, ref := range c.RelatedStates {
+		prefix := fmt.Sprintf("related_states[%d]", i)
+		switch {
+		case ref.Name == "":
+			problems = append(problems, prefix+".name is required")
+		case names[ref.Name]:
+			problems = append(problems, fmt.Sprintf("%s.name %q is used twice", prefix, ref.Name))
+		}
+		names[ref.Name] = true
+		sources := 0
+		for _, set := range []bool{ref.StateFile != "", ref.RemoteStateURL != "", ref.Backend != nil} {
+			if set {
+				sources++
+			}
+		}
+		if sources != 1 {
+			problems = append(problems, prefix+" needs exactly one of state_file, remote_state_url or backend")
+		}
+		problems = append(problems, validateBackend(prefix+".backend", ref.Backend)...)
+	}
+
+	if n := c.Thresholds.MaxFailures; n != nil && *n < 0 {
+		problems = append(problems, "thresholds.max_failures must not be negative")
+	}
+	if n := c.Thresholds.MaxErrors; n != nil && *n < 0 {
+		problems = append(problems, "thresholds.max_errors must not be negative")
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+func validateBackend(prefix string, b *Backend) []string {
+	if b == nil {
+		return nil
+	}
+	var problems []string
+	switch b.Type {
+	case "azurerm":
+		for field, value := range map[string]string{
+			"storage_account_name": b.StorageAccountName,
+			"container_name":       b.ContainerName,
+			"key":                  b.Key,
+		} {
+			if value == "" {
+				problems = append(problems, prefix+"."+field+" is required for the azurerm backend")
+			}
+		}
+	case "http":
+		if b.Address == "" {
+			problems = append(problems, prefix+".address is required for the http backend (or TF_HTTP_ADDRESS)")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("%s.type %q must be \"azurerm\" or \"http\"", prefix, b.Type))
+	}
+	return problems
+}
+
+func hasProfile(f *file, name string) bool {
+	_, ok := f.Profiles[name]
+	return name != "" && ok
+}
+
+func profileNames(f *file) []string {
+	var names []string
+	for name := range f.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func copyBackend(b *Backend) *Backend {
+	if b == nil {
+		return nil
+	}
+	c := *b
+	return &c
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if strings.TrimSpace(v) != "" {
+			return v
+		}
+	}
+	return ""
+}
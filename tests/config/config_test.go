@@ -0,0 +1,151 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.json")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	return path
+}
+
+func envFrom(vars map[string]string) func(string) string {
+	return func(name string) string { return vars[name] }
+}
+
+const profiles = `{
+  "plan_file": "shared.json",
+  "default_profile": "dev",
+  "profiles": {
+    "dev":  {"environment": "dev", "backend": {"type": "azurerm", "storage_account_name": "sa", "container_name": "tfstate", "key": "Dev/global.tfstate"}},
+    "prod": {"environment": "prod", "backend": {"type": "http", "address": "https://state.example.com/prod"}, "thresholds": {"max_failures": 0}}
+  }
+}`
+
+func TestLoadLayersFileProfileEnvAndFlags(t *testing.T) {
+	path := writeConfig(t, profiles)
+
+	cfg, err := Load(&Flags{Config: path}, envFrom(nil))
+	require.NoError(t, err)
+	assert.Equal(t, "dev", cfg.Profile, "default_profile applies when no environment is chosen")
+	assert.Equal(t, "Dev/global.tfstate", cfg.Backend.Key)
+	assert.Equal(t, "shared.json", cfg.PlanFile, "top-level settings are shared by every profile")
+	assert.Equal(t, filepath.Join("expectations", "dev.json"), cfg.Expectations)
+	assert.Nil(t, cfg.Thresholds.MaxFailures)
+
+	cfg, err = Load(&Flags{Config: path}, envFrom(map[string]string{"TEST_ENV": "prod", "TF_PLAN_FILE": "env.json"}))
+	require.NoError(t, err)
+	assert.Equal(t, "prod", cfg.Profile, "TEST_ENV picks the matching profile")
+	assert.Equal(t, "http", cfg.Backend.Type)
+	assert.Equal(t, "env.json", cfg.PlanFile, "environment variables override the file")
+	require.NotNil(t, cfg.Thresholds.MaxFailures)
+	assert.Equal(t, 0, *cfg.Thresholds.MaxFailures)
+
+	cfg, err = Load(&Flags{Config: path, PlanFile: "flag.json", StateFile: "local.tfstate"},
+		envFrom(map[string]string{"TF_PLAN_FILE": "env.json", "TF_HTTP_ADDRESS": "https://other.example.com", "TEST_PROFILE": "prod"}))
+	require.NoError(t, err)
+	assert.Equal(t, "flag.json", cfg.PlanFile, "flags override environment variables")
+	assert.Equal(t, "local.tfstate", cfg.StateFile)
+	assert.Equal(t, "https://other.example.com", cfg.Backend.Address)
+}
+
+func TestLoadDoesNotApplyDefaultProfileToAnotherEnvironment(t *testing.T) {
+	cfg, err := Load(&Flags{Config: writeConfig(t, profiles), Environment: "qa"}, envFrom(nil))
+	require.NoError(t, err)
+	assert.Equal(t, "", cfg.Profile)
+	assert.Equal(t, "qa", cfg.Environment)
+	assert.Nil(t, cfg.Backend, "dev's backend must not be used for qa")
+}
+
+func TestLoadTypedErrors(t *testing.T) {
+	var profileErr *UnknownProfileError
+	_, err := Load(&Flags{Config: writeConfig(t, profiles), Profile: "staging"}, envFrom(nil))
+	require.ErrorAs(t, err, &profileErr)
+	assert.Equal(t, []string{"dev", "prod"}, profileErr.Known)
+
+	var fileErr *FileError
+	_, err = Load(&Flags{Config: writeConfig(t, `{"enviroment": "dev"}`)}, envFrom(nil))
+	require.ErrorAs(t, err, &fileErr, "unknown keys are rejected, not ignored")
+	assert.Contains(t, err.Error(), "enviroment")
+
+	_, err = Load(&Flags{Config: writeConfig(t, `{"environment": "dev",`)}, envFrom(nil))
+	require.ErrorAs(t, err, &fileErr, "malformed JSON is an error")
+
+	_, err = Load(&Flags{Config: filepath.Join(t.TempDir(), "missing.json")}, envFrom(nil))
+	require.ErrorAs(t, err, &fileErr)
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+
+	var validationErr *ValidationError
+	_, err = Load(&Flags{Config: writeConfig(t, `{"environment": "Dev", "backend": {"type": "azurerm"}, "thresholds": {"max_errors": -1}}`)}, envFrom(nil))
+	require.ErrorAs(t, err, &validationErr)
+	assert.Len(t, validationErr.Problems, 5)
+	assert.Contains(t, err.Error(), "backend.key is required")
+	assert.Contains(t, err.Error(), `environment "Dev"`)
+}
+
+func TestLoadWithoutConfigFile(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	cfg, err := Load(nil, envFrom(map[string]string{"TEST_ENV": "dev", "TF_STATE_FILE": "x.tfstate"}))
+	require.NoError(t, err, "the default config.json is optional")
+	assert.Equal(t, "x.tfstate", cfg.StateFile)
+
+	var validationErr *ValidationError
+	_, err = Load(nil, envFrom(nil))
+	require.ErrorAs(t, err, &validationErr)
+}
+
+func TestRepositoryConfigProfiles(t *testing.T) {
+	cfg, err := Load(&Flags{Config: "../config.json", Environment: "dev"}, envFrom(nil))
+	require.NoError(t, err)
+	assert.Equal(t, "dev", cfg.Profile)
+	assert.Equal(t, "azurerm", cfg.Backend.Type)
+
+	// Only Dev has Terraform, a state and expectations so far
+	var profileErr *UnknownProfileError
+	_, err = Load(&Flags{Config: "../config.json", Profile: "qa"}, envFrom(nil))
+	require.ErrorAs(t, err, &profileErr)
+	assert.Equal(t, []string{"dev"}, profileErr.Known)
+}
+
+func TestLoadRelatedStates(t *testing.T) {
+	cfg, err := Load(&Flags{Config: writeConfig(t, `{
+  "environment": "dev",
+  "related_states": [
+    {"name": "hub", "backend": {"type": "azurerm", "storage_account_name": "sa", "container_name": "tfstate", "key": "Hub/global.tfstate"}},
+    {"name": "shared", "state_file": "testdata/shared.tfstate"}
+  ]
+}`)}, envFrom(nil))
+	require.NoError(t, err)
+	require.Len(t, cfg.RelatedStates, 2)
+	assert.Equal(t, "Hub/global.tfstate", cfg.RelatedStates[0].Backend.Key)
+
+	var validationErr *ValidationError
+	_, err = Load(&Flags{Config: writeConfig(t, `{
+  "environment": "dev",
+  "related_states": [
+    {"name": "hub", "state_file": "a.tfstate", "remote_state_url": "https://state.example.com/hub"},
+    {"name": "hub", "backend": {"type": "http"}},
+    {"state_file": "b.tfstate"}
+  ]
+}`)}, envFrom(nil))
+	require.ErrorAs(t, err, &validationErr)
+	assert.Equal(t, []string{
+		"related_states[0] needs exactly one of state_file, remote_state_url or backend",
+		"related_states[1].backend.address is required for the http backend (or TF_HTTP_ADDRESS)",
+		`related_states[1].name "hub" is used twice`,
+		"related_states[2].name is required",
+	}, validationErr.Problems)
+
+	var credErr *CredentialError
+	_, err = Load(&Flags{Config: writeConfig(t, `{"environment": "dev", "related_states": [{"name": "hub", "remote_state_url": "https://a.blob.core.windows.net/c/hub?sig=abc"}]}`)}, envFrom(nil))
+	require.ErrorAs(t, err, &credErr)
+	assert.Equal(t, []string{"related_states[0].remote_state_url (sig)"}, credErr.Settings)
+}
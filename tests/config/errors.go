@@ -0,0 +1,53 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// FileError reports a config file that could not be read or is not valid JSON
+// for the schema, including unknown keys
+type FileError struct {
+	Path string
+	Err  error
+}
+
+func (e *FileError) Error() string {
+	return fmt.Sprintf("config file %s: %v", e.Path, e.Err)
+}
+
+func (e *FileError) Unwrap() error {
+	return e.Err
+}
+
+// ValidationError lists every problem found in the layered configuration
+type ValidationError struct {
+	Path     string
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid configuration (%s): %s", e.Path, strings.Join(e.Problems, "; "))
+}
+
+// UnknownProfileError reports a profile that the config file does not define
+type UnknownProfileError struct {
+	Path    string
+	Profile string
+	Known   []string
+}
+
+func (e *UnknownProfileError) Error() string {
+	return fmt.Sprintf("profile %q is not defined in %s (known: %s)", e.Profile, e.Path, strings.Join(e.Known, ", "))
+}
+
+// CredentialError reports secrets committed to a config file; the values are never included
+type CredentialError struct {
+	Path     string
+	Settings []string
+}
+
+func (e *CredentialError) Error() string {
+	return fmt.Sprintf("config file %s embeds credentials in %s; pass them through TF_REMOTE_STATE_URL, ARM_SAS_TOKEN or TF_HTTP_PASSWORD instead, or use -allowConfigCredentials for an uncommitted local file",
+		e.Path, strings.Join(e.Settings, ", "))
+}
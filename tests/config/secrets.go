@@ -0,0 +1,185 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SAS tokens valid for longer than this are reported; issue short-lived, read-only tokens
+const MaxSASLifetime = 30 * 24 * time.Hour
+
+// Clock for SAS expiry checks, replaced in tests
+var now = time.Now
+
+// Query parameters that carry credentials in SAS and OAuth URLs
+var secretQueryParams = []string{"sig", "signature", "code", "token", "access_token", "client_secret", "password"}
+
+// Credentials in free text: key=value pairs from URLs and connection strings,
+// JSON string fields, bearer tokens and passwords in URL userinfo
+var secretPatterns = []struct {
+	re   *regexp.Regexp
+	repl string
+}{
+	{regexp.MustCompile(`(?i)\b(sig|signature|access_token|client_secret|password|passwd|pwd|token|accountkey|sharedaccesskey)=[^&\s;,"'<>]+`), "${1}=REDACTED"},
+	{regexp.MustCompile(`(?i)("(?:[a-z_]*password|client_secret|access_key|primary_key|secondary_key|[a-z_]*connection_string|sas_token)"\s*:\s*)"[^"]*"`), `${1}"REDACTED"`},
+	{regexp.MustCompile(`(?i)\bbearer\s+[a-z0-9._~+/-]+=*`), "Bearer REDACTED"},
+	{regexp.MustCompile(`(://[^/\s:@]+):[^@\s/]+@`), "${1}:REDACTED@"},
+}
+
+// Secret values from the environment, masked wherever they appear
+var known struct {
+	sync.RWMutex
+	values []string
+}
+
+// Environment variables whose values are credentials
+var secretEnvVars = []string{"ARM_CLIENT_SECRET", "ARM_ACCESS_KEY", "ARM_SAS_TOKEN", "TF_HTTP_PASSWORD"}
+
+// RegisterSecrets makes Redact mask these exact values, e.g. a client secret
+// that shows up in an error without a recognizable key in front of it
+func RegisterSecrets(values ...string) {
+	known.Lock()
+	defer known.Unlock()
+	for _, v := range values {
+		// Very short values would mask unrelated text
+		if len(strings.TrimSpace(v)) >= 8 {
+			known.values = append(known.values, v)
+		}
+	}
+	// Longest first so a token is masked whole, not around a registered substring
+	sort.Slice(known.values, func(i, j int) bool { return len(known.values[i]) > len(known.values[j]) })
+}
+
+// Redact masks credentials in a log line, report message or property value
+func Redact(text string) string {
+	known.RLock()
+	for _, v := range known.values {
+		text = strings.ReplaceAll(text, v, "REDACTED")
+	}
+	known.RUnlock()
+	for _, p := range secretPatterns {
+		text = p.re.ReplaceAllString(text, p.repl)
+	}
+	return text
+}
+
+// RedactURL masks SAS signatures, tokens and userinfo passwords in a URL, keeping the rest readable
+func RedactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme == "" {
+		return Redact(raw)
+	}
+	if _, hasPassword := u.User.Password(); hasPassword {
+		u.User = url.UserPassword(u.User.Username(), "REDACTED")
+	}
+	query := u.Query()
+	for key := range query {
+		if isSecretParam(key) {
+			query.Set(key, "REDACTED")
+		}
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// Names of the credentials a URL carries: secret query parameters and a userinfo password
+func urlCredentials(raw string) []string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil
+	}
+	var found []string
+	if _, hasPassword := u.User.Password(); hasPassword {
+		found = append(found, "password")
+	}
+	for key := range u.Query() {
+		if isSecretParam(key) {
+			found = append(found, key)
+		}
+	}
+	sort.Strings(found)
+	return found
+}
+
+func isSecretParam(key string) bool {
+	for _, secret := range secretQueryParams {
+		if strings.EqualFold(key, secret) {
+			return true
+		}
+	}
+	return false
+}
+
+// Settings of the config file that embed credentials, e.g. "profiles.dev.remote_state_url (sig)"
+func embeddedCredentials(f *file) []string {
+	var found []string
+	check := func(prefix string, c Config) {
+		if creds := urlCredentials(c.RemoteStateURL); len(creds) > 0 {
+			found = append(found, fmt.Sprintf("%sremote_state_url (%s)", prefix, strings.Join(creds, ", ")))
+		}
+		if c.Backend != nil {
+			if creds := urlCredentials(c.Backend.Address); len(creds) > 0 {
+				found = append(found, fmt.Sprintf("%sbackend.address (%s)", prefix, strings.Join(creds, ", ")))
+			}
+		}
+		for i, ref := range c.RelatedStates {
+			if creds := urlCredentials(ref.RemoteStateURL); len(creds) > 0 {
+				found = append(found, fmt.Sprintf("%srelated_states[%d].remote_state_url (%s)", prefix, i, strings.Join(creds, ", ")))
+			}
+			if ref.Backend != nil {
+				if creds := urlCredentials(ref.Backend.Address); len(creds) > 0 {
+					found = append(found, fmt.Sprintf("%srelated_states[%d].backend.address (%s)", prefix, i, strings.Join(creds, ", ")))
+				}
+			}
+		}
+	}
+	check("", f.Config)
+	for _, name := range profileNames(f) {
+		check("profiles."+name+".", f.Profiles[name])
+	}
+	return found
+}
+
+// Warn about a SAS token that has already expired or stays valid for too long.
+// token is either a bare SAS query string or a URL carrying one.
+func sasWarning(source, token string) string {
+	query := token
+	if u, err := url.Parse(token); err == nil && u.Scheme != "" {
+		query = u.RawQuery
+	}
+	params, err := url.ParseQuery(strings.TrimPrefix(query, "?"))
+	if err != nil || params.Get("sig") == "" {
+		return ""
+	}
+	se := params.Get("se")
+	if se == "" {
+		return fmt.Sprintf("SAS token in %s has no expiry (se)", source)
+	}
+	expiry, err := parseSASTime(se)
+	if err != nil {
+		return fmt.Sprintf("SAS token in %s has an unreadable expiry se=%s", source, se)
+	}
+	switch remaining := expiry.Sub(now()); {
+	case remaining <= 0:
+		return fmt.Sprintf("SAS token in %s expired on %s", source, expiry.Format(time.RFC3339))
+	case remaining > MaxSASLifetime:
+		return fmt.Sprintf("SAS token in %s is valid until %s, more than %d days from now; issue a short-lived token",
+			source, expiry.Format(time.RFC3339), int(MaxSASLifetime.Hours()/24))
+	}
+	return ""
+}
+
+// SAS times are ISO 8601 UTC, with or without the time of day
+func parseSASTime(s string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04Z", "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized SAS time %q", s)
+}
@@ -0,0 +1,250 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Catalog of resource types that support diagnostic settings, with the log
+// categories and metrics each must send to the environment's workspace
+const diagnosticsCatalogPath = "diagnostics/catalog.json"
+
+// DiagnosticsCatalog lists, per resource type, what a diagnostic setting must enable
+type DiagnosticsCatalog struct {
+	ResourceTypes map[string]DiagnosticsRequirement `json:"resource_types"`
+}
+
+type DiagnosticsRequirement struct {
+	Logs    []string `json:"logs"`    // log categories; the allLogs category group covers every one
+	Metrics []string `json:"metrics"` // metric categories, usually AllMetrics
+}
+
+// What one azurerm_monitor_diagnostic_setting sends where
+type diagnosticSetting struct {
+	ResourceInstance
+	name      string
+	target    string // lowercased target_resource_id
+	workspace string // lowercased log_analytics_workspace_id
+	logs      []string
+	metrics   []string
+}
+
+func loadDiagnosticsCatalog(path string) (*DiagnosticsCatalog, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	catalog := &DiagnosticsCatalog{}
+	if err := json.Unmarshal(data, catalog); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return catalog, nil
+}
+
+// Types sorted by name, for stable reports
+func (c *DiagnosticsCatalog) types() []string {
+	types := make([]string, 0, len(c.ResourceTypes))
+	for t := range c.ResourceTypes {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// Instances in state of every type the catalog knows
+func (c *DiagnosticsCatalog) instances(tfState *State) []ResourceInstance {
+	var instances []ResourceInstance
+	for _, t := range c.types() {
+		instances = append(instances, tfState.InstancesByType(t)...)
+	}
+	return instances
+}
+
+// Diagnostic settings in state by target resource id. Providers before 4.0 use
+// log and metric blocks with an enabled flag, later ones enabled_log and enabled_metric.
+func diagnosticSettingsOf(tfState *State) map[string][]diagnosticSetting {
+	byTarget := map[string][]diagnosticSetting{}
+	for _, ri := range tfState.InstancesByType("azurerm_monitor_diagnostic_setting") {
+		a := ri.Attr()
+		ds := diagnosticSetting{ResourceInstance: ri}
+		ds.name, _ = a.String("name")
+		target, _ := a.String("target_resource_id")
+		workspace, _ := a.String("log_analytics_workspace_id")
+		ds.target, ds.workspace = strings.ToLower(target), strings.ToLower(workspace)
+		ds.logs = enabledCategories(a, "enabled_log", "log", "category", "category_group")
+		ds.metrics = enabledCategories(a, "enabled_metric", "metric", "category")
+		byTarget[ds.target] = append(byTarget[ds.target], ds)
+	}
+	return byTarget
+}
+
+// Categories of the enabled blocks; blocks of the legacy kind count unless enabled = false
+func enabledCategories(a Attrs, block, legacy string, keys ...string) []string {
+	var categories []string
+	for _, name := range []string{block, legacy} {
+		items, _ := a.List(name)
+		for i := range items {
+			if enabled, err := a.Bool(fmt.Sprintf("%s.%d.enabled", name, i)); err == nil && !enabled {
+				continue
+			}
+			for _, key := range keys {
+				if c, _ := a.String(fmt.Sprintf("%s.%d.%s", name, i, key)); c != "" {
+					categories = append(categories, c)
+				}
+			}
+		}
+	}
+	return categories
+}
+
+// Id of the environment's workspace: the one named in expectations, else the
+// log_analytics_workspace_id output. Empty when neither says.
+func environmentWorkspace(tfState *State, exp *Expectations) string {
+	if exp != nil && exp.LogAnalytics.WorkspaceName != "" {
+		for _, ri := range tfState.InstancesByType("azurerm_log_analytics_workspace") {
+			if name, _ := ri.Attr().String("name"); strings.EqualFold(name, exp.LogAnalytics.WorkspaceName) {
+				id, _ := ri.Attr().String("id")
+				return strings.ToLower(id)
+			}
+		}
+		return "/workspaces/" + strings.ToLower(exp.LogAnalytics.WorkspaceName) // matched by suffix
+	}
+	if id, ok := tfState.OutputValue("log_analytics_workspace_id"); ok {
+		if s, ok := id.(string); ok {
+			return strings.ToLower(s)
+		}
+	}
+	return ""
+}
+
+// How to name the environment workspace when neither source does
+const unknownWorkspace = "set log_analytics.workspace_name in the expectations or output log_analytics_workspace_id"
+
+func isWorkspace(id, workspace string) bool {
+	if strings.HasPrefix(workspace, "/workspaces/") {
+		return strings.HasSuffix(id, workspace)
+	}
+	return id == workspace
+}
+
+// The diagnostic setting of ri that sends to the environment's workspace
+func envDiagnosticSetting(ri ResourceInstance, settings map[string][]diagnosticSetting, workspace string) (diagnosticSetting, []diagnosticSetting, bool) {
+	id, _ := ri.Attr().String("id")
+	all := settings[strings.ToLower(id)]
+	for _, ds := range all {
+		if isWorkspace(ds.workspace, workspace) {
+			return ds, all, true
+		}
+	}
+	return diagnosticSetting{}, all, false
+}
+
+// Every resource that supports diagnostics has a setting sending to the environment's workspace
+func checkDiagnosticSettingCoverage(tfState *State, exp *Expectations, catalog *DiagnosticsCatalog) CheckResult {
+	workspace := environmentWorkspace(tfState, exp)
+	settings := diagnosticSettingsOf(tfState)
+	return eachInstance(catalog.instances(tfState), func(ri ResourceInstance) CheckResult {
+		if id, _ := ri.Attr().String("id"); id == "" {
+			return notApplicable("id is not known until apply")
+		}
+		ds, all, ok := envDiagnosticSetting(ri, settings, workspace)
+		if !ok || workspace == "" {
+			if len(all) == 0 {
+				return fail("no azurerm_monitor_diagnostic_setting targets this resource")
+			}
+			var elsewhere []string
+			for _, other := range all {
+				elsewhere = append(elsewhere, fmt.Sprintf("%s -> %s", other.name, other.workspace))
+			}
+			if workspace == "" {
+				return fail("found " + strings.Join(elsewhere, ", ") + ", but the environment workspace is unknown: " + unknownWorkspace)
+			}
+			return fail("no diagnostic setting sends to the environment workspace; found " + strings.Join(elsewhere, ", "))
+		}
+		return passWith(1, fmt.Sprintf("%s -> %s; logs: %s; metrics: %s", ds.name, ds.workspace, listOrNone(ds.logs), listOrNone(ds.metrics)))
+	})
+}
+
+// The environment workspace receives every log category and metric the catalog requires
+func checkDiagnosticCategories(tfState *State, exp *Expectations, catalog *DiagnosticsCatalog) CheckResult {
+	workspace := environmentWorkspace(tfState, exp)
+	settings := diagnosticSettingsOf(tfState)
+	return eachInstance(catalog.instances(tfState), func(ri ResourceInstance) CheckResult {
+		if id, _ := ri.Attr().String("id"); id == "" {
+			return notApplicable("id is not known until apply")
+		}
+		ds, _, ok := envDiagnosticSetting(ri, settings, workspace)
+		if workspace == "" || !ok {
+			return notApplicable("no diagnostic setting to the environment workspace")
+		}
+		req := catalog.ResourceTypes[ri.Type]
+		missingLogs := missingCategories(req.Logs, ds.logs, "allLogs")
+		missingMetrics := missingCategories(req.Metrics, ds.metrics, "AllMetrics")
+		var problems []string
+		if len(missingLogs) > 0 {
+			problems = append(problems, "missing log categories "+strings.Join(missingLogs, ", "))
+		}
+		if len(missingMetrics) > 0 {
+			problems = append(problems, "missing metrics "+strings.Join(missingMetrics, ", "))
+		}
+		if len(problems) > 0 {
+			return fail(fmt.Sprintf("%s: %s", ds.name, strings.Join(problems, "; ")))
+		}
+		return pass(1)
+	})
+}
+
+// Per resource type: how many instances send diagnostics to the environment workspace.
+// A listing only; the gaps fail in checkDiagnosticSettingCoverage.
+func checkDiagnosticsByType(tfState *State, exp *Expectations, catalog *DiagnosticsCatalog) CheckResult {
+	workspace := environmentWorkspace(tfState, exp)
+	settings := diagnosticSettingsOf(tfState)
+	res := CheckResult{Pass: true}
+	for _, t := range catalog.types() {
+		instances := tfState.InstancesByType(t)
+		if len(instances) == 0 {
+			continue
+		}
+		covered, withSetting := 0, 0
+		for _, ri := range instances {
+			if _, all, ok := envDiagnosticSetting(ri, settings, workspace); ok && workspace != "" {
+				covered++
+			} else if len(all) > 0 {
+				withSetting++
+			}
+		}
+		msg := fmt.Sprintf("%d of %d instance(s) send diagnostics to the environment workspace", covered, len(instances))
+		if workspace == "" {
+			msg = fmt.Sprintf("%d of %d instance(s) have a diagnostic setting; the environment workspace is unknown", withSetting, len(instances))
+		}
+		r := passWith(1, msg)
+		res.Instances = append(res.Instances, InstanceResult{Address: t, CheckResult: r})
+		res.Evaluated += r.Evaluated
+		res.Pass = res.Pass && r.Pass
+	}
+	return res
+}
+
+// Required categories not enabled; the catch-all category enables them all
+func missingCategories(required, enabled []string, catchAll string) []string {
+	if containsFold(enabled, catchAll) {
+		return nil
+	}
+	var missing []string
+	for _, r := range required {
+		if !containsFold(enabled, r) {
+			missing = append(missing, r)
+		}
+	}
+	return missing
+}
+
+func listOrNone(items []string) string {
+	if len(items) == 0 {
+		return "none"
+	}
+	return strings.Join(items, ", ")
+}
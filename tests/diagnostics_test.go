@@ -0,0 +1,125 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Sends every resource type the catalog lists to the environment's Log Analytics workspace
+func RunDiagnosticsTests(tfState *State, exp *Expectations) []TestCase {
+	catalog, err := loadDiagnosticsCatalog(diagnosticsCatalogPath)
+	withCatalog := func(check func(*State, *Expectations, *DiagnosticsCatalog) CheckResult) func() CheckResult {
+		return func() CheckResult {
+			if err != nil {
+				return fail("diagnostics catalog: " + err.Error())
+			}
+			return check(tfState, exp, catalog)
+		}
+	}
+	tests := []GenericTest{
+		{"1._Verify_Diagnostic_Setting_Targets_Env_Workspace", "DiagnosticsTests", withCatalog(checkDiagnosticSettingCoverage)},
+		{"2._Verify_Required_Log_Categories_And_Metrics", "DiagnosticsTests", withCatalog(checkDiagnosticCategories)},
+		{"3._Report_Diagnostics_Coverage_By_Resource_Type", "DiagnosticsTests", withCatalog(checkDiagnosticsByType)},
+	}
+
+	return executeTestCases(tests)
+}
+
+// APIM sending gateway logs to the environment workspace, an Event Hub namespace
+// sending only metrics there, and an NSG whose setting goes to another workspace
+const diagnosticsState = `{
+	"version": 4,
+	"outputs": {"log_analytics_workspace_id": {"value": "/s/rg/workspaces/dev-law", "type": "string"}},
+	"resources": [
+		{"module": "module.exp.module.apim", "mode": "managed", "type": "azurerm_api_management", "name": "this",
+		 "instances": [{"attributes": {"id": "/s/rg/service/dev-apim"}}]},
+		{"module": "module.epp.module.epp_eventhub", "mode": "managed", "type": "azurerm_eventhub_namespace", "name": "this",
+		 "instances": [{"attributes": {"id": "/s/rg/namespaces/dev-ehns"}}]},
+		{"module": "module.spoke.module.nsg_exp", "mode": "managed", "type": "azurerm_network_security_group", "name": "this",
+		 "instances": [{"attributes": {"id": "/s/rg/networkSecurityGroups/exp-nsg"}}]},
+		{"module": "module.proc.module.func", "mode": "managed", "type": "azurerm_windows_function_app", "name": "this",
+		 "instances": [{"attributes": {"id": "/s/rg/sites/proc-func"}}]},
+		{"mode": "managed", "type": "azurerm_monitor_diagnostic_setting", "name": "apim",
+		 "instances": [{"attributes": {"name": "apim-diag", "target_resource_id": "/S/RG/SERVICE/DEV-APIM", "log_analytics_workspace_id": "/s/rg/workspaces/dev-law",
+		   "enabled_log": [{"category": "GatewayLogs", "category_group": ""}], "metric": [{"category": "AllMetrics", "enabled": true}]}}]},
+		{"mode": "managed", "type": "azurerm_monitor_diagnostic_setting", "name": "ehns",
+		 "instances": [{"attributes": {"name": "ehns-diag", "target_resource_id": "/s/rg/namespaces/dev-ehns", "log_analytics_workspace_id": "/s/rg/workspaces/dev-law",
+		   "log": [{"category": "OperationalLogs", "enabled": false}], "metric": [{"category": "AllMetrics", "enabled": true}]}}]},
+		{"mode": "managed", "type": "azurerm_monitor_diagnostic_setting", "name": "nsg",
+		 "instances": [{"attributes": {"name": "nsg-diag", "target_resource_id": "/s/rg/networkSecurityGroups/exp-nsg", "log_analytics_workspace_id": "/s/hub/workspaces/hub-law",
+		   "enabled_log": [{"category": "", "category_group": "allLogs"}]}}]}
+	]
+}`
+
+func TestDiagnosticsCoverage(t *testing.T) {
+	tfState, err := parseState([]byte(diagnosticsState))
+	require.NoError(t, err)
+	catalog, err := loadDiagnosticsCatalog(diagnosticsCatalogPath)
+	require.NoError(t, err)
+
+	coverage := instanceOutcomes(checkDiagnosticSettingCoverage(tfState, nil, catalog))
+	assert.Equal(t, map[string]string{
+		"module.exp.module.apim.azurerm_api_management.this":              "pass: apim-diag -> /s/rg/workspaces/dev-law; logs: GatewayLogs; metrics: AllMetrics",
+		"module.epp.module.epp_eventhub.azurerm_eventhub_namespace.this":  "pass: ehns-diag -> /s/rg/workspaces/dev-law; logs: none; metrics: AllMetrics",
+		"module.spoke.module.nsg_exp.azurerm_network_security_group.this": "fail: no diagnostic setting sends to the environment workspace; found nsg-diag -> /s/hub/workspaces/hub-law",
+		"module.proc.module.func.azurerm_windows_function_app.this":       "fail: no azurerm_monitor_diagnostic_setting targets this resource",
+	}, coverage)
+
+	categories := instanceOutcomes(checkDiagnosticCategories(tfState, nil, catalog))
+	assert.Equal(t, "pass: ", categories["module.exp.module.apim.azurerm_api_management.this"])
+	assert.Equal(t, "fail: ehns-diag: missing log categories OperationalLogs, RuntimeAuditLogs", categories["module.epp.module.epp_eventhub.azurerm_eventhub_namespace.this"],
+		"a disabled legacy log block does not count")
+	assert.Equal(t, "n/a: no diagnostic setting to the environment workspace", categories["module.proc.module.func.azurerm_windows_function_app.this"])
+
+	byType := instanceOutcomes(checkDiagnosticsByType(tfState, nil, catalog))
+	assert.Len(t, byType, 4, "only types present in state are listed")
+	assert.Equal(t, "pass: 0 of 1 instance(s) send diagnostics to the environment workspace", byType["azurerm_windows_function_app"])
+}
+
+func TestDiagnosticsEnvironmentWorkspace(t *testing.T) {
+	tfState, err := parseState([]byte(diagnosticsState))
+	require.NoError(t, err)
+	catalog, err := loadDiagnosticsCatalog(diagnosticsCatalogPath)
+	require.NoError(t, err)
+
+	exp := &Expectations{}
+	exp.LogAnalytics.WorkspaceName = "HUB-LAW"
+	res := checkDiagnosticSettingCoverage(tfState, exp, catalog)
+	for _, inst := range res.Instances {
+		assert.Equal(t, inst.Address == "module.spoke.module.nsg_exp.azurerm_network_security_group.this", inst.Pass,
+			"the named workspace wins over the output: %s", inst.Address)
+	}
+
+	// Without a workspace every resource is still reported with what it has
+	tfState.Outputs = nil
+	res = checkDiagnosticSettingCoverage(tfState, nil, catalog)
+	assert.False(t, res.Pass)
+	require.Len(t, res.Instances, 4)
+	for _, inst := range res.Instances {
+		if inst.Address == "module.proc.module.func.azurerm_windows_function_app.this" {
+			assert.Equal(t, "no azurerm_monitor_diagnostic_setting targets this resource", inst.Message)
+		} else {
+			assert.Contains(t, inst.Message, "the environment workspace is unknown")
+		}
+	}
+	byType := checkDiagnosticsByType(tfState, nil, catalog)
+	assert.Contains(t, byType.Instances[0].Message, "1 of 1 instance(s) have a diagnostic setting")
+}
+
+func TestDiagnosticsSkipUnknownIDs(t *testing.T) {
+	tfState, err := parseState([]byte(diagnosticsState))
+	require.NoError(t, err)
+	catalog, err := loadDiagnosticsCatalog(diagnosticsCatalogPath)
+	require.NoError(t, err)
+	for _, r := range tfState.Resources {
+		if r.Type == "azurerm_api_management" {
+			delete(r.Instances[0].Attributes, "id")
+		}
+	}
+
+	const apim = "module.exp.module.apim.azurerm_api_management.this"
+	assert.Equal(t, "n/a: id is not known until apply", instanceOutcomes(checkDiagnosticSettingCoverage(tfState, nil, catalog))[apim])
+	assert.Equal(t, "n/a: id is not known until apply", instanceOutcomes(checkDiagnosticCategories(tfState, nil, catalog))[apim])
+}
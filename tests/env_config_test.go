@@ -0,0 +1,305 @@
+package test
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Checks that state carries exactly what the environment's main.tf and terraform.tfvars declare
+func RunEnvironmentConfigTests(tfState *State, exp *Expectations) []TestCase {
+	cfg := exp.Config
+	tests := []GenericTest{
+		{"1._Verify_Spoke_VNet_Matches_spoke_vnet_cidr", "EnvironmentConfigTests", func() CheckResult {
+			if cfg == nil {
+				return notApplicable("no Terraform environment for " + exp.Environment)
+			}
+			return eachInstance(findModuleInstancesByType(tfState, "module.spoke", "azurerm_virtual_network"), func(vnet ResourceInstance) CheckResult {
+				space, err := vnet.Attr().Strings("address_space")
+				if err != nil {
+					return fail(err.Error())
+				}
+				if len(space) != 1 || space[0] != exp.SpokeVNetCIDR {
+					return fail(fmt.Sprintf("address_space is %v, main.tf sets spoke_vnet_cidr = %q", space, exp.SpokeVNetCIDR))
+				}
+				return pass(1)
+			})
+		}},
+		{"2._Verify_Spoke_Subnets_Match_Configured_CIDRs", "EnvironmentConfigTests", func() CheckResult {
+			if cfg == nil {
+				return notApplicable("no Terraform environment for " + exp.Environment)
+			}
+			return spokeSubnetsMatch(tfState, exp.SubnetCIDRs)
+		}},
+		{"3._Verify_Module_Resources_In_Configured_Region", "EnvironmentConfigTests", func() CheckResult {
+			if cfg == nil {
+				return notApplicable("no Terraform environment for " + exp.Environment)
+			}
+			var located []ResourceInstance
+			for _, ri := range tfState.Query(Query{Mode: ModeManaged}) {
+				segments := moduleSegments(ri.Module)
+				if len(segments) == 0 || cfg.Modules[segments[0]] == nil {
+					continue
+				}
+				_, hasRegion := cfg.Modules[segments[0]].String("region")
+				if _, ok := ri.Attributes["location"]; ok && hasRegion {
+					located = append(located, ri)
+				}
+			}
+			return eachInstance(located, func(ri ResourceInstance) CheckResult {
+				name := moduleSegments(ri.Module)[0]
+				region, _ := cfg.Modules[name].String("region")
+				location, err := ri.Attr().String("location")
+				if err != nil {
+					return fail(err.Error())
+				}
+				if !sameLocation(location, region) {
+					return fail(fmt.Sprintf("location is %s, module.%s sets region = %q", location, name, region))
+				}
+				return pass(1)
+			})
+		}},
+		{"4._Verify_APIM_SKU_Matches_apim_sku", "EnvironmentConfigTests", func() CheckResult {
+			if cfg == nil {
+				return notApplicable("no Terraform environment for " + exp.Environment)
+			}
+			return eachInstance(findModuleInstancesByType(tfState, "module.exp", "azurerm_api_management"), func(apim ResourceInstance) CheckResult {
+				sku, err := apim.Attr().String("sku_name")
+				if err != nil {
+					return fail(err.Error())
+				}
+				if sku != exp.APIMSku {
+					return fail(fmt.Sprintf("sku_name is %s, main.tf sets apim_sku = %q", sku, exp.APIMSku))
+				}
+				return pass(1)
+			})
+		}},
+		{"5._Verify_Resource_Group_Names_Use_region_short", "EnvironmentConfigTests", func() CheckResult {
+			if cfg == nil {
+				return notApplicable("no Terraform environment for " + exp.Environment)
+			}
+			return resourceGroupsNamedByConfig(tfState, cfg)
+		}},
+		{"6._Verify_Module_Tags_Applied", "EnvironmentConfigTests", func() CheckResult {
+			if cfg == nil {
+				return notApplicable("no Terraform environment for " + exp.Environment)
+			}
+			return moduleTagsApplied(tfState, cfg)
+		}},
+	}
+
+	return executeTestCases(tests)
+}
+
+// Module calls in name order, for stable reports
+func sortedModules(cfg *EnvironmentConfig) []*EnvModule {
+	var modules []*EnvModule
+	for _, name := range sortedKeys(cfg.Modules) {
+		modules = append(modules, cfg.Modules[name])
+	}
+	return modules
+}
+
+// Resource groups of every module call setting region_short are named
+// <project_name>-<environment>-<region_short>-..., as the modules build them
+func resourceGroupsNamedByConfig(tfState *State, cfg *EnvironmentConfig) CheckResult {
+	res := CheckResult{Pass: true}
+	for _, mod := range sortedModules(cfg) {
+		short, ok := mod.String("region_short")
+		if !ok {
+			continue
+		}
+		project, _ := mod.String("project_name")
+		env, _ := mod.String("environment")
+		prefix := strings.ToLower(fmt.Sprintf("%s-%s-%s-", project, env, short))
+		r := eachInstance(findModuleInstancesByType(tfState, "module."+mod.Name, "azurerm_resource_group"), func(rg ResourceInstance) CheckResult {
+			name, err := rg.Attr().String("name")
+			if err != nil {
+				return fail(err.Error())
+			}
+			if !strings.HasPrefix(strings.ToLower(name), prefix) {
+				return fail(fmt.Sprintf("name is %s, module.%s sets region_short = %q so it should start with %s", name, mod.Name, short, prefix))
+			}
+			return pass(1)
+		})
+		res.Instances = append(res.Instances, r.Instances...)
+		res.Evaluated += r.Evaluated
+		res.Pass = res.Pass && r.Pass
+	}
+	return res
+}
+
+// Every tag a module call passes in tags lands, key and value exactly, on each
+// resource of that module that takes tags
+func moduleTagsApplied(tfState *State, cfg *EnvironmentConfig) CheckResult {
+	res := CheckResult{Pass: true}
+	for _, mod := range sortedModules(cfg) {
+		want, ok := mod.Inputs["tags"].(map[string]interface{})
+		if !ok || len(want) == 0 {
+			continue
+		}
+		var tagged []ResourceInstance
+		for _, ri := range tfState.InModule("module." + mod.Name) {
+			if _, ok := ri.Attributes["tags"]; ok {
+				tagged = append(tagged, ri)
+			}
+		}
+		r := eachInstance(tagged, func(ri ResourceInstance) CheckResult {
+			got, _ := ri.Attributes["tags"].(map[string]interface{})
+			var problems []string
+			for _, key := range sortedKeys(want) {
+				if v, ok := got[key]; !ok {
+					problems = append(problems, fmt.Sprintf("%s missing", key))
+				} else if v != want[key] {
+					problems = append(problems, fmt.Sprintf("%s is %q", key, v))
+				}
+			}
+			if len(problems) > 0 {
+				return fail(fmt.Sprintf("module.%s sets tags %v, but %s", mod.Name, want, strings.Join(problems, ", ")))
+			}
+			return pass(1)
+		})
+		res.Instances = append(res.Instances, r.Instances...)
+		res.Evaluated += r.Evaluated
+		res.Pass = res.Pass && r.Pass
+	}
+	return res
+}
+
+// One result per configured subnet plus one per spoke subnet main.tf does not declare
+func spokeSubnetsMatch(tfState *State, want map[string]string) CheckResult {
+	res := CheckResult{Pass: true}
+	record := func(address string, r CheckResult) {
+		res.Instances = append(res.Instances, InstanceResult{Address: address, CheckResult: r})
+		res.Evaluated += r.Evaluated
+		res.Pass = res.Pass && r.Pass
+	}
+
+	seen := map[string]bool{}
+	for _, sn := range findModuleInstancesByType(tfState, "module.spoke", "azurerm_subnet") {
+		segments := moduleSegments(sn.Module)
+		key := ""
+		if len(segments) > 1 {
+			key = strings.TrimPrefix(segments[1], "subnet_")
+		}
+		cidr, configured := want[key]
+		if !configured {
+			record(sn.Address(), fail("subnet is not declared by any *_subnet_cidr in main.tf"))
+			continue
+		}
+		seen[key] = true
+		prefixes, err := sn.Attr().Strings("address_prefixes")
+		switch {
+		case err != nil:
+			record(sn.Address(), fail(err.Error()))
+		case len(prefixes) != 1 || prefixes[0] != cidr:
+			record(sn.Address(), fail(fmt.Sprintf("address_prefixes is %v, main.tf sets %s_subnet_cidr = %q", prefixes, key, cidr)))
+		default:
+			record(sn.Address(), pass(1))
+		}
+	}
+	for _, key := range sortedKeys(want) {
+		if !seen[key] {
+			record("module.spoke.module.subnet_"+key, fail(fmt.Sprintf("%s_subnet_cidr = %q is configured but no subnet is in state", key, want[key])))
+		}
+	}
+	return res
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestDevExpectationsFollowMainTF(t *testing.T) {
+	exp, err := loadExpectations("dev")
+	require.NoError(t, err)
+	require.NotNil(t, exp.Config, "Environments/Dev is parsed")
+
+	assert.Equal(t, "UAE North", exp.Region)
+	assert.Equal(t, "10.110.0.0/16", exp.SpokeVNetCIDR)
+	assert.Equal(t, "10.110.31.0/24", exp.SubnetCIDRs["procfapp"])
+	assert.Equal(t, "Developer_1", exp.APIMSku)
+	assert.Equal(t, "Standard_B2s", exp.VMSize, "default of Modules/Bastion vm_size")
+	assert.Equal(t, 7, exp.EventHubMessageRetention, `message_retention = "7" in main.tf`)
+	assert.Equal(t, "PerGB2018", exp.LogAnalytics.SKU, "not in main.tf, kept from expectations/dev.json")
+	assert.Equal(t, "agida-dev-uaen-exp-apim-law", exp.LogAnalytics.WorkspaceName, "from expectations/dev.json; main.tf does not output it")
+
+	spoke := exp.Config.Modules["spoke"]
+	require.NotNil(t, spoke)
+	assert.Equal(t, "../../Modules/Spoke", spoke.Source)
+	assert.Contains(t, spoke.Inputs, "default_nsg_rules", "var.* resolved from terraform.tfvars")
+	assert.Equal(t, "uaen", spoke.Inputs["region_short"])
+	assert.Equal(t, map[string]interface{}{"Environment": "dev", "Owner": "CloudTeam", "project": "API Ecosystem"}, spoke.Inputs["tags"])
+	assert.NotContains(t, exp.Config.Modules["bastion"].Inputs, "bastion_subnet_id", "module outputs are not constants")
+}
+
+func TestSpokeSubnetsMatchReportsMissingAndUndeclared(t *testing.T) {
+	tfState, err := parseState([]byte(`{
+		"version": 4,
+		"resources": [
+			{"module": "module.spoke.module.subnet_bastion", "mode": "managed", "type": "azurerm_subnet", "name": "subnet",
+			 "instances": [{"attributes": {"address_prefixes": ["10.110.10.0/24"]}}]},
+			{"module": "module.spoke.module.subnet_exp", "mode": "managed", "type": "azurerm_subnet", "name": "subnet",
+			 "instances": [{"attributes": {"address_prefixes": ["10.110.99.0/24"]}}]},
+			{"module": "module.spoke.module.subnet_extra", "mode": "managed", "type": "azurerm_subnet", "name": "subnet",
+			 "instances": [{"attributes": {"address_prefixes": ["10.110.70.0/24"]}}]}
+		]
+	}`))
+	require.NoError(t, err)
+
+	res := spokeSubnetsMatch(tfState, map[string]string{
+		"bastion": "10.110.10.0/24",
+		"exp":     "10.110.20.0/24",
+		"proc":    "10.110.30.0/24",
+	})
+	assert.False(t, res.Pass)
+	require.Len(t, res.Instances, 4)
+
+	byAddress := map[string]CheckResult{}
+	for _, inst := range res.Instances {
+		byAddress[inst.Address] = inst.CheckResult
+	}
+	assert.True(t, byAddress["module.spoke.module.subnet_bastion.azurerm_subnet.subnet"].Pass)
+	assert.Contains(t, byAddress["module.spoke.module.subnet_exp.azurerm_subnet.subnet"].Message, `exp_subnet_cidr = "10.110.20.0/24"`)
+	assert.Contains(t, byAddress["module.spoke.module.subnet_extra.azurerm_subnet.subnet"].Message, "not declared")
+	assert.Contains(t, byAddress["module.spoke.module.subnet_proc"].Message, "no subnet is in state")
+}
+
+func TestEnvironmentConfigNamingAndTags(t *testing.T) {
+	tfState, err := parseState([]byte(`{
+		"version": 4,
+		"resources": [
+			{"module": "module.exp", "mode": "managed", "type": "azurerm_resource_group", "name": "this",
+			 "instances": [{"attributes": {"name": "agida-dev-uaen-exp-rg", "tags": {"Environment": "dev", "Owner": "CloudTeam"}}}]},
+			{"module": "module.spoke", "mode": "managed", "type": "azurerm_resource_group", "name": "this",
+			 "instances": [{"attributes": {"name": "agida-dev-weu-spk-rg", "tags": {"Environment": "dev"}}}]},
+			{"module": "module.spoke.module.subnet_exp", "mode": "managed", "type": "azurerm_subnet", "name": "subnet",
+			 "instances": [{"attributes": {"name": "exp"}}]}
+		]
+	}`))
+	require.NoError(t, err)
+	tags := map[string]interface{}{"Environment": "dev", "Owner": "CloudTeam"}
+	cfg := &EnvironmentConfig{Modules: map[string]*EnvModule{
+		"exp":   {Name: "exp", Inputs: map[string]interface{}{"project_name": "agida", "environment": "dev", "region_short": "uaen", "tags": tags}},
+		"spoke": {Name: "spoke", Inputs: map[string]interface{}{"project_name": "agida", "environment": "dev", "region_short": "uaen", "tags": tags}},
+	}}
+
+	naming := resourceGroupsNamedByConfig(tfState, cfg)
+	require.Len(t, naming.Instances, 2)
+	assert.True(t, naming.Instances[0].Pass)
+	assert.Contains(t, naming.Instances[1].Message, `region_short = "uaen" so it should start with agida-dev-uaen-`)
+
+	applied := moduleTagsApplied(tfState, cfg)
+	require.Len(t, applied.Instances, 2, "the subnet takes no tags")
+	assert.True(t, applied.Instances[0].Pass)
+	assert.Contains(t, applied.Instances[1].Message, "Owner missing")
+}
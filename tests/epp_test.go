@@ -5,61 +5,78 @@ import (
 	"strings"
 )
 
-func RunEppTests(tfState map[string]interface{}) []TestCase {
+func RunEppTests(tfState *State) []TestCase {
 	tests := []GenericTest{
-		{"1._Verify_EPP_Resource_Group", "EppInfraTests", func() (bool, string) {
-			for _, rg := range findResourcesByType(tfState, "azurerm_resource_group") {
-				if strings.Contains(rg["name"].(string), "epp-rg") {
-					return true, ""
+		{"1._Verify_EPP_Resource_Group", "EppInfraTests", func() CheckResult {
+			for _, rg := range findModuleInstancesByType(tfState, "module.epp", "azurerm_resource_group") {
+				name, err := rg.Attr().String("name")
+				if err != nil {
+					return fail(err.Error())
+				}
+				if strings.Contains(name, "epp-rg") {
+					return pass(1)
 				}
 			}
-			return false, "EPP Resource Group 'epp-rg' not found"
+			return fail("EPP Resource Group 'epp-rg' not found")
 		}},
-		{"2._Verify_EPP_EventHub_Namespace_And_EventHub", "EppInfraTests", func() (bool, string) {
+		{"2._Verify_EPP_EventHub_Namespace_And_EventHub", "EppInfraTests", func() CheckResult {
 			foundNS, foundEH := false, false
-			for _, ns := range findResourcesByType(tfState, "azurerm_eventhub_namespace") {
-				if strings.Contains(ns["name"].(string), "epphubspace-ns") {
+			for _, ns := range findModuleInstancesByType(tfState, "module.epp", "azurerm_eventhub_namespace") {
+				name, err := ns.Attr().String("name")
+				if err != nil {
+					return fail(err.Error())
+				}
+				if strings.Contains(name, "epphubspace-ns") {
 					foundNS = true
 				}
 			}
-			for _, eh := range findResourcesByType(tfState, "azurerm_eventhub") {
-				if strings.Contains(eh["name"].(string), "epphub-eh") {
+			for _, eh := range findModuleInstancesByType(tfState, "module.epp", "azurerm_eventhub") {
+				name, err := eh.Attr().String("name")
+				if err != nil {
+					return fail(err.Error())
+				}
+				if strings.Contains(name, "epphub-eh") {
 					foundEH = true
 				}
 			}
 			if !foundNS {
-				return false, "Event Hub Namespace 'epphubspace-ns' not found"
+				return fail("Event Hub Namespace 'epphubspace-ns' not found")
 			}
 			if !foundEH {
-				return false, "Event Hub 'epphub-eh' not found"
+				return fail("Event Hub 'epphub-eh' not found")
 			}
-			return true, ""
+			return pass(2)
 		}},
-		{"3._Verify_EPP_Private_Endpoint", "EppInfraTests", func() (bool, string) {
-			for _, pep := range findResourcesByType(tfState, "azurerm_private_endpoint") {
-				if strings.Contains(pep["name"].(string), "epphub-pep") {
-					return true, ""
+		{"3._Verify_EPP_Private_Endpoint", "EppInfraTests", func() CheckResult {
+			for _, pep := range findModuleInstancesByType(tfState, "module.epp", "azurerm_private_endpoint") {
+				name, err := pep.Attr().String("name")
+				if err != nil {
+					return fail(err.Error())
+				}
+				if strings.Contains(name, "epphub-pep") {
+					return pass(1)
 				}
 			}
-			return false, "Private Endpoint 'epphub-pep' not found"
+			return fail("Private Endpoint 'epphub-pep' not found")
 		}},
-		{"4._Verify_Tag_Consistency", "EPPInfraTests", func() (bool, string) {
-			rgs := findResourcesByType(tfState, "azurerm_resource_group")
+		{"4._Verify_Tag_Consistency", "EPPInfraTests", func() CheckResult {
+			rgs := findModuleResourcesByType(tfState, "module.epp", "azurerm_resource_group")
 			for _, rg := range rgs {
 				tags, _ := rg["tags"].(map[string]interface{})
-				if tags["Project"] == "API Ecosystem" {
-					return true, ""
+				if tagValue(tags, "Project") == "API Ecosystem" {
+					return pass(1)
 				}
 			}
-			return false, "Tags not consistent"
+			return fail("Tags not consistent")
 		}},
-		{"5._Verify_EPP_EventHub_Namespace_Public_Network_Disabled", "EppInfraTests", func() (bool, string) {
-			for _, ns := range findResourcesByType(tfState, "azurerm_eventhub_namespace") {
+		{"5._Verify_EPP_EventHub_Namespace_Public_Network_Disabled", "EppInfraTests", func() CheckResult {
+			return eachInstance(findModuleInstancesByType(tfState, "module.epp", "azurerm_eventhub_namespace"), func(ri ResourceInstance) CheckResult {
+				ns := ri.Attributes
 				if enabled, ok := ns["public_network_access_enabled"].(bool); ok && enabled {
-					return false, fmt.Sprintf("Public network access ENABLED on Event Hub Namespace '%v'", ns["name"])
+					return fail(fmt.Sprintf("Public network access ENABLED on Event Hub Namespace '%v'", ns["name"]))
 				}
-			}
-			return true, ""
+				return pass(1)
+			})
 		}},
 	}
 
@@ -1,69 +1,71 @@
 package test
 
-import (
-	"strings"
-)
+import "strings"
 
-func RunExpTests(tfState map[string]interface{}) []TestCase {
+func RunExpTests(tfState *State, exp *Expectations) []TestCase {
 	tests := []GenericTest{
-		{"1._Verify_Resource_Group_Existence_and_Properties", "EXPInfraTests", func() (bool, string) {
-			rgs := findResourcesByType(tfState, "azurerm_resource_group")
+		{"1._Verify_Resource_Group_Existence_and_Properties", "EXPInfraTests", func() CheckResult {
+			rgs := findModuleResourcesByType(tfState, "module.exp", "azurerm_resource_group")
 			for _, rg := range rgs {
 				name, _ := rg["name"].(string)
-				if strings.Contains(name, "exp-rg") {
+				if strings.HasSuffix(name, "exp-rg") {
 					loc, _ := rg["location"].(string)
 					tags, _ := rg["tags"].(map[string]interface{})
-					if loc != "" && tags["Project"] == "API Ecosystem" {
-						return true, ""
+					if loc != "" && tagValue(tags, "Project") == "API Ecosystem" {
+						return pass(1)
 					}
 				}
 			}
-			return false, "Resource Group 'exp-rg' not found or properties invalid"
+			return fail("Resource Group 'exp-rg' not found in module.exp or properties invalid")
 		}},
-		{"2._Verify_APIM_Instance", "EXPInfraTests", func() (bool, string) {
-			apims := findResourcesByType(tfState, "azurerm_api_management")
+		{"2._Verify_APIM_Instance", "EXPInfraTests", func() CheckResult {
+			apims, err := tfState.Select("module.exp.module.apim.azurerm_api_management.*")
+			if err != nil {
+				return fail(err.Error())
+			}
 			for _, apim := range apims {
-				name, _ := apim["name"].(string)
-				if strings.Contains(name, "exp-apim") {
-					return true, ""
+				name, _ := apim.Attributes["name"].(string)
+				if strings.HasSuffix(name, "exp-apim") {
+					return pass(1)
 				}
 			}
-			return false, "APIM instance 'exp-apim' not found"
+			return fail("APIM instance 'exp-apim' not found in module.exp.module.apim")
 		}},
-		{"3._Verify_APIM_Network_and_Access_Configuration", "EXPInfraTests", func() (bool, string) {
-			apims := findResourcesByType(tfState, "azurerm_api_management")
-			for _, apim := range apims {
-				publicAccess, _ := apim["public_network_access_enabled"].(bool)
-				if publicAccess {
-					return true, ""
+		{"3._Verify_APIM_Network_and_Access_Configuration", "EXPInfraTests", func() CheckResult {
+			// Public network access is judged with every other PaaS resource by the PrivatePosture
+			// suite, the subnet's NSG and DNS records by APIMInfraTests
+			return eachInstance(expAPIMs(tfState), func(ri ResourceInstance) CheckResult {
+				if problem := apimNetworkTypeProblem(ri, exp); problem != "" {
+					return fail(problem)
 				}
-			}
-			return false, "APIM public network access not enabled"
+				if subnet, _ := ri.Attr().String("virtual_network_configuration.0.subnet_id"); subnet == "" {
+					return fail("APIM has no virtual_network_configuration subnet")
+				}
+				return pass(1)
+			})
 		}},
-		{"4._Verify_Tag_Consistency", "EXPInfraTests", func() (bool, string) {
-			rgs := findResourcesByType(tfState, "azurerm_resource_group")
+		{"4._Verify_Tag_Consistency", "EXPInfraTests", func() CheckResult {
+			rgs := findModuleResourcesByType(tfState, "module.exp", "azurerm_resource_group")
 			for _, rg := range rgs {
 				tags, _ := rg["tags"].(map[string]interface{})
-				if tags["Project"] == "API Ecosystem" {
-					return true, ""
+				if tagValue(tags, "Project") == "API Ecosystem" {
+					return pass(1)
 				}
 			}
-			return false, "Tags not consistent"
+			return fail("Tags not consistent")
 		}},
-		{"5._Verify_Output_Values", "EXPInfraTests", func() (bool, string) {
-			outputs, ok := tfState["outputs"].(map[string]interface{})
-			if !ok {
-				return false, "No outputs in state"
+		{"5._Verify_Output_Values", "EXPInfraTests", func() CheckResult {
+			if len(tfState.Outputs) == 0 {
+				return fail("No outputs in state")
 			}
-			apimID, exists := outputs["apim_id"]
+			apimID, exists := tfState.OutputValue("apim_id")
 			if !exists {
-				return false, "Output 'apim_id' not found"
+				return fail("Output 'apim_id' not found")
 			}
-			val, ok := apimID.(map[string]interface{})
-			if !ok || val["value"] == nil {
-				return false, "Output 'apim_id' missing value"
+			if apimID == nil {
+				return fail("Output 'apim_id' missing value")
 			}
-			return true, ""
+			return pass(1)
 		}},
 	}
 
@@ -0,0 +1,147 @@
+package test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Directory holding one <env>.json of expected values per environment
+const expectationsDir = "expectations"
+
+// Expectations are the values an environment is deployed with; checks compare
+// state against these instead of literals. Values the environment's Terraform
+// already declares are derived from it, see applyEnvironmentConfig.
+type Expectations struct {
+	Environment            string            `json:"environment"`
+	Region                 string            `json:"region"`
+	SpokeVNetCIDR          string            `json:"spoke_vnet_cidr"`
+	SubnetCIDRs            map[string]string `json:"subnet_cidrs"` // keyed by spoke subnet, e.g. "bastion" for module.spoke.module.subnet_bastion
+	VMSize                 string            `json:"vm_size"`
+	APIMSku                string            `json:"apim_sku"`
+	APIMVirtualNetworkType string            `json:"apim_virtual_network_type"` // defaults to Internal
+	StorageAccountTier     string            `json:"storage_account_tier"`
+	LogAnalytics           struct {
+		SKU             string `json:"sku"`
+		RetentionInDays int    `json:"retention_in_days"`
+		WorkspaceName   string `json:"workspace_name"` // diagnostic settings target, e.g. the Exp APIM's workspace
+	} `json:"log_analytics"`
+	EventHubMessageRetention int                `json:"eventhub_message_retention"`
+	Flows                    []ExpectedFlow     `json:"flows"`                   // connections the reachability simulator must allow or deny
+	CapacityPrefixLengths    []int              `json:"capacity_prefix_lengths"` // subnet sizes the capacity report finds free space for; default /24
+	PostureExceptions        []PostureException `json:"posture_exceptions"`      // resources this environment lets stay partly public
+
+	Config *EnvironmentConfig `json:"-"` // nil when the environment has no Terraform directory
+}
+
+// Load expectations/<env>.json for the environment selected by -env, TEST_ENV or config.json
+func loadExpectations(env string) (*Expectations, error) {
+	return loadExpectationsFile(filepath.Join(expectationsDir, env+".json"), env)
+}
+
+// Load an expectations file, e.g. one a config profile points at, for env
+func loadExpectationsFile(path, env string) (*Expectations, error) {
+	if env == "" {
+		return nil, fmt.Errorf("no environment set; use -env, TEST_ENV or \"environment\" in config.json")
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("environment %q is not configured: no %s", env, path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	exp := &Expectations{}
+	if err := json.Unmarshal(data, exp); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if exp.Environment != env {
+		return nil, fmt.Errorf("%s: declares environment %q", path, exp.Environment)
+	}
+	for _, f := range exp.Flows {
+		if f.Name == "" || (f.Expect != "allow" && f.Expect != "deny") {
+			return nil, fmt.Errorf("%s: flow %q needs a name and expect \"allow\" or \"deny\"", path, f.Name)
+		}
+	}
+
+	for _, bits := range exp.CapacityPrefixLengths {
+		if bits < 1 || bits > 32 {
+			return nil, fmt.Errorf("%s: capacity_prefix_lengths: /%d is not an IPv4 prefix length", path, bits)
+		}
+	}
+
+	if err := validatePostureExceptions(exp.PostureExceptions); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	dir, err := findEnvironmentDir(env)
+	if errors.Is(err, os.ErrNotExist) {
+		return exp, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := loadEnvironmentConfig(dir)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", dir, err)
+	}
+	exp.applyEnvironmentConfig(cfg)
+	return exp, nil
+}
+
+// Take region, CIDRs, SKUs and sizes from the module calls that set them,
+// overriding the expectations file so the two cannot drift apart
+func (e *Expectations) applyEnvironmentConfig(cfg *EnvironmentConfig) {
+	e.Config = cfg
+	if spoke, ok := cfg.Modules["spoke"]; ok {
+		if v, ok := spoke.String("region"); ok {
+			e.Region = v
+		}
+		if v, ok := spoke.String("spoke_vnet_cidr"); ok {
+			e.SpokeVNetCIDR = v
+		}
+		if names := spoke.InputsWithSuffix("_subnet_cidr"); len(names) > 0 {
+			e.SubnetCIDRs = map[string]string{}
+			for _, name := range names {
+				e.SubnetCIDRs[strings.TrimSuffix(name, "_subnet_cidr")], _ = spoke.String(name)
+			}
+		}
+	}
+	if bastion, ok := cfg.Modules["bastion"]; ok {
+		if v, ok := bastion.String("vm_size"); ok {
+			e.VMSize = v
+		}
+	}
+	if exp, ok := cfg.Modules["exp"]; ok {
+		if v, ok := exp.String("apim_sku"); ok {
+			e.APIMSku = v
+		}
+		if v, ok := exp.String("virtual_network_type"); ok {
+			e.APIMVirtualNetworkType = v
+		}
+	}
+	if epp, ok := cfg.Modules["epp"]; ok {
+		if v, ok := epp.Int("message_retention"); ok {
+			e.EventHubMessageRetention = v
+		}
+	}
+}
+
+// Azure locations compare by display name or normalized name: "UAE North" == "uaenorth"
+func sameLocation(a, b string) bool {
+	normalize := func(s string) string { return strings.ToLower(strings.ReplaceAll(s, " ", "")) }
+	return normalize(a) == normalize(b)
+}
+
+// Expected CIDR of a spoke subnet, keyed by its module call: module.spoke.module.subnet_<key>
+func (e *Expectations) SubnetCIDR(ri ResourceInstance) (string, bool) {
+	segments := moduleSegments(ri.Module)
+	if len(segments) < 2 || segments[0] != "spoke" || !strings.HasPrefix(segments[1], "subnet_") {
+		return "", false
+	}
+	cidr, ok := e.SubnetCIDRs[strings.TrimPrefix(segments[1], "subnet_")]
+	return cidr, ok
+}
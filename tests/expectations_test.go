@@ -0,0 +1,43 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadExpectationsPerEnvironment(t *testing.T) {
+	dev, err := loadExpectations("dev")
+	require.NoError(t, err)
+	assert.Equal(t, "dev", dev.Environment)
+	assert.NotEmpty(t, dev.SpokeVNetCIDR)
+	assert.Len(t, dev.SubnetCIDRs, 8)
+	assert.Equal(t, "UAE North", dev.Region)
+	assert.Equal(t, "Developer_1", dev.APIMSku)
+
+	_, err = loadExpectations("")
+	assert.ErrorContains(t, err, "no environment set")
+	// QA and Prod have no Terraform yet, so there is nothing to expect of them
+	for _, env := range []string{"qa", "prod", "staging"} {
+		_, err = loadExpectations(env)
+		assert.ErrorContains(t, err, "is not configured", env)
+	}
+}
+
+func TestExpectationsSubnetCIDR(t *testing.T) {
+	exp, err := loadExpectations("dev")
+	require.NoError(t, err)
+
+	subnet := ResourceInstance{
+		Resource: &Resource{Module: "module.spoke.module.subnet_procfapp", Mode: ModeManaged, Type: "azurerm_subnet", Name: "subnet"},
+		Instance: &Instance{},
+	}
+	cidr, ok := exp.SubnetCIDR(subnet)
+	assert.True(t, ok)
+	assert.Equal(t, "10.110.31.0/24", cidr)
+
+	subnet.Resource = &Resource{Module: "module.hub.module.subnet_exp", Mode: ModeManaged, Type: "azurerm_subnet", Name: "subnet"}
+	_, ok = exp.SubnetCIDR(subnet)
+	assert.False(t, ok, "only spoke subnets are keyed")
+}
@@ -0,0 +1,277 @@
+package test
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// Address space of a VNet, from this state or a related one
+type ipamVNet struct {
+	state   string // "" for the environment's own state, else the related state's name
+	address string
+	key     string // <resource group>/<name>
+	space   []netip.Prefix
+}
+
+// Prefixes of a subnet and the VNet it is carved from
+type ipamSubnet struct {
+	ResourceInstance
+	id       string
+	vnet     string // <resource group>/<name>
+	prefixes []netip.Prefix
+}
+
+func vnetsOf(tfState *State, stateName string) []ipamVNet {
+	var vnets []ipamVNet
+	for _, ri := range tfState.InstancesByType("azurerm_virtual_network") {
+		space, _ := ri.Attr().Strings("address_space")
+		vnets = append(vnets, ipamVNet{
+			state:   stateName,
+			address: ri.Address(),
+			key:     nsgKey(ri.Attributes["resource_group_name"], ri.Attributes["name"]),
+			space:   parsePrefixes(space),
+		})
+	}
+	return vnets
+}
+
+func subnetsOf(tfState *State) []ipamSubnet {
+	var subnets []ipamSubnet
+	for _, ri := range tfState.InstancesByType("azurerm_subnet") {
+		id, _ := ri.Attr().String("id")
+		prefixes, _ := ri.Attr().Strings("address_prefixes")
+		subnets = append(subnets, ipamSubnet{
+			ResourceInstance: ri,
+			id:               strings.ToLower(id),
+			vnet:             nsgKey(ri.Attributes["resource_group_name"], ri.Attributes["virtual_network_name"]),
+			prefixes:         parsePrefixes(prefixes),
+		})
+	}
+	return subnets
+}
+
+// Every subnet prefix lies inside one of its VNet's address_space prefixes
+func checkSubnetsWithinVNets(tfState *State) CheckResult {
+	vnets := map[string]ipamVNet{}
+	for _, v := range vnetsOf(tfState, "") {
+		vnets[v.key] = v
+	}
+	subnets := subnetsOf(tfState)
+	instances := make([]ResourceInstance, len(subnets))
+	byAddress := map[string]ipamSubnet{}
+	for i, s := range subnets {
+		instances[i] = s.ResourceInstance
+		byAddress[s.Address()] = s
+	}
+	return eachInstance(instances, func(ri ResourceInstance) CheckResult {
+		s := byAddress[ri.Address()]
+		vnet, ok := vnets[s.vnet]
+		if !ok {
+			return notApplicable("parent VNet " + s.vnet + " is not in this state")
+		}
+		for _, p := range s.prefixes {
+			if !containedIn(p, vnet.space) {
+				return fail(fmt.Sprintf("prefix %s is outside %s address_space %v", p, vnet.address, vnet.space))
+			}
+		}
+		return pass(1)
+	})
+}
+
+func containedIn(p netip.Prefix, space []netip.Prefix) bool {
+	for _, s := range space {
+		if s.Bits() <= p.Bits() && s.Contains(p.Addr()) {
+			return true
+		}
+	}
+	return false
+}
+
+// No two subnets of the same VNet share addresses
+func checkSubnetOverlaps(tfState *State) CheckResult {
+	subnets := subnetsOf(tfState)
+	instances := make([]ResourceInstance, len(subnets))
+	byAddress := map[string]ipamSubnet{}
+	for i, s := range subnets {
+		instances[i] = s.ResourceInstance
+		byAddress[s.Address()] = s
+	}
+	return eachInstance(instances, func(ri ResourceInstance) CheckResult {
+		self := byAddress[ri.Address()]
+		var clashes []string
+		for _, other := range subnets {
+			if other.Address() == self.Address() || other.vnet != self.vnet {
+				continue
+			}
+			for _, p := range self.prefixes {
+				for _, q := range other.prefixes {
+					if p.Overlaps(q) {
+						clashes = append(clashes, fmt.Sprintf("%s overlaps %s of %s", p, q, other.Address()))
+					}
+				}
+			}
+		}
+		if len(clashes) > 0 {
+			return fail(strings.Join(clashes, "; "))
+		}
+		return pass(1)
+	})
+}
+
+// No VNet of this state shares addresses with another VNet here or in a related state, e.g. the hub
+func checkVNetOverlaps(tfState *State, related []NamedState) CheckResult {
+	own := vnetsOf(tfState, "")
+	all := append([]ipamVNet{}, own...)
+	for _, r := range related {
+		all = append(all, vnetsOf(r.State, r.Name)...)
+	}
+
+	res := CheckResult{Pass: true}
+	for _, v := range own {
+		var clashes []string
+		for _, other := range all {
+			if other.state == v.state && other.address == v.address {
+				continue
+			}
+			where := other.address
+			if other.state != "" {
+				where = other.state + ": " + other.address
+			}
+			for _, p := range v.space {
+				for _, q := range other.space {
+					if p.Overlaps(q) {
+						clashes = append(clashes, fmt.Sprintf("%s overlaps %s of %s", p, q, where))
+					}
+				}
+			}
+		}
+		r := pass(1)
+		if len(clashes) > 0 {
+			r = fail(strings.Join(clashes, "; "))
+		}
+		res.Instances = append(res.Instances, InstanceResult{Address: v.address, CheckResult: r})
+		res.Evaluated += r.Evaluated
+		res.Pass = res.Pass && r.Pass
+	}
+	return res
+}
+
+// A private IP with the subnet it is allocated from
+type privateIP struct {
+	addr     string
+	subnetID string
+}
+
+// Private IPs of NICs, private endpoints and APIM instances, by the instance that holds them
+func privateIPsOf(ri ResourceInstance) []privateIP {
+	a := ri.Attr()
+	var ips []privateIP
+	switch ri.Type {
+	case "azurerm_network_interface":
+		configs, _ := a.List("ip_configuration")
+		for i := range configs {
+			// Dynamic addresses are unknown until apply, so a plan has none
+			if addr, err := a.String(fmt.Sprintf("ip_configuration.%d.private_ip_address", i)); err == nil && addr != "" {
+				subnet, _ := a.String(fmt.Sprintf("ip_configuration.%d.subnet_id", i))
+				ips = append(ips, privateIP{addr, subnet})
+			}
+		}
+	case "azurerm_private_endpoint":
+		subnet, _ := a.String("subnet_id")
+		for _, block := range []string{"private_service_connection", "ip_configuration"} {
+			items, _ := a.List(block)
+			for i := range items {
+				if addr, err := a.String(fmt.Sprintf("%s.%d.private_ip_address", block, i)); err == nil {
+					ips = append(ips, privateIP{addr, subnet})
+				}
+			}
+		}
+	case "azurerm_api_management":
+		subnet, _ := a.String("virtual_network_configuration.0.subnet_id")
+		addrs, _ := a.Strings("private_ip_addresses")
+		for _, addr := range addrs {
+			ips = append(ips, privateIP{addr, subnet})
+		}
+	}
+	return ips
+}
+
+// Private IPs lie inside their subnet and avoid the addresses Azure reserves in every subnet
+func checkPrivateIPPlacement(tfState *State) CheckResult {
+	subnets := map[string]ipamSubnet{}
+	for _, s := range subnetsOf(tfState) {
+		subnets[s.id] = s
+	}
+	var holders []ResourceInstance
+	for _, t := range []string{"azurerm_network_interface", "azurerm_private_endpoint", "azurerm_api_management"} {
+		for _, ri := range tfState.InstancesByType(t) {
+			if len(privateIPsOf(ri)) > 0 {
+				holders = append(holders, ri)
+			}
+		}
+	}
+	return eachInstance(holders, func(ri ResourceInstance) CheckResult {
+		checked := 0
+		var problems []string
+		for _, ip := range privateIPsOf(ri) {
+			addr, err := netip.ParseAddr(ip.addr)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%q is not an IP address", ip.addr))
+				continue
+			}
+			subnet, ok := subnets[strings.ToLower(ip.subnetID)]
+			if !ok {
+				continue // subnet lives in another state
+			}
+			checked++
+			if problem := placementProblem(addr, subnet.prefixes); problem != "" {
+				problems = append(problems, fmt.Sprintf("%s %s of %s", addr, problem, subnet.Address()))
+			}
+		}
+		switch {
+		case len(problems) > 0:
+			return fail(strings.Join(problems, "; "))
+		case checked == 0:
+			return notApplicable("subnet is not in this state")
+		}
+		return pass(1)
+	})
+}
+
+// Why an address cannot be used in a subnet: outside every prefix, or one of
+// the network address, .1 (gateway), .2 and .3 (Azure DNS) or the broadcast address
+func placementProblem(addr netip.Addr, prefixes []netip.Prefix) string {
+	for _, p := range prefixes {
+		if !p.Contains(addr) {
+			continue
+		}
+		offset := 0
+		for a := p.Addr(); a != addr && offset < 4; a = a.Next() {
+			offset++
+		}
+		if offset < 4 {
+			return fmt.Sprintf("is reserved by Azure (offset %d in %s)", offset, p)
+		}
+		if addr == lastAddr(p) {
+			return fmt.Sprintf("is the broadcast address of %s", p)
+		}
+		return ""
+	}
+	return fmt.Sprintf("is outside prefixes %v", prefixes)
+}
+
+func lastAddr(p netip.Prefix) netip.Addr {
+	b := p.Masked().Addr().AsSlice()
+	hostBits := len(b)*8 - p.Bits()
+	for i := len(b) - 1; i >= 0 && hostBits > 0; i-- {
+		n := hostBits
+		if n > 8 {
+			n = 8
+		}
+		b[i] |= byte(1<<n - 1)
+		hostBits -= n
+	}
+	addr, _ := netip.AddrFromSlice(b)
+	return addr
+}
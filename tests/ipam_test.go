@@ -0,0 +1,111 @@
+package test
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Address plan checks across VNets, subnets and the private IPs allocated from them
+func RunIPAMTests(tfState *State, related []NamedState) []TestCase {
+	tests := []GenericTest{
+		{"1._Verify_Subnets_Within_VNet_Address_Space", "IPAMTests", func() CheckResult {
+			return checkSubnetsWithinVNets(tfState)
+		}},
+		{"2._Verify_Subnets_Do_Not_Overlap", "IPAMTests", func() CheckResult {
+			return checkSubnetOverlaps(tfState)
+		}},
+		{"3._Verify_VNets_Do_Not_Overlap_Across_States", "IPAMTests", func() CheckResult {
+			return checkVNetOverlaps(tfState, related)
+		}},
+		{"4._Verify_Private_IPs_Inside_Subnet_And_Not_Reserved", "IPAMTests", func() CheckResult {
+			return checkPrivateIPPlacement(tfState)
+		}},
+	}
+
+	return executeTestCases(tests)
+}
+
+// The spoke with a subnet typed into the wrong range and one reusing another's /24
+const ipamState = `{
+	"version": 4,
+	"resources": [
+		{"module": "module.spoke.module.spoke_vnet", "mode": "managed", "type": "azurerm_virtual_network", "name": "vnet",
+		 "instances": [{"attributes": {"name": "spoke-vnet", "resource_group_name": "spk-rg", "address_space": ["10.110.0.0/16"]}}]},
+		{"module": "module.spoke.module.subnet_bastion", "mode": "managed", "type": "azurerm_subnet", "name": "subnet",
+		 "instances": [{"attributes": {"id": "/s/bst-snet", "resource_group_name": "spk-rg", "virtual_network_name": "spoke-vnet", "address_prefixes": ["10.110.10.0/24"]}}]},
+		{"module": "module.spoke.module.subnet_exp", "mode": "managed", "type": "azurerm_subnet", "name": "subnet",
+		 "instances": [{"attributes": {"id": "/s/exp-snet", "resource_group_name": "spk-rg", "virtual_network_name": "spoke-vnet", "address_prefixes": ["10.110.20.0/24"]}}]},
+		{"module": "module.spoke.module.subnet_proc", "mode": "managed", "type": "azurerm_subnet", "name": "subnet",
+		 "instances": [{"attributes": {"id": "/s/proc-snet", "resource_group_name": "spk-rg", "virtual_network_name": "spoke-vnet", "address_prefixes": ["10.111.30.0/24"]}}]},
+		{"module": "module.spoke.module.subnet_procfapp", "mode": "managed", "type": "azurerm_subnet", "name": "subnet",
+		 "instances": [{"attributes": {"id": "/s/procfapp-snet", "resource_group_name": "spk-rg", "virtual_network_name": "spoke-vnet", "address_prefixes": ["10.110.20.128/25"]}}]},
+		{"module": "module.bastion.module.bastion_vm", "mode": "managed", "type": "azurerm_network_interface", "name": "this",
+		 "instances": [{"attributes": {"ip_configuration": [{"subnet_id": "/s/bst-snet", "private_ip_address": "10.110.10.4"}]}}]},
+		{"module": "module.exp.module.apim", "mode": "managed", "type": "azurerm_api_management", "name": "this",
+		 "instances": [{"attributes": {"private_ip_addresses": ["10.110.20.3"], "virtual_network_configuration": [{"subnet_id": "/s/exp-snet"}]}}]},
+		{"module": "module.sys.module.pep", "mode": "managed", "type": "azurerm_private_endpoint", "name": "this",
+		 "instances": [{"attributes": {"subnet_id": "/S/BST-SNET", "private_service_connection": [{"private_ip_address": "10.110.11.5"}]}}]}
+	]
+}`
+
+func TestIPAMChecks(t *testing.T) {
+	tfState, err := parseState([]byte(ipamState))
+	require.NoError(t, err)
+
+	messages := func(res CheckResult) map[string]string {
+		m := map[string]string{}
+		for _, inst := range res.Instances {
+			if !inst.Pass {
+				m[inst.Address] = inst.Message
+			}
+		}
+		return m
+	}
+
+	outside := messages(checkSubnetsWithinVNets(tfState))
+	assert.Len(t, outside, 1)
+	assert.Contains(t, outside["module.spoke.module.subnet_proc.azurerm_subnet.subnet"], "10.111.30.0/24 is outside")
+
+	overlapping := messages(checkSubnetOverlaps(tfState))
+	assert.Len(t, overlapping, 2, "both sides of an overlap are reported")
+	assert.Contains(t, overlapping["module.spoke.module.subnet_exp.azurerm_subnet.subnet"], "overlaps 10.110.20.128/25 of module.spoke.module.subnet_procfapp")
+
+	placement := messages(checkPrivateIPPlacement(tfState))
+	assert.Len(t, placement, 2, "the bastion NIC at .4 is fine")
+	assert.Contains(t, placement["module.exp.module.apim.azurerm_api_management.this"], "10.110.20.3 is reserved by Azure (offset 3")
+	assert.Contains(t, placement["module.sys.module.pep.azurerm_private_endpoint.this"], "is outside prefixes [10.110.10.0/24]", "subnet ids compare case-insensitively")
+}
+
+func TestVNetOverlapsSpanRelatedStates(t *testing.T) {
+	tfState, err := parseState([]byte(ipamState))
+	require.NoError(t, err)
+	hub, err := parseState([]byte(`{"version": 4, "resources": [
+		{"mode": "managed", "type": "azurerm_virtual_network", "name": "hub",
+		 "instances": [{"attributes": {"name": "hub-vnet", "resource_group_name": "hub-rg", "address_space": ["10.100.0.0/16", "10.110.128.0/17"]}}]}
+	]}`))
+	require.NoError(t, err)
+
+	assert.True(t, checkVNetOverlaps(tfState, nil).Pass)
+
+	res := checkVNetOverlaps(tfState, []NamedState{{Name: "hub", State: hub}})
+	assert.False(t, res.Pass)
+	require.Len(t, res.Instances, 1, "only this state's VNets are reported")
+	assert.Equal(t, "10.110.0.0/16 overlaps 10.110.128.0/17 of hub: azurerm_virtual_network.hub", res.Instances[0].Message)
+}
+
+func TestPlacementProblem(t *testing.T) {
+	prefixes := []netip.Prefix{netip.MustParsePrefix("10.110.10.0/24")}
+	for addr, want := range map[string]string{
+		"10.110.10.0":   "is reserved by Azure (offset 0 in 10.110.10.0/24)",
+		"10.110.10.1":   "is reserved by Azure (offset 1 in 10.110.10.0/24)",
+		"10.110.10.4":   "",
+		"10.110.10.254": "",
+		"10.110.10.255": "is the broadcast address of 10.110.10.0/24",
+		"10.110.11.4":   "is outside prefixes [10.110.10.0/24]",
+	} {
+		assert.Equal(t, want, placementProblem(netip.MustParseAddr(addr), prefixes), addr)
+	}
+}
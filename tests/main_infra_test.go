@@ -1,114 +1,145 @@
 package test
 
-func RunMainInfraTests(tfState map[string]interface{}) []TestCase {
+// related holds other states, e.g. the hub, so peerings can be matched across them
+func RunMainInfraTests(tfState *State, related []NamedState) []TestCase {
 	tests := []GenericTest{
-		{"1._Validate_Resource_Group", "AzureMainInfraTests", func() (bool, string) {
-			for _, rg := range findResourcesByType(tfState, "azurerm_resource_group") {
+		{"1._Validate_Resource_Group", "AzureMainInfraTests", func() CheckResult {
+			return eachInstance(tfState.InstancesByType("azurerm_resource_group"), func(ri ResourceInstance) CheckResult {
+				rg := ri.Attributes
 				if rg["name"] == "" {
-					return false, "Resource Group name is empty"
+					return fail("Resource Group name is empty")
 				}
-			}
-			return true, ""
+				return pass(1)
+			})
 		}},
-		{"2._Validate_Virtual_Network", "AzureMainInfraTests", func() (bool, string) {
-			for _, vnet := range findResourcesByType(tfState, "azurerm_virtual_network") {
-				if vnet["name"] == "" {
-					return false, "VNet name is empty"
+		{"2._Validate_Virtual_Network", "AzureMainInfraTests", func() CheckResult {
+			return eachInstance(tfState.InstancesByType("azurerm_virtual_network"), func(vnet ResourceInstance) CheckResult {
+				if vnet.Attributes["name"] == "" {
+					return fail("VNet name is empty")
 				}
-				if len(vnet["address_space"].([]interface{})) == 0 {
-					return false, "VNet address space is empty"
+				space, err := vnet.Attr().List("address_space")
+				if err != nil {
+					return fail(err.Error())
 				}
-			}
-			return true, ""
+				if len(space) == 0 {
+					return fail("VNet address space is empty")
+				}
+				return pass(1)
+			})
 		}},
-		{"3._Validate_Subnet", "AzureMainInfraTests", func() (bool, string) {
-			for _, sn := range findResourcesByType(tfState, "azurerm_subnet") {
-				if sn["name"] == "" {
-					return false, "Subnet name is empty"
+		{"3._Validate_Subnet", "AzureMainInfraTests", func() CheckResult {
+			return eachInstance(tfState.InstancesByType("azurerm_subnet"), func(sn ResourceInstance) CheckResult {
+				if sn.Attributes["name"] == "" {
+					return fail("Subnet name is empty")
+				}
+				prefixes, err := sn.Attr().List("address_prefixes")
+				if err != nil {
+					return fail(err.Error())
 				}
-				if len(sn["address_prefixes"].([]interface{})) == 0 {
-					return false, "Subnet address_prefixes is empty"
+				if len(prefixes) == 0 {
+					return fail("Subnet address_prefixes is empty")
 				}
-			}
-			return true, ""
+				return pass(1)
+			})
 		}},
-		{"4._Validate_NSG", "AzureMainInfraTests", func() (bool, string) {
-			for _, nsg := range findResourcesByType(tfState, "azurerm_network_security_group") {
+		{"4._Validate_NSG", "AzureMainInfraTests", func() CheckResult {
+			return eachInstance(tfState.InstancesByType("azurerm_network_security_group"), func(ri ResourceInstance) CheckResult {
+				nsg := ri.Attributes
 				if nsg["name"] == "" {
-					return false, "NSG name is empty"
+					return fail("NSG name is empty")
 				}
-			}
-			return true, ""
+				return pass(1)
+			})
 		}},
-		{"5._Validate_NSG_Subnet_Association", "AzureMainInfraTests", func() (bool, string) {
-			for _, sn := range findResourcesByType(tfState, "azurerm_subnet") {
-				_ = sn["network_security_group_id"]
-			}
-			return true, ""
+		{"5._Validate_NSG_Subnet_Association", "AzureMainInfraTests", func() CheckResult {
+			return eachInstance(tfState.InstancesByType("azurerm_subnet_network_security_group_association"), func(ri ResourceInstance) CheckResult {
+				assoc := ri.Attributes
+				if subnetID, _ := assoc["subnet_id"].(string); subnetID == "" {
+					return fail("NSG association has no subnet_id")
+				}
+				if nsgID, _ := assoc["network_security_group_id"].(string); nsgID == "" {
+					return fail("NSG association has no network_security_group_id")
+				}
+				return pass(1)
+			})
 		}},
-		{"6._Validate_VM", "AzureMainInfraTests", func() (bool, string) {
-			for _, vm := range findResourcesByType(tfState, "azurerm_virtual_machine") {
+		{"6._Validate_VM", "AzureMainInfraTests", func() CheckResult {
+			return eachInstance(tfState.InstancesByType("azurerm_virtual_machine"), func(ri ResourceInstance) CheckResult {
+				vm := ri.Attributes
 				if vm["name"] == "" {
-					return false, "VM name is empty"
+					return fail("VM name is empty")
 				}
-			}
-			return true, ""
+				return pass(1)
+			})
 		}},
-		{"7._Validate_NIC", "AzureMainInfraTests", func() (bool, string) {
-			for _, nic := range findResourcesByType(tfState, "azurerm_network_interface") {
+		{"7._Validate_NIC", "AzureMainInfraTests", func() CheckResult {
+			return eachInstance(tfState.InstancesByType("azurerm_network_interface"), func(ri ResourceInstance) CheckResult {
+				nic := ri.Attributes
 				if nic["name"] == "" {
-					return false, "NIC name is empty"
+					return fail("NIC name is empty")
 				}
-			}
-			return true, ""
+				return pass(1)
+			})
 		}},
-		{"8._Validate_App_Gateway", "AzureMainInfraTests", func() (bool, string) {
-			for _, agw := range findResourcesByType(tfState, "azurerm_application_gateway") {
+		{"8._Validate_App_Gateway", "AzureMainInfraTests", func() CheckResult {
+			return eachInstance(tfState.InstancesByType("azurerm_application_gateway"), func(ri ResourceInstance) CheckResult {
+				agw := ri.Attributes
 				if agw["name"] == "" {
-					return false, "Application Gateway name is empty"
+					return fail("Application Gateway name is empty")
 				}
-			}
-			return true, ""
+				return pass(1)
+			})
 		}},
-		{"9._Validate_APIM", "AzureMainInfraTests", func() (bool, string) {
-			for _, apim := range findResourcesByType(tfState, "azurerm_api_management") {
+		{"9._Validate_APIM", "AzureMainInfraTests", func() CheckResult {
+			return eachInstance(tfState.InstancesByType("azurerm_api_management"), func(ri ResourceInstance) CheckResult {
+				apim := ri.Attributes
 				if apim["name"] == "" {
-					return false, "APIM name is empty"
+					return fail("APIM name is empty")
 				}
-			}
-			return true, ""
+				return pass(1)
+			})
 		}},
-		{"10._Validate_DNS_Zone", "AzureMainInfraTests", func() (bool, string) {
-			for _, dns := range findResourcesByType(tfState, "azurerm_dns_zone") {
+		{"10._Validate_DNS_Zone", "AzureMainInfraTests", func() CheckResult {
+			return eachInstance(tfState.InstancesByType("azurerm_dns_zone"), func(ri ResourceInstance) CheckResult {
+				dns := ri.Attributes
 				if dns["name"] == "" {
-					return false, "DNS Zone name is empty"
+					return fail("DNS Zone name is empty")
 				}
-			}
-			return true, ""
+				return pass(1)
+			})
 		}},
-		{"11._Validate_Public_IP", "AzureMainInfraTests", func() (bool, string) {
-			for _, pip := range findResourcesByType(tfState, "azurerm_public_ip") {
+		{"11._Validate_Public_IP", "AzureMainInfraTests", func() CheckResult {
+			return eachInstance(tfState.InstancesByType("azurerm_public_ip"), func(ri ResourceInstance) CheckResult {
+				pip := ri.Attributes
 				if pip["name"] == "" {
-					return false, "Public IP name is empty"
+					return fail("Public IP name is empty")
 				}
-			}
-			return true, ""
+				return pass(1)
+			})
 		}},
-		{"12._Validate_VNET_Peering", "AzureMainInfraTests", func() (bool, string) {
-			for _, peer := range findResourcesByType(tfState, "azurerm_virtual_network_peering") {
+		{"12._Validate_VNET_Peering", "AzureMainInfraTests", func() CheckResult {
+			return eachInstance(tfState.InstancesByType("azurerm_virtual_network_peering"), func(ri ResourceInstance) CheckResult {
+				peer := ri.Attributes
 				if peer["name"] == "" {
-					return false, "VNET peering name is empty"
+					return fail("VNET peering name is empty")
 				}
-			}
-			return true, ""
+				return pass(1)
+			})
 		}},
-		{"13._Validate_NSG_Rules", "AzureMainInfraTests", func() (bool, string) {
-			for _, nsg := range findResourcesByType(tfState, "azurerm_network_security_group") {
+		{"13._Validate_NSG_Rules", "AzureMainInfraTests", func() CheckResult {
+			return eachInstance(tfState.InstancesByType("azurerm_network_security_group"), func(ri ResourceInstance) CheckResult {
+				nsg := ri.Attributes
 				if nsg["security_rule"] == nil {
-					return false, "NSG has no security_rule block"
+					return fail("NSG has no security_rule block")
 				}
-			}
-			return true, ""
+				return pass(1)
+			})
+		}},
+		{"14._Validate_VNET_Peering_Has_Reverse_Peering", "AzureMainInfraTests", func() CheckResult {
+			return checkPeeringSymmetry(tfState, related)
+		}},
+		{"15._Validate_VNET_Peering_Settings_Match_Reverse", "AzureMainInfraTests", func() CheckResult {
+			return checkPeeringSettings(tfState, related)
 		}},
 	}
 
@@ -1,34 +1,43 @@
 package test
 
 import (
-	"sync"
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
 
 type testModule struct {
 	Name string
-	Func func(map[string]interface{}) []TestCase
+	Func func(*State) []TestCase
 }
 
-func getAllTestModules() []testModule {
+// Suites that compare against environment values get them bound from exp,
+// and suites spanning several states get the related states
+func getAllTestModules(exp *Expectations, related []NamedState) []testModule {
+	withExpectations := func(run func(*State, *Expectations) []TestCase) func(*State) []TestCase {
+		return func(tfState *State) []TestCase { return run(tfState, exp) }
+	}
+	withRelatedStates := func(run func(*State, []NamedState) []TestCase) func(*State) []TestCase {
+		return func(tfState *State) []TestCase { return run(tfState, related) }
+	}
 	return []testModule{
-		{"MainInfra", RunMainInfraTests},
-		{"DevInfra", RunDevInfraTests},
-		{"Bastion", RunBastionTests},
+		{"MainInfra", withRelatedStates(RunMainInfraTests)},
+		{"DevInfra", withExpectations(RunDevInfraTests)},
+		{"Bastion", withExpectations(RunBastionTests)},
 		{"Proc", RunProcTests},
 		{"Spoke", RunSpokeTests},
 		{"Epp", RunEppTests},
-		{"Exp", RunExpTests},
+		{"Exp", withExpectations(RunExpTests)},
 		{"Src", RunSrcTests},
 		{"Sys", RunSysTests},
-		{"APIM", RunAPIMTests},
+		{"APIM", withExpectations(RunAPIMTests)},
 		{"AppGateway", RunAppGatewayTests},
-		{"EventHub", RunEventHubTests},
+		{"EventHub", withExpectations(RunEventHubTests)},
 		{"FuncNetCore8ISO", RunFunctionAppNetCore8ISOTests},
 		{"FuncApp", RunFunctionAppTests},
-		{"LogAnalytics", RunLogAnalyticsTests},
+		{"LogAnalytics", withExpectations(RunLogAnalyticsTests)},
 		{"NSG", RunNSGTests},
 		{"PrivateEndpoint", RunPrivateEndpointTests},
 		{"PublicIP", RunPublicIPTests},
@@ -39,43 +48,154 @@ func getAllTestModules() []testModule {
 		{"SubnetDelegation", RunSubnetWithDelegationTests},
 		{"VNet", RunVNetValidationTests},
 		{"WindowsVM", RunWindowsVMValidationTests},
+		{"EnvironmentConfig", withExpectations(RunEnvironmentConfigTests)},
+		{"Reachability", withExpectations(RunReachabilityTests)},
+		{"IPAM", withRelatedStates(RunIPAMTests)},
+		{"PrivatePosture", withExpectations(RunPrivatePostureTests)},
+		{"Diagnostics", withExpectations(RunDiagnosticsTests)},
+		{"TagPolicy", withExpectations(RunTagPolicyTests)},
 	}
 }
 
 func TestAllModulesFromMain(t *testing.T) {
 	cfg := LoadTestConfig(t) // Load config from flag/env/config.json
-	tfState := loadRemoteTFState(t, cfg.RemoteStateURL)
-
-	var suite TestSuite
-	var mu sync.Mutex
+	tfState, source := loadTFState(t, cfg)
+	props := stateProperties(cfg, tfState, source.Describe())
 
-	modules := getAllTestModules()
+	modules := getAllTestModules(loadEnvExpectations(t, cfg), loadRelatedStates(t, cfg))
+	suites := make([]TestSuite, len(modules)) // one <testsuite> per module, kept in module order
 
-	for _, mod := range modules {
-		mod := mod // capture range variable
+	for i, mod := range modules {
+		i, mod := i, mod // capture range variables
 
 		t.Run(mod.Name, func(t *testing.T) {
 			t.Parallel() // Run subtest in parallel
 
+			started := time.Now()
 			testCases := mod.Func(tfState)
 
-			mu.Lock()
-			defer mu.Unlock()
-
 			for _, tc := range testCases {
 				assert.NotEmpty(t, tc.Name, "Test case should have a name")
-				assert.Contains(t, []string{"PASS", "FAIL"}, tc.Status, "Test case should have valid status")
-
-				suite.TestCases = append(suite.TestCases, tc)
-				suite.Tests++
-				if tc.Status == "FAIL" {
-					suite.Failures++
-				}
+				assert.Contains(t, validStatuses, tc.Status, "Test case should have valid status")
 			}
+			suites[i] = newTestSuite(mod.Name, started, props, testCases)
 		})
 	}
 
 	t.Cleanup(func() {
-		writeReport(t, suite, "reports/overall_modules_parallel_report.xml")
+		var report TestSuites
+		for _, suite := range suites {
+			report.add(suite)
+		}
+		writeReport(t, report, "reports/overall_modules_parallel_report.xml")
+		checkThresholds(t, cfg, report)
+	})
+}
+
+var validStatuses = []string{StatusPass, StatusFail, StatusSkipped, StatusError}
+
+// Runs before apply: plan-only change assertions plus every module suite against planned values
+func TestPlanFromMain(t *testing.T) {
+	cfg := LoadTestConfig(t)
+	if cfg.PlanFile == "" {
+		t.Skip("No plan file. Use -planFile or set TF_PLAN_FILE.")
+	}
+	plan := loadTFPlan(t, cfg)
+	plannedState := plan.PlannedState()
+	props := planProperties(cfg, plan)
+
+	exp := loadEnvExpectations(t, cfg)
+	modules := getAllTestModules(exp, loadRelatedStates(t, cfg))
+	suites := make([]TestSuite, len(modules)+1)
+
+	t.Run("PlanChanges", func(t *testing.T) {
+		started := time.Now()
+		testCases := RunPlanTests(plan, exp)
+		for _, tc := range testCases {
+			assert.Nil(t, tc.Failure, "%s: %v", tc.Name, tc.Failure)
+			assert.Nil(t, tc.Error, "%s: %v", tc.Name, tc.Error)
+		}
+		suites[0] = newTestSuite("PlanChanges", started, props, testCases)
+	})
+
+	t.Run("PlannedValues", func(t *testing.T) {
+		for i, mod := range modules {
+			i, mod := i, mod
+
+			t.Run(mod.Name, func(t *testing.T) {
+				t.Parallel()
+
+				started := time.Now()
+				testCases := mod.Func(plannedState)
+				for j := range testCases {
+					testCases[j].Classname = "Plan." + testCases[j].Classname
+				}
+				suites[i+1] = newTestSuite("Plan."+mod.Name, started, props, testCases)
+			})
+		}
+	})
+
+	t.Cleanup(func() {
+		var report TestSuites
+		for _, suite := range suites {
+			report.add(suite)
+		}
+		writeReport(t, report, "reports/plan_modules_report.xml")
+		checkThresholds(t, cfg, report)
+	})
+}
+
+// Sits between Plan and Apply: fails when the plan deletes or replaces a protected resource
+func TestPlanGuardrails(t *testing.T) {
+	cfg := LoadTestConfig(t)
+	if cfg.PlanFile == "" {
+		t.Skip("No plan file. Use -planFile or set TF_PLAN_FILE.")
+	}
+	plan := loadTFPlan(t, cfg)
+
+	policy, allow, err := loadGuardrails(cfg.Environment)
+	if err != nil {
+		fatalf(t, "❌ Failed to load guardrails for %q: %v", cfg.Environment, err)
+	}
+
+	started := time.Now()
+	testCases := RunGuardrailTests(plan, policy, allow)
+	for _, tc := range testCases {
+		assert.Nil(t, tc.Failure, "%s: %v", tc.Name, tc.Failure)
+		assert.Nil(t, tc.Error, "%s: %v", tc.Name, tc.Error)
+	}
+
+	var report TestSuites
+	report.add(newTestSuite("PlanGuardrails", started, planProperties(cfg, plan), testCases))
+
+	t.Cleanup(func() {
+		writeReport(t, report, "reports/plan_guardrails_report.xml")
 	})
 }
+
+// Not a pass/fail check: writes reports/address_capacity.{json,txt} with each VNet's
+// allocated subnets, utilization, fragmentation and next free prefixes. Uses state
+// from the configured source and adds the planned spoke CIDRs; skipped without a state.
+func TestAddressCapacityReport(t *testing.T) {
+	cfg := LoadTestConfig(t)
+	if _, err := stateSourceFromConfig(cfg); err != nil {
+		t.Skip("No state to report on. Use -stateFile, -remoteStateURL or a configured backend.")
+	}
+	exp := loadEnvExpectations(t, cfg)
+
+	tfState, _ := loadTFState(t, cfg)
+	report := capacityFromState(tfState, "state", exp.capacityPrefixLengths())
+	for _, r := range loadRelatedStates(t, cfg) {
+		report = append(report, capacityFromState(r.State, r.Name, exp.capacityPrefixLengths())...)
+	}
+	report = append(report, capacityFromExpectations(exp)...)
+	if len(report) == 0 {
+		t.Skip("No VNets in state and no spoke_vnet_cidr for this environment.")
+	}
+
+	if err := writeCapacityReport(report, "reports"); err != nil {
+		fatalf(t, "❌ Failed to write capacity report: %v", err)
+	}
+	logf(t, "%s", formatCapacity(report))
+	fmt.Println("📄 Capacity report written to reports/address_capacity.txt")
+}
@@ -0,0 +1,341 @@
+package test
+
+import (
+	"fmt"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Inbound ports that must never be reachable from the Internet: RDP, SSH and WinRM
+var managementPorts = []int{22, 3389, 5985, 5986}
+
+// Finding kinds reported by analyzeNSGRules
+const (
+	FindingManagementExposed = "management-exposed"
+	FindingDuplicatePriority = "duplicate-priority"
+	FindingShadowed          = "shadowed"
+	FindingContradicts       = "contradicts-deny"
+)
+
+// NSGRule is one security rule, from an inline security_rule block of an
+// azurerm_network_security_group or a standalone azurerm_network_security_rule
+type NSGRule struct {
+	Address             string // resource address, or <nsg address>.security_rule["<name>"] for inline rules
+	NSG                 string // <resource group>/<nsg name>
+	Name                string
+	Priority            int
+	Direction           string
+	Access              string
+	Protocol            string
+	SourcePorts         []portRange
+	DestinationPorts    []portRange
+	SourcePrefixes      []string
+	DestinationPrefixes []string
+}
+
+// NSGFinding is one problem with a rule
+type NSGFinding struct {
+	Kind    string
+	Message string
+}
+
+type portRange struct{ lo, hi int }
+
+// Gather every rule in state, grouped by the NSG it belongs to. A rule managed
+// as its own resource also shows up in the NSG's security_rule list after a
+// refresh; the standalone resource wins so findings carry its address.
+func collectNSGRules(tfState *State) []NSGRule {
+	var rules []NSGRule
+	seen := map[string]bool{}
+	for _, ri := range tfState.InstancesByType("azurerm_network_security_rule") {
+		a := ri.Attributes
+		nsg := nsgKey(a["resource_group_name"], a["network_security_group_name"])
+		rule := newNSGRule(ri.Address(), nsg, a)
+		seen[nsg+"/"+rule.Name] = true
+		rules = append(rules, rule)
+	}
+	for _, ri := range tfState.InstancesByType("azurerm_network_security_group") {
+		nsg := nsgKey(ri.Attributes["resource_group_name"], ri.Attributes["name"])
+		inline, _ := ri.Attributes["security_rule"].([]interface{})
+		for _, item := range inline {
+			a, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			name, _ := a["name"].(string)
+			if seen[nsg+"/"+name] {
+				continue
+			}
+			rules = append(rules, newNSGRule(ri.Address()+".security_rule["+strconv.Quote(name)+"]", nsg, a))
+		}
+	}
+	sort.SliceStable(rules, func(i, j int) bool {
+		if rules[i].NSG != rules[j].NSG {
+			return rules[i].NSG < rules[j].NSG
+		}
+		return rules[i].Priority < rules[j].Priority
+	})
+	return rules
+}
+
+// Azure resource names are case-insensitive, and ids in state do not always match the name's case
+func nsgKey(group, name interface{}) string {
+	return strings.ToLower(fmt.Sprintf("%v/%v", group, name))
+}
+
+func newNSGRule(address, nsg string, a map[string]interface{}) NSGRule {
+	name, _ := a["name"].(string)
+	priority, _ := a["priority"].(float64)
+	direction, _ := a["direction"].(string)
+	access, _ := a["access"].(string)
+	protocol, _ := a["protocol"].(string)
+	return NSGRule{
+		Address:             address,
+		NSG:                 nsg,
+		Name:                name,
+		Priority:            int(priority),
+		Direction:           direction,
+		Access:              access,
+		Protocol:            protocol,
+		SourcePorts:         parsePortRanges(singleOrList(a, "source_port_range", "source_port_ranges")),
+		DestinationPorts:    parsePortRanges(singleOrList(a, "destination_port_range", "destination_port_ranges")),
+		SourcePrefixes:      singleOrList(a, "source_address_prefix", "source_address_prefixes"),
+		DestinationPrefixes: singleOrList(a, "destination_address_prefix", "destination_address_prefixes"),
+	}
+}
+
+// Rules set either the singular attribute or its plural list; the other is empty
+func singleOrList(a map[string]interface{}, single, plural string) []string {
+	if s, _ := a[single].(string); s != "" {
+		return []string{s}
+	}
+	var values []string
+	list, _ := a[plural].([]interface{})
+	for _, item := range list {
+		if s, ok := item.(string); ok && s != "" {
+			values = append(values, s)
+		}
+	}
+	return values
+}
+
+// "*", "443" or "1000-2000"; an unreadable range is treated as any port so it is never missed
+func parsePortRanges(specs []string) []portRange {
+	var ranges []portRange
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		lo, hi, isRange := strings.Cut(spec, "-")
+		if !isRange {
+			hi = lo
+		}
+		l, errLo := strconv.Atoi(lo)
+		h, errHi := strconv.Atoi(hi)
+		if spec == "*" || errLo != nil || errHi != nil {
+			l, h = 0, 65535
+		}
+		ranges = append(ranges, portRange{l, h})
+	}
+	return ranges
+}
+
+// Analyze the rules of each NSG, returning the findings for every rule address
+// that has any. Only rules with the same direction in the same NSG interact.
+func analyzeNSGRules(rules []NSGRule) map[string][]NSGFinding {
+	findings := map[string][]NSGFinding{}
+	add := func(r NSGRule, kind, format string, args ...interface{}) {
+		findings[r.Address] = append(findings[r.Address], NSGFinding{Kind: kind, Message: fmt.Sprintf(format, args...)})
+	}
+
+	for i, r := range rules {
+		if exposed := r.exposedManagementPorts(); len(exposed) > 0 {
+			add(r, FindingManagementExposed, "rule %s allows inbound %s from %s to management port(s) %v",
+				r.Name, r.Protocol, strings.Join(r.SourcePrefixes, ","), exposed)
+		}
+
+		for j, other := range rules {
+			if i == j || other.NSG != r.NSG || !strings.EqualFold(other.Direction, r.Direction) {
+				continue
+			}
+			if other.Priority == r.Priority {
+				add(r, FindingDuplicatePriority, "rule %s shares priority %d with %s", r.Name, r.Priority, other.Name)
+				continue
+			}
+			if other.Priority > r.Priority {
+				continue
+			}
+			// other is evaluated first. An Allow below a broader Deny is the usual
+			// deny-then-allow mistake; a Deny below a broader Allow is a deny-all
+			// fallback and is only reported when it is fully shadowed.
+			switch {
+			case other.covers(r):
+				add(r, FindingShadowed, "rule %s (priority %d) never matches: %s rule %s (priority %d) already matches all its traffic",
+					r.Name, r.Priority, other.Access, other.Name, other.Priority)
+			case strings.EqualFold(r.Access, "Allow") && strings.EqualFold(other.Access, "Deny") && other.overlaps(r):
+				add(r, FindingContradicts, "Allow rule %s (priority %d) is partly overridden by Deny rule %s (priority %d)",
+					r.Name, r.Priority, other.Name, other.Priority)
+			}
+		}
+	}
+	return findings
+}
+
+// Ports of managementPorts an inbound Allow rule opens to any source or the Internet
+func (r NSGRule) exposedManagementPorts() []int {
+	if !strings.EqualFold(r.Direction, "Inbound") || !strings.EqualFold(r.Access, "Allow") {
+		return nil
+	}
+	if !protocolCovers(r.Protocol, "Tcp") {
+		return nil
+	}
+	public := false
+	for _, prefix := range r.SourcePrefixes {
+		public = public || isInternetPrefix(prefix)
+	}
+	if !public {
+		return nil
+	}
+	var exposed []int
+	for _, port := range managementPorts {
+		for _, pr := range r.DestinationPorts {
+			if pr.lo <= port && port <= pr.hi {
+				exposed = append(exposed, port)
+				break
+			}
+		}
+	}
+	return exposed
+}
+
+// r matches every packet other matches
+func (r NSGRule) covers(other NSGRule) bool {
+	return protocolCovers(r.Protocol, other.Protocol) &&
+		prefixesCover(r.SourcePrefixes, other.SourcePrefixes) &&
+		prefixesCover(r.DestinationPrefixes, other.DestinationPrefixes) &&
+		portsCover(r.SourcePorts, other.SourcePorts) &&
+		portsCover(r.DestinationPorts, other.DestinationPorts)
+}
+
+// Some packet matches both rules
+func (r NSGRule) overlaps(other NSGRule) bool {
+	return (protocolCovers(r.Protocol, other.Protocol) || protocolCovers(other.Protocol, r.Protocol)) &&
+		prefixesOverlap(r.SourcePrefixes, other.SourcePrefixes) &&
+		prefixesOverlap(r.DestinationPrefixes, other.DestinationPrefixes) &&
+		portsOverlap(r.SourcePorts, other.SourcePorts) &&
+		portsOverlap(r.DestinationPorts, other.DestinationPorts)
+}
+
+func protocolCovers(a, b string) bool {
+	return a == "*" || strings.EqualFold(a, b)
+}
+
+func isAnyPrefix(p string) bool {
+	return p == "*" || strings.EqualFold(p, "Any") || p == "0.0.0.0/0"
+}
+
+func isInternetPrefix(p string) bool {
+	return isAnyPrefix(p) || strings.EqualFold(p, "Internet")
+}
+
+// Whether prefix a contains prefix b. Service tags other than * only contain themselves.
+func prefixCovers(a, b string) bool {
+	if isAnyPrefix(a) || strings.EqualFold(a, b) {
+		return true
+	}
+	pa, errA := parsePrefix(a)
+	pb, errB := parsePrefix(b)
+	return errA == nil && errB == nil && pa.Bits() <= pb.Bits() && pa.Contains(pb.Addr())
+}
+
+// Whether prefixes a and b can both match an address. Service tags are
+// conservatively assumed to overlap with any CIDR but not with other tags.
+func prefixOverlaps(a, b string) bool {
+	if prefixCovers(a, b) || prefixCovers(b, a) {
+		return true
+	}
+	_, errA := parsePrefix(a)
+	_, errB := parsePrefix(b)
+	return (errA == nil) != (errB == nil)
+}
+
+// A CIDR or a bare address
+func parsePrefix(s string) (netip.Prefix, error) {
+	if p, err := netip.ParsePrefix(s); err == nil {
+		return p.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+func prefixesCover(a, b []string) bool {
+	for _, pb := range b {
+		covered := false
+		for _, pa := range a {
+			covered = covered || prefixCovers(pa, pb)
+		}
+		if !covered {
+			return false
+		}
+	}
+	return len(a) > 0
+}
+
+func prefixesOverlap(a, b []string) bool {
+	for _, pa := range a {
+		for _, pb := range b {
+			if prefixOverlaps(pa, pb) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func portsCover(a, b []portRange) bool {
+	for _, pb := range b {
+		covered := false
+		for _, pa := range a {
+			covered = covered || (pa.lo <= pb.lo && pb.hi <= pa.hi)
+		}
+		if !covered {
+			return false
+		}
+	}
+	return len(a) > 0
+}
+
+func portsOverlap(a, b []portRange) bool {
+	for _, pa := range a {
+		for _, pb := range b {
+			if pa.lo <= pb.hi && pb.lo <= pa.hi {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// One result per rule: the rule's findings of the given kind, or a pass
+func nsgFindingsOf(rules []NSGRule, findings map[string][]NSGFinding, kind string) CheckResult {
+	res := CheckResult{Pass: true}
+	for _, rule := range rules {
+		var messages []string
+		for _, f := range findings[rule.Address] {
+			if f.Kind == kind {
+				messages = append(messages, f.Message)
+			}
+		}
+		r := pass(1)
+		if len(messages) > 0 {
+			r = fail(strings.Join(messages, "; "))
+		}
+		res.Instances = append(res.Instances, InstanceResult{Address: rule.Address, CheckResult: r})
+		res.Evaluated += r.Evaluated
+		res.Pass = res.Pass && r.Pass
+	}
+	return res
+}
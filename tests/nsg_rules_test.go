@@ -0,0 +1,91 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Standalone rules as the nsg resource module creates them from *_nsg_rules, plus one inline rule
+const nsgRulesState = `{
+	"version": 4,
+	"resources": [
+		{"module": "module.spoke.module.nsg_bastion", "mode": "managed", "type": "azurerm_network_security_group", "name": "this",
+		 "instances": [{"attributes": {"name": "bst-nsg", "resource_group_name": "spk-rg", "security_rule": [
+			{"name": "allow_private_in", "priority": 100, "direction": "Inbound", "access": "Allow", "protocol": "*",
+			 "source_port_range": "*", "destination_port_range": "*", "source_address_prefix": "VirtualNetwork", "destination_address_prefix": "*"},
+			{"name": "inline_https", "priority": 300, "direction": "Inbound", "access": "Allow", "protocol": "Tcp",
+			 "source_port_range": "*", "destination_port_range": "", "destination_port_ranges": ["443", "8443"],
+			 "source_address_prefix": "", "source_address_prefixes": ["10.110.0.0/16"], "destination_address_prefix": "*"}
+		 ]}}]},
+		{"module": "module.spoke.module.nsg_bastion", "mode": "managed", "type": "azurerm_network_security_rule", "name": "rules",
+		 "instances": [
+			{"index_key": "allow_private_in", "attributes": {"name": "allow_private_in", "priority": 100, "direction": "Inbound", "access": "Allow", "protocol": "*",
+			 "source_port_range": "*", "destination_port_range": "*", "source_address_prefix": "VirtualNetwork", "destination_address_prefix": "*",
+			 "resource_group_name": "spk-rg", "network_security_group_name": "bst-nsg"}},
+			{"index_key": "deny_rdp", "attributes": {"name": "deny_rdp", "priority": 110, "direction": "Inbound", "access": "Deny", "protocol": "Tcp",
+			 "source_port_range": "*", "destination_port_range": "3389", "source_address_prefix": "*", "destination_address_prefix": "*",
+			 "resource_group_name": "spk-rg", "network_security_group_name": "bst-nsg"}},
+			{"index_key": "allow_public_in", "attributes": {"name": "allow_public_in", "priority": 120, "direction": "Inbound", "access": "Allow", "protocol": "*",
+			 "source_port_range": "*", "destination_port_range": "*", "source_address_prefix": "0.0.0.0/0", "destination_address_prefix": "*",
+			 "resource_group_name": "spk-rg", "network_security_group_name": "bst-nsg"}},
+			{"index_key": "allow_ssh_again", "attributes": {"name": "allow_ssh_again", "priority": 120, "direction": "Inbound", "access": "Allow", "protocol": "Tcp",
+			 "source_port_range": "*", "destination_port_range": "22", "source_address_prefix": "10.110.10.0/24", "destination_address_prefix": "*",
+			 "resource_group_name": "spk-rg", "network_security_group_name": "bst-nsg"}},
+			{"index_key": "allow_private_out", "attributes": {"name": "allow_private_out", "priority": 120, "direction": "Outbound", "access": "Allow", "protocol": "*",
+			 "source_port_range": "*", "destination_port_range": "*", "source_address_prefix": "*", "destination_address_prefix": "VirtualNetwork",
+			 "resource_group_name": "spk-rg", "network_security_group_name": "bst-nsg"}}
+		 ]}
+	]
+}`
+
+func TestAnalyzeNSGRules(t *testing.T) {
+	tfState, err := parseState([]byte(nsgRulesState))
+	require.NoError(t, err)
+
+	rules := collectNSGRules(tfState)
+	require.Len(t, rules, 6, "the inline copy of a standalone rule is not counted twice")
+	byName := map[string]NSGRule{}
+	for _, r := range rules {
+		byName[r.Name] = r
+	}
+	assert.Equal(t, `module.spoke.module.nsg_bastion.azurerm_network_security_rule.rules["deny_rdp"]`, byName["deny_rdp"].Address)
+	assert.Equal(t, `module.spoke.module.nsg_bastion.azurerm_network_security_group.this.security_rule["inline_https"]`, byName["inline_https"].Address)
+	assert.Equal(t, []portRange{{443, 443}, {8443, 8443}}, byName["inline_https"].DestinationPorts)
+
+	findings := analyzeNSGRules(rules)
+	kinds := func(name string) []string {
+		var k []string
+		for _, f := range findings[byName[name].Address] {
+			k = append(k, f.Kind)
+		}
+		return k
+	}
+
+	assert.Empty(t, kinds("allow_private_in"))
+	assert.Empty(t, kinds("allow_private_out"), "priority 120 outbound does not clash with priority 120 inbound")
+	assert.Equal(t, []string{FindingManagementExposed, FindingContradicts, FindingDuplicatePriority}, kinds("allow_public_in"))
+	assert.Contains(t, findings[byName["allow_public_in"].Address][0].Message, "[22 3389 5985 5986]")
+	assert.Equal(t, []string{FindingDuplicatePriority}, kinds("allow_ssh_again"), "rules sharing a priority do not shadow each other")
+	assert.Equal(t, []string{FindingShadowed}, kinds("inline_https"))
+
+	res := nsgFindingsOf(rules, findings, FindingShadowed)
+	assert.False(t, res.Pass)
+	require.Len(t, res.Instances, len(rules), "one result per rule")
+}
+
+func TestNSGPrefixAndPortMatching(t *testing.T) {
+	assert.True(t, prefixCovers("*", "VirtualNetwork"))
+	assert.True(t, prefixCovers("10.110.0.0/16", "10.110.10.0/24"))
+	assert.True(t, prefixCovers("10.110.0.0/16", "10.110.10.4"))
+	assert.False(t, prefixCovers("10.110.10.0/24", "10.110.0.0/16"))
+	assert.False(t, prefixCovers("VirtualNetwork", "10.110.0.0/16"), "service tags are not expanded")
+	assert.True(t, prefixOverlaps("VirtualNetwork", "10.110.0.0/16"), "but are assumed to overlap CIDRs")
+	assert.False(t, prefixOverlaps("VirtualNetwork", "Internet"))
+
+	assert.Equal(t, []portRange{{0, 65535}, {1000, 2000}, {22, 22}, {0, 65535}}, parsePortRanges([]string{"*", "1000-2000", "22", "ssh"}))
+	assert.True(t, portsCover([]portRange{{0, 65535}}, []portRange{{22, 22}}))
+	assert.False(t, portsCover([]portRange{{1000, 2000}}, []portRange{{22, 22}, {1500, 1500}}))
+	assert.True(t, portsOverlap([]portRange{{1000, 2000}}, []portRange{{22, 22}, {1500, 1500}}))
+}
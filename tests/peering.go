@@ -0,0 +1,145 @@
+package test
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Peering settings that must agree with the reverse peering for the hub-spoke model
+var peeringFlags = []string{"allow_virtual_network_access", "allow_forwarded_traffic", "allow_gateway_transit", "use_remote_gateways"}
+
+// A VNet peering from this state or a related one, by the ids of the VNets it joins
+type vnetPeering struct {
+	ResourceInstance
+	state    string // "" for the environment's own state, else the related state's name
+	local    string // lowercased id of the VNet the peering belongs to, "" when unknown
+	remote   string // lowercased remote_virtual_network_id, "" until known
+	settings map[string]bool
+}
+
+func (p vnetPeering) where() string {
+	if p.state == "" {
+		return p.Address()
+	}
+	return p.state + ": " + p.Address()
+}
+
+// Peerings and VNet ids of this state and the related ones. VNets read through
+// a data source count: the spoke module looks the hub up that way.
+func collectPeerings(tfState *State, related []NamedState) ([]vnetPeering, map[string]bool) {
+	states := append([]NamedState{{State: tfState}}, related...)
+	vnetIDs := map[string]bool{}
+	idsByKey := map[string]string{}
+	for _, s := range states {
+		for _, ri := range s.State.Instances() {
+			if ri.Type != "azurerm_virtual_network" {
+				continue
+			}
+			if id, _ := ri.Attr().String("id"); id != "" {
+				vnetIDs[strings.ToLower(id)] = true
+				idsByKey[nsgKey(ri.Attributes["resource_group_name"], ri.Attributes["name"])] = strings.ToLower(id)
+			}
+		}
+	}
+
+	var peerings []vnetPeering
+	for _, s := range states {
+		for _, ri := range s.State.InstancesByType("azurerm_virtual_network_peering") {
+			a := ri.Attr()
+			p := vnetPeering{ResourceInstance: ri, state: s.Name, settings: map[string]bool{}}
+			remote, _ := a.String("remote_virtual_network_id")
+			p.remote = strings.ToLower(remote)
+			// A peering id is <vnet id>/virtualNetworkPeerings/<name>; before apply, go by the VNet's name
+			id, _ := a.String("id")
+			if i := strings.Index(strings.ToLower(id), "/virtualnetworkpeerings/"); i > 0 {
+				p.local = strings.ToLower(id[:i])
+			} else {
+				p.local = idsByKey[nsgKey(ri.Attributes["resource_group_name"], ri.Attributes["virtual_network_name"])]
+			}
+			for _, flag := range peeringFlags {
+				p.settings[flag], _ = a.Bool(flag)
+			}
+			peerings = append(peerings, p)
+		}
+	}
+	return peerings, vnetIDs
+}
+
+// The peering back from p's remote VNet to p's own
+func reversePeering(p vnetPeering, peerings []vnetPeering) (vnetPeering, bool) {
+	for _, q := range peerings {
+		if q.local != "" && q.local == p.remote && q.remote == p.local {
+			return q, true
+		}
+	}
+	return vnetPeering{}, false
+}
+
+// One result per peering of this state, related states only supply the other side
+func eachPeering(tfState *State, related []NamedState, check func(p vnetPeering, all []vnetPeering, vnetIDs map[string]bool) CheckResult) CheckResult {
+	peerings, vnetIDs := collectPeerings(tfState, related)
+	own := map[string]vnetPeering{}
+	var instances []ResourceInstance
+	for _, p := range peerings {
+		if p.state == "" {
+			own[p.Address()] = p
+			instances = append(instances, p.ResourceInstance)
+		}
+	}
+	return eachInstance(instances, func(ri ResourceInstance) CheckResult {
+		return check(own[ri.Address()], peerings, vnetIDs)
+	})
+}
+
+// Every peering's remote VNet exists and peers back, in this state or a related one
+func checkPeeringSymmetry(tfState *State, related []NamedState) CheckResult {
+	return eachPeering(tfState, related, func(p vnetPeering, all []vnetPeering, vnetIDs map[string]bool) CheckResult {
+		switch {
+		case p.remote == "":
+			return notApplicable("remote_virtual_network_id is not known yet")
+		case !vnetIDs[p.remote]:
+			return fail(fmt.Sprintf("remote_virtual_network_id %s does not resolve to a VNet in this or a related state", p.remote))
+		case p.local == "":
+			return fail("the peering's own VNet is not in this or a related state")
+		}
+		reverse, ok := reversePeering(p, all)
+		if !ok {
+			return fail(fmt.Sprintf("no peering from %s back to %s; add the reverse peering or the related state that holds it", p.remote, p.local))
+		}
+		return passWith(1, "reverse peering "+reverse.where())
+	})
+}
+
+// Both directions of a peering agree: the same access and forwarding, and gateway
+// transit offered on exactly the side the other uses remote gateways from
+func checkPeeringSettings(tfState *State, related []NamedState) CheckResult {
+	return eachPeering(tfState, related, func(p vnetPeering, all []vnetPeering, _ map[string]bool) CheckResult {
+		reverse, ok := reversePeering(p, all)
+		if !ok {
+			return notApplicable("no reverse peering to compare with")
+		}
+		if problems := peeringSettingsProblems(p, reverse); len(problems) > 0 {
+			return fail(strings.Join(problems, "; "))
+		}
+		return pass(1)
+	})
+}
+
+func peeringSettingsProblems(p, reverse vnetPeering) []string {
+	var problems []string
+	for _, flag := range []string{"allow_virtual_network_access", "allow_forwarded_traffic"} {
+		if p.settings[flag] != reverse.settings[flag] {
+			problems = append(problems, fmt.Sprintf("%s is %t but %t on %s", flag, p.settings[flag], reverse.settings[flag], reverse.where()))
+		}
+	}
+	if p.settings["allow_gateway_transit"] && p.settings["use_remote_gateways"] {
+		problems = append(problems, "allow_gateway_transit and use_remote_gateways cannot both be set on one peering")
+	}
+	if p.settings["use_remote_gateways"] && !reverse.settings["allow_gateway_transit"] {
+		problems = append(problems, fmt.Sprintf("use_remote_gateways is set but %s does not allow gateway transit", reverse.where()))
+	}
+	if p.settings["allow_gateway_transit"] && !reverse.settings["use_remote_gateways"] {
+		problems = append(problems, fmt.Sprintf("allow_gateway_transit is set but %s does not use remote gateways", reverse.where()))
+	}
+	return problems
+}
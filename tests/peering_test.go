@@ -0,0 +1,104 @@
+package test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	hubVNetID   = "/subscriptions/s/resourceGroups/hub-rg/providers/Microsoft.Network/virtualNetworks/hub-vnet"
+	spokeVNetID = "/subscriptions/s/resourceGroups/spk-rg/providers/Microsoft.Network/virtualNetworks/spoke-vnet"
+)
+
+// The Dev state: the spoke VNet, its peering to the hub and the hub read through a data source
+func peeringSpokeState(t *testing.T, toHub string) *State {
+	tfState, err := parseState([]byte(`{"version": 4, "resources": [
+		{"module": "module.spoke.module.spoke_vnet", "mode": "managed", "type": "azurerm_virtual_network", "name": "this",
+		 "instances": [{"attributes": {"id": "` + spokeVNetID + `", "name": "spoke-vnet", "resource_group_name": "spk-rg"}}]},
+		{"module": "module.spoke.module.spoke_vnet", "mode": "data", "type": "azurerm_virtual_network", "name": "hub",
+		 "instances": [{"attributes": {"id": "` + hubVNetID + `", "name": "hub-vnet", "resource_group_name": "hub-rg"}}]},
+		{"module": "module.spoke.module.spoke_vnet", "mode": "managed", "type": "azurerm_virtual_network_peering", "name": "to_hub",
+		 "instances": [{"attributes": ` + toHub + `}]}
+	]}`))
+	require.NoError(t, err)
+	return tfState
+}
+
+// The hub's state with its peering back to the spoke
+func peeringHubState(t *testing.T, fromHub string) []NamedState {
+	hub, err := parseState([]byte(`{"version": 4, "resources": [
+		{"mode": "managed", "type": "azurerm_virtual_network", "name": "hub",
+		 "instances": [{"attributes": {"id": "` + hubVNetID + `", "name": "hub-vnet", "resource_group_name": "hub-rg"}}]},
+		{"mode": "managed", "type": "azurerm_virtual_network_peering", "name": "to_spoke",
+		 "instances": [{"attributes": ` + fromHub + `}]}
+	]}`))
+	require.NoError(t, err)
+	return []NamedState{{Name: "hub", State: hub}}
+}
+
+func peeringAttrs(id, vnet, rg, remote string, flags ...string) string {
+	set := map[string]bool{"allow_virtual_network_access": true, "allow_forwarded_traffic": true}
+	for _, f := range flags {
+		name, value, _ := strings.Cut(f, "=")
+		set[name] = value == "true"
+	}
+	attrs := `{"id": "` + id + `", "virtual_network_name": "` + vnet + `", "resource_group_name": "` + rg + `", "remote_virtual_network_id": "` + remote + `"`
+	for _, flag := range peeringFlags {
+		if set[flag] {
+			attrs += `, "` + flag + `": true`
+		} else {
+			attrs += `, "` + flag + `": false`
+		}
+	}
+	return attrs + "}"
+}
+
+func TestPeeringSymmetry(t *testing.T) {
+	toHub := peeringAttrs(spokeVNetID+"/virtualNetworkPeerings/spoke-to-hub", "spoke-vnet", "spk-rg", hubVNetID)
+	fromHub := peeringAttrs(hubVNetID+"/virtualNetworkPeerings/hub-to-spoke", "hub-vnet", "hub-rg", spokeVNetID)
+	spoke := peeringSpokeState(t, toHub)
+
+	res := checkPeeringSymmetry(spoke, nil)
+	assert.False(t, res.Pass)
+	assert.Contains(t, res.Instances[0].Message, "no peering from "+strings.ToLower(hubVNetID)+" back to "+strings.ToLower(spokeVNetID))
+
+	res = checkPeeringSymmetry(spoke, peeringHubState(t, fromHub))
+	assert.True(t, res.Pass, res.Message)
+	require.Len(t, res.Instances, 1, "only this state's peerings are reported")
+	assert.Equal(t, "reverse peering hub: azurerm_virtual_network_peering.to_spoke", res.Instances[0].Message)
+
+	dangling := peeringSpokeState(t, peeringAttrs(spokeVNetID+"/virtualNetworkPeerings/x", "spoke-vnet", "spk-rg", "/subscriptions/s/resourceGroups/old/providers/Microsoft.Network/virtualNetworks/gone"))
+	res = checkPeeringSymmetry(dangling, nil)
+	assert.False(t, res.Pass)
+	assert.Contains(t, res.Instances[0].Message, "does not resolve to a VNet")
+}
+
+func TestPeeringSettings(t *testing.T) {
+	toHub := peeringAttrs(spokeVNetID+"/virtualNetworkPeerings/spoke-to-hub", "spoke-vnet", "spk-rg", hubVNetID, "use_remote_gateways=true")
+	spoke := peeringSpokeState(t, toHub)
+
+	assert.Equal(t, StatusSkipped, executeTestCases([]GenericTest{{"settings", "PeeringTests", func() CheckResult {
+		return checkPeeringSettings(spoke, nil)
+	}}})[0].Status, "nothing to compare without the hub")
+
+	consistent := peeringHubState(t, peeringAttrs(hubVNetID+"/virtualNetworkPeerings/hub-to-spoke", "hub-vnet", "hub-rg", spokeVNetID, "allow_gateway_transit=true"))
+	res := checkPeeringSettings(spoke, consistent)
+	assert.True(t, res.Pass, res.Message)
+
+	mismatched := peeringHubState(t, peeringAttrs(hubVNetID+"/virtualNetworkPeerings/hub-to-spoke", "hub-vnet", "hub-rg", spokeVNetID, "allow_forwarded_traffic=false"))
+	res = checkPeeringSettings(spoke, mismatched)
+	assert.False(t, res.Pass)
+	assert.Equal(t, "allow_forwarded_traffic is true but false on hub: azurerm_virtual_network_peering.to_spoke; "+
+		"use_remote_gateways is set but hub: azurerm_virtual_network_peering.to_spoke does not allow gateway transit", res.Instances[0].Message)
+}
+
+// Before apply a peering has no id; its VNet is found by name
+func TestPeeringMatchesByVNetNameWithoutID(t *testing.T) {
+	toHub := peeringAttrs("", "spoke-vnet", "SPK-RG", hubVNetID)
+	fromHub := peeringAttrs("", "hub-vnet", "hub-rg", spokeVNetID)
+	res := checkPeeringSymmetry(peeringSpokeState(t, toHub), peeringHubState(t, fromHub))
+	assert.True(t, res.Pass, res.Message)
+}
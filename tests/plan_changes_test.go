@@ -0,0 +1,105 @@
+package test
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Plan-only assertions over resource_changes; state checks run separately against planned values
+func RunPlanTests(plan *Plan, exp *Expectations) []TestCase {
+	tests := []GenericTest{
+		// Deletes and replaces are listed only: whether one is allowed is decided by the
+		// guardrails, against their protected list and allow-list, in TestPlanGuardrails
+		{"1._Report_Managed_Resources_Deleted", "PlanChangeTests", func() CheckResult {
+			return reportPlannedChanges(plan, Actions.Delete, "deletes")
+		}},
+		{"2._Report_Managed_Resources_Replaced", "PlanChangeTests", func() CheckResult {
+			return reportPlannedChanges(plan, Actions.Replace, "replaces")
+		}},
+		{"3._Verify_No_Inbound_Allow_From_Internet_Introduced", "PlanChangeTests", func() CheckResult {
+			var open []string
+			evaluated := 0
+			for _, rc := range plan.ChangesWith(Actions.Writes) {
+				rules := plannedSecurityRules(rc)
+				evaluated += len(rules)
+				for _, rule := range rules {
+					if rule["direction"] == "Inbound" && rule["access"] == "Allow" && isInternetSource(rule["source_address_prefix"]) {
+						open = append(open, fmt.Sprintf("%s (%v, port %v)", rc.Address, rule["name"], rule["destination_port_range"]))
+					}
+				}
+			}
+			if len(open) > 0 {
+				return fail("Plan allows inbound traffic from the Internet: " + strings.Join(open, "; "))
+			}
+			return pass(evaluated)
+		}},
+		{"4._Verify_Planned_APIM_Network_Type", "PlanChangeTests", func() CheckResult {
+			// Held to the same expectation as the state-side APIM checks, on module.exp only
+			apims := 0
+			for _, rc := range plan.ChangesWith(Actions.Writes) {
+				if rc.Type != "azurerm_api_management" || !inModule(rc.ModuleAddress, "exp") {
+					continue
+				}
+				apims++
+				networkType, err := rc.AfterAttrs().String("virtual_network_type")
+				if err != nil {
+					return fail(err.Error())
+				}
+				if want := exp.apimVirtualNetworkType(); networkType != want {
+					return fail(fmt.Sprintf("%s plans virtual_network_type=%s, expected %s", rc.Address, networkType, want))
+				}
+			}
+			return pass(apims)
+		}},
+	}
+
+	return executeTestCases(tests)
+}
+
+// A module address lies in the named top-level module call, e.g. module.exp.module.apim in exp
+func inModule(moduleAddress, name string) bool {
+	segments := moduleSegments(moduleAddress)
+	return len(segments) > 0 && segments[0] == name
+}
+
+// List the changes taking an action; never fails
+func reportPlannedChanges(plan *Plan, action func(Actions) bool, verb string) CheckResult {
+	var addresses []string
+	for _, rc := range plan.ChangesWith(action) {
+		addresses = append(addresses, rc.Address)
+	}
+	if len(addresses) == 0 {
+		return passWith(len(plan.ResourceChanges), "Plan "+verb+" no resources")
+	}
+	return passWith(len(plan.ResourceChanges), fmt.Sprintf("Plan %s %d resource(s): %s", verb, len(addresses), strings.Join(addresses, ", ")))
+}
+
+// Rules from a standalone azurerm_network_security_rule or an NSG's inline security_rule blocks
+func plannedSecurityRules(rc ResourceChange) []map[string]interface{} {
+	after := rc.AfterAttrs()
+	switch rc.Type {
+	case "azurerm_network_security_rule":
+		return []map[string]interface{}{after.Values}
+	case "azurerm_network_security_group":
+		inline, err := after.List("security_rule")
+		if err != nil {
+			return nil
+		}
+		var rules []map[string]interface{}
+		for _, r := range inline {
+			if rule, ok := r.(map[string]interface{}); ok {
+				rules = append(rules, rule)
+			}
+		}
+		return rules
+	}
+	return nil
+}
+
+func isInternetSource(prefix interface{}) bool {
+	switch prefix {
+	case "*", "0.0.0.0/0", "Internet", "Any":
+		return true
+	}
+	return false
+}
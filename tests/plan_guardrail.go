@@ -0,0 +1,154 @@
+package test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Directory holding <env>.json protected-resource policies and <env>.allow.json allow-lists
+const guardrailDir = "guardrails"
+
+// GuardrailPolicy lists the resources a plan must never delete or replace in an environment
+type GuardrailPolicy struct {
+	Protected []ProtectedResource `json:"protected"`
+}
+
+type ProtectedResource struct {
+	Address string `json:"address"` // address pattern, see ParseAddressPattern
+	Reason  string `json:"reason"`
+}
+
+// GuardrailAllowList records intentional destructive changes, one entry per instance address
+type GuardrailAllowList struct {
+	Allowed []AllowedChange `json:"allowed"`
+}
+
+type AllowedChange struct {
+	Address string `json:"address"` // exact instance address as shown in the plan
+	Reason  string `json:"reason"`
+}
+
+// GuardrailFinding is a planned delete or replace of a protected resource
+type GuardrailFinding struct {
+	Address      string
+	Action       string
+	Protection   string   // why the resource is protected
+	ForcedBy     []string // attributes that forced a replacement, from replace_paths
+	ActionReason string
+	Allowed      bool
+	AllowReason  string
+}
+
+// Load guardrails/<env>.json and the optional guardrails/<env>.allow.json
+func loadGuardrails(env string) (*GuardrailPolicy, *GuardrailAllowList, error) {
+	policy := &GuardrailPolicy{}
+	if err := readGuardrailFile(filepath.Join(guardrailDir, env+".json"), policy); err != nil {
+		return nil, nil, err
+	}
+	for _, p := range policy.Protected {
+		if _, err := ParseAddressPattern(p.Address); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	allow := &GuardrailAllowList{}
+	err := readGuardrailFile(filepath.Join(guardrailDir, env+".allow.json"), allow)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, nil, err
+	}
+	for _, a := range allow.Allowed {
+		if strings.TrimSpace(a.Reason) == "" {
+			return nil, nil, fmt.Errorf("allow-list entry for %s has no reason", a.Address)
+		}
+	}
+	return policy, allow, nil
+}
+
+func readGuardrailFile(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// AnalyzeDestructiveChanges returns one finding per planned delete or replace of a protected resource
+func AnalyzeDestructiveChanges(plan *Plan, policy *GuardrailPolicy, allow *GuardrailAllowList) []GuardrailFinding {
+	allowed := map[string]string{}
+	if allow != nil {
+		for _, a := range allow.Allowed {
+			allowed[a.Address] = a.Reason
+		}
+	}
+
+	var findings []GuardrailFinding
+	for _, rc := range plan.ChangesWith(Actions.Destroys) {
+		protection, ok := policy.protects(rc)
+		if !ok {
+			continue
+		}
+		reason, isAllowed := allowed[rc.Address]
+		findings = append(findings, GuardrailFinding{
+			Address:      rc.Address,
+			Action:       rc.Change.Actions.String(),
+			Protection:   protection,
+			ForcedBy:     formatReplacePaths(rc.Change.ReplacePaths),
+			ActionReason: rc.ActionReason,
+			Allowed:      isAllowed,
+			AllowReason:  reason,
+		})
+	}
+	return findings
+}
+
+func (p *GuardrailPolicy) protects(rc ResourceChange) (string, bool) {
+	for _, protected := range p.Protected {
+		q, err := ParseAddressPattern(protected.Address)
+		if err == nil && q.Matches(rc.Instance()) {
+			return protected.Reason, true
+		}
+	}
+	return "", false
+}
+
+// Render replace_paths such as [["address_space", 0]] as address_space[0]
+func formatReplacePaths(paths [][]interface{}) []string {
+	var results []string
+	for _, p := range paths {
+		var b strings.Builder
+		for _, step := range p {
+			switch s := step.(type) {
+			case string:
+				if b.Len() > 0 {
+					b.WriteString(".")
+				}
+				b.WriteString(s)
+			case float64:
+				b.WriteString("[" + strconv.Itoa(int(s)) + "]")
+			default:
+				b.WriteString(fmt.Sprintf("[%v]", s))
+			}
+		}
+		results = append(results, b.String())
+	}
+	return results
+}
+
+func (f GuardrailFinding) String() string {
+	msg := fmt.Sprintf("%s would be %sd (%s)", f.Address, f.Action, f.Protection)
+	if len(f.ForcedBy) > 0 {
+		msg += "; replacement forced by " + strings.Join(f.ForcedBy, ", ")
+	}
+	if f.ActionReason != "" {
+		msg += "; reason: " + f.ActionReason
+	}
+	return msg
+}
@@ -0,0 +1,71 @@
+package test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// One test case per destructive change to a protected resource; allow-listed changes pass with their reason
+func RunGuardrailTests(plan *Plan, policy *GuardrailPolicy, allow *GuardrailAllowList) []TestCase {
+	findings := AnalyzeDestructiveChanges(plan, policy, allow)
+
+	tests := []GenericTest{
+		{"1._Verify_No_Protected_Resource_Destroyed", "PlanGuardrailTests", func() CheckResult {
+			var blocked []string
+			for _, f := range findings {
+				if !f.Allowed {
+					blocked = append(blocked, f.Address)
+				}
+			}
+			if len(blocked) > 0 {
+				return fail("Plan destroys protected resources: " + strings.Join(blocked, ", "))
+			}
+			return pass(len(plan.ResourceChanges))
+		}},
+	}
+	for _, f := range findings {
+		f := f
+		tests = append(tests, GenericTest{"Destructive_Change_" + f.Address, "PlanGuardrailTests", func() CheckResult {
+			if f.Allowed {
+				return passWith(1, "Allow-listed: "+f.AllowReason)
+			}
+			return fail(f.String())
+		}})
+	}
+
+	return executeTestCases(tests)
+}
+
+func TestGuardrailFlagsProtectedReplacement(t *testing.T) {
+	plan, err := loadPlanFile("testdata/plan.json")
+	require.NoError(t, err)
+	policy, allow, err := loadGuardrails("dev")
+	require.NoError(t, err)
+
+	findings := AnalyzeDestructiveChanges(plan, policy, allow)
+	require.Len(t, findings, 2)
+
+	vnet := findings[0]
+	assert.Equal(t, "module.spoke.module.spoke_vnet.azurerm_virtual_network.this", vnet.Address)
+	assert.Equal(t, "replace", vnet.Action)
+	assert.Equal(t, []string{"location", "address_space[0]"}, vnet.ForcedBy)
+	assert.Contains(t, vnet.String(), "replacement forced by location, address_space[0]")
+	assert.False(t, vnet.Allowed)
+
+	assert.Equal(t, "delete", findings[1].Action)
+	assert.Contains(t, findings[1].Protection, "Event Hub")
+
+	allow.Allowed = append(allow.Allowed, AllowedChange{
+		Address: "module.spoke.module.spoke_vnet.azurerm_virtual_network.this",
+		Reason:  "Re-addressing the spoke",
+	})
+	cases := RunGuardrailTests(plan, policy, allow)
+	require.Len(t, cases, 3)
+	assert.Equal(t, "FAIL", cases[0].Status)
+	assert.NotContains(t, cases[0].Failure.Message, "spoke_vnet", "allow-listed change no longer blocks")
+	assert.Equal(t, "PASS", cases[1].Status)
+	assert.Equal(t, "FAIL", cases[2].Status)
+}
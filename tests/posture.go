@@ -0,0 +1,190 @@
+package test
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Exception name for a resource allowed to have no private endpoint
+const exceptPrivateEndpoint = "private_endpoint"
+
+// PaaS types that must be private-only, with the settings that open them to the
+// Internet. The provider defaults every one of these to true when unset.
+var postureTypes = []struct {
+	Type           string
+	PublicSettings []string
+	Monitor        bool // reached privately through an Azure Monitor Private Link Scope
+}{
+	{"azurerm_api_management", []string{"public_network_access_enabled"}, false},
+	{"azurerm_windows_function_app", []string{"public_network_access_enabled"}, false},
+	{"azurerm_linux_function_app", []string{"public_network_access_enabled"}, false},
+	{"azurerm_storage_account", []string{"public_network_access_enabled"}, false},
+	{"azurerm_eventhub_namespace", []string{"public_network_access_enabled"}, false},
+	{"azurerm_application_insights", []string{"internet_ingestion_enabled", "internet_query_enabled"}, true},
+	{"azurerm_log_analytics_workspace", []string{"internet_ingestion_enabled", "internet_query_enabled"}, true},
+}
+
+// PostureException lets one environment keep a resource partly public
+type PostureException struct {
+	Address string   `json:"address"` // address pattern, see ParseAddressPattern
+	Allow   []string `json:"allow"`   // public settings left enabled, or "private_endpoint"
+	Reason  string   `json:"reason"`
+}
+
+// Why the environment lets ri keep setting, if it does
+func (e *Expectations) postureException(ri ResourceInstance, setting string) (string, bool) {
+	if e == nil {
+		return "", false
+	}
+	for _, ex := range e.PostureExceptions {
+		q, err := ParseAddressPattern(ex.Address)
+		if err != nil || !q.Matches(ri) {
+			continue
+		}
+		for _, allowed := range ex.Allow {
+			if allowed == setting {
+				return ex.Reason, true
+			}
+		}
+	}
+	return "", false
+}
+
+// PaaS instances the posture checks cover, either the Azure Monitor ones or the others
+func postureInstances(tfState *State, monitor bool) []ResourceInstance {
+	var instances []ResourceInstance
+	for _, pt := range postureTypes {
+		if pt.Monitor == monitor {
+			instances = append(instances, tfState.InstancesByType(pt.Type)...)
+		}
+	}
+	return instances
+}
+
+func publicSettingsOf(resourceType string) []string {
+	for _, pt := range postureTypes {
+		if pt.Type == resourceType {
+			return pt.PublicSettings
+		}
+	}
+	return nil
+}
+
+// Fail for every public setting left enabled that the environment has no exception for
+func checkPublicSettings(ri ResourceInstance, exp *Expectations) CheckResult {
+	var open, excepted []string
+	for _, setting := range publicSettingsOf(ri.Type) {
+		enabled, err := ri.Attr().Bool(setting)
+		if err != nil {
+			enabled = true // unset falls back to the provider default
+		}
+		if !enabled {
+			continue
+		}
+		if reason, ok := exp.postureException(ri, setting); ok {
+			excepted = append(excepted, fmt.Sprintf("%s excepted: %s", setting, reason))
+		} else {
+			open = append(open, setting+" is enabled")
+		}
+	}
+	if len(open) > 0 {
+		return fail(strings.Join(open, "; "))
+	}
+	return passWith(1, strings.Join(excepted, "; "))
+}
+
+// Public network access is disabled on every PaaS resource outside Azure Monitor
+func checkPublicNetworkAccess(tfState *State, exp *Expectations) CheckResult {
+	return eachInstance(postureInstances(tfState, false), func(ri ResourceInstance) CheckResult {
+		return checkPublicSettings(ri, exp)
+	})
+}
+
+// Application Insights and Log Analytics accept neither ingestion nor queries from the Internet
+func checkInternetIngestionAndQuery(tfState *State, exp *Expectations) CheckResult {
+	return eachInstance(postureInstances(tfState, true), func(ri ResourceInstance) CheckResult {
+		return checkPublicSettings(ri, exp)
+	})
+}
+
+// Ids of resources reachable through a private endpoint: the endpoints' targets,
+// and resources linked into an Azure Monitor Private Link Scope an endpoint targets
+func privateEndpointTargets(tfState *State) map[string]bool {
+	targets := map[string]bool{}
+	for _, pe := range tfState.InstancesByType("azurerm_private_endpoint") {
+		a := pe.Attr()
+		connections, _ := a.List("private_service_connection")
+		for i := range connections {
+			if id, _ := a.String(fmt.Sprintf("private_service_connection.%d.private_connection_resource_id", i)); id != "" {
+				targets[strings.ToLower(id)] = true
+			}
+		}
+	}
+	scopes := map[string]string{}
+	for _, ri := range tfState.InstancesByType("azurerm_monitor_private_link_scope") {
+		if id, _ := ri.Attr().String("id"); id != "" {
+			scopes[nsgKey(ri.Attributes["resource_group_name"], ri.Attributes["name"])] = strings.ToLower(id)
+		}
+	}
+	for _, ri := range tfState.InstancesByType("azurerm_monitor_private_link_scoped_service") {
+		linked, _ := ri.Attr().String("linked_resource_id")
+		scope := scopes[nsgKey(ri.Attributes["resource_group_name"], ri.Attributes["scope_name"])]
+		if linked != "" && targets[scope] {
+			targets[strings.ToLower(linked)] = true
+		}
+	}
+	return targets
+}
+
+// A private endpoint targets every PaaS resource. APIM injected into a VNet in
+// Internal mode has a private gateway address instead and cannot take one.
+func checkPrivateEndpointCoverage(tfState *State, exp *Expectations) CheckResult {
+	targets := privateEndpointTargets(tfState)
+	instances := append(postureInstances(tfState, false), postureInstances(tfState, true)...)
+	return eachInstance(instances, func(ri ResourceInstance) CheckResult {
+		id, _ := ri.Attr().String("id")
+		if id == "" {
+			return notApplicable("id is not known until apply")
+		}
+		if targets[strings.ToLower(id)] {
+			return pass(1)
+		}
+		if vnetType, _ := ri.Attr().String("virtual_network_type"); ri.Type == "azurerm_api_management" && vnetType == "Internal" {
+			return passWith(1, "reached through Internal VNet injection")
+		}
+		if reason, ok := exp.postureException(ri, exceptPrivateEndpoint); ok {
+			return passWith(1, "private endpoint excepted: "+reason)
+		}
+		return fail("no private endpoint targets " + id)
+	})
+}
+
+// Check that every exception names a known setting and has a reason
+func validatePostureExceptions(exceptions []PostureException) error {
+	known := map[string]bool{exceptPrivateEndpoint: true}
+	for _, pt := range postureTypes {
+		for _, s := range pt.PublicSettings {
+			known[s] = true
+		}
+	}
+	for _, ex := range exceptions {
+		if _, err := ParseAddressPattern(ex.Address); err != nil {
+			return err
+		}
+		if strings.TrimSpace(ex.Reason) == "" || len(ex.Allow) == 0 {
+			return fmt.Errorf("posture exception for %s needs allow and a reason", ex.Address)
+		}
+		for _, a := range ex.Allow {
+			if !known[a] {
+				names := make([]string, 0, len(known))
+				for n := range known {
+					names = append(names, n)
+				}
+				sort.Strings(names)
+				return fmt.Errorf("posture exception for %s: %q is not one of %s", ex.Address, a, strings.Join(names, ", "))
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Private-only posture of every PaaS resource: no public access and a private endpoint in front
+func RunPrivatePostureTests(tfState *State, exp *Expectations) []TestCase {
+	tests := []GenericTest{
+		{"1._Verify_Public_Network_Access_Disabled", "PrivatePostureTests", func() CheckResult {
+			return checkPublicNetworkAccess(tfState, exp)
+		}},
+		{"2._Verify_Private_Endpoint_Targets_Resource", "PrivatePostureTests", func() CheckResult {
+			return checkPrivateEndpointCoverage(tfState, exp)
+		}},
+		{"3._Verify_Monitoring_Internet_Ingestion_And_Query_Disabled", "PrivatePostureTests", func() CheckResult {
+			return checkInternetIngestionAndQuery(tfState, exp)
+		}},
+	}
+
+	return executeTestCases(tests)
+}
+
+// The function app stack: the app behind a private endpoint, its storage public,
+// App Insights open to the Internet and its workspace scoped into a private link scope
+const postureState = `{
+	"version": 4,
+	"resources": [
+		{"module": "module.proc.module.func", "mode": "managed", "type": "azurerm_windows_function_app", "name": "this",
+		 "instances": [{"attributes": {"id": "/s/rg/sites/proc-func", "public_network_access_enabled": false}}]},
+		{"module": "module.proc.module.func", "mode": "managed", "type": "azurerm_storage_account", "name": "storage",
+		 "instances": [{"attributes": {"id": "/s/rg/storageAccounts/procst", "public_network_access_enabled": true}}]},
+		{"module": "module.proc.module.func", "mode": "managed", "type": "azurerm_application_insights", "name": "this",
+		 "instances": [{"attributes": {"id": "/s/rg/components/proc-appi", "internet_ingestion_enabled": true, "internet_query_enabled": false}}]},
+		{"module": "module.proc.module.func.module.law", "mode": "managed", "type": "azurerm_log_analytics_workspace", "name": "this",
+		 "instances": [{"attributes": {"id": "/s/rg/workspaces/proc-law", "internet_ingestion_enabled": false, "internet_query_enabled": false}}]},
+		{"module": "module.exp.module.apim", "mode": "managed", "type": "azurerm_api_management", "name": "this",
+		 "instances": [{"attributes": {"id": "/s/rg/service/exp-apim", "virtual_network_type": "Internal"}}]},
+		{"mode": "managed", "type": "azurerm_monitor_private_link_scope", "name": "ampls",
+		 "instances": [{"attributes": {"id": "/s/rg/privateLinkScopes/ampls", "name": "ampls", "resource_group_name": "rg"}}]},
+		{"mode": "managed", "type": "azurerm_monitor_private_link_scoped_service", "name": "law",
+		 "instances": [{"attributes": {"linked_resource_id": "/s/rg/workspaces/proc-law", "scope_name": "ampls", "resource_group_name": "rg"}}]},
+		{"module": "module.proc.module.pep", "mode": "managed", "type": "azurerm_private_endpoint", "name": "this",
+		 "instances": [{"index_key": 0, "attributes": {"private_service_connection": [{"private_connection_resource_id": "/S/RG/SITES/PROC-FUNC"}]}},
+		               {"index_key": 1, "attributes": {"private_service_connection": [{"private_connection_resource_id": "/s/rg/privateLinkScopes/ampls"}]}}]}
+	]
+}`
+
+func TestPrivatePostureChecks(t *testing.T) {
+	tfState, err := parseState([]byte(postureState))
+	require.NoError(t, err)
+
+	public := instanceOutcomes(checkPublicNetworkAccess(tfState, nil))
+	assert.Equal(t, map[string]string{
+		"module.proc.module.func.azurerm_storage_account.storage":   "fail: public_network_access_enabled is enabled",
+		"module.exp.module.apim.azurerm_api_management.this":        "fail: public_network_access_enabled is enabled",
+		"module.proc.module.func.azurerm_windows_function_app.this": "pass: ",
+	}, public, "an unset setting takes the provider default")
+
+	internet := instanceOutcomes(checkInternetIngestionAndQuery(tfState, nil))
+	assert.Equal(t, map[string]string{
+		"module.proc.module.func.azurerm_application_insights.this":               "fail: internet_ingestion_enabled is enabled",
+		"module.proc.module.func.module.law.azurerm_log_analytics_workspace.this": "pass: ",
+	}, internet)
+
+	endpoints := instanceOutcomes(checkPrivateEndpointCoverage(tfState, nil))
+	assert.Equal(t, map[string]string{
+		"module.proc.module.func.azurerm_storage_account.storage":                 "fail: no private endpoint targets /s/rg/storageAccounts/procst",
+		"module.proc.module.func.azurerm_application_insights.this":               "fail: no private endpoint targets /s/rg/components/proc-appi",
+		"module.proc.module.func.azurerm_windows_function_app.this":               "pass: ",
+		"module.proc.module.func.module.law.azurerm_log_analytics_workspace.this": "pass: ",
+		"module.exp.module.apim.azurerm_api_management.this":                      "pass: reached through Internal VNet injection",
+	}, endpoints, "the function app, the scoped workspace and Internal APIM are private")
+}
+
+func TestPrivatePostureExceptions(t *testing.T) {
+	tfState, err := parseState([]byte(postureState))
+	require.NoError(t, err)
+	exp := &Expectations{PostureExceptions: []PostureException{
+		{Address: "module.proc.module.*.azurerm_storage_account.*", Allow: []string{"public_network_access_enabled", "private_endpoint"}, Reason: "deployment pipeline uploads packages"},
+	}}
+
+	const storage = "module.proc.module.func.azurerm_storage_account.storage"
+	public := instanceOutcomes(checkPublicNetworkAccess(tfState, exp))
+	assert.Equal(t, "pass: public_network_access_enabled excepted: deployment pipeline uploads packages", public[storage])
+	assert.Equal(t, "fail: public_network_access_enabled is enabled", public["module.exp.module.apim.azurerm_api_management.this"],
+		"exceptions apply only to matching resources")
+	assert.Equal(t, "pass: private endpoint excepted: deployment pipeline uploads packages",
+		instanceOutcomes(checkPrivateEndpointCoverage(tfState, exp))[storage])
+}
+
+func TestLoadExpectationsValidatesPostureExceptions(t *testing.T) {
+	for body, want := range map[string]string{
+		`{"address": "module.proc.**", "allow": ["public_network_access_enabled"]}`: "needs allow and a reason",
+		`{"address": "module.proc.**", "allow": ["public"], "reason": "x"}`:         `"public" is not one of`,
+	} {
+		path := filepath.Join(t.TempDir(), "dev.json")
+		require.NoError(t, os.WriteFile(path, []byte(`{"environment": "dev", "posture_exceptions": [`+body+`]}`), 0644))
+		_, err := loadExpectationsFile(path, "dev")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), want)
+	}
+}
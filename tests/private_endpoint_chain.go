@@ -0,0 +1,202 @@
+package test
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Sub-resources a private endpoint can connect to, by target type
+var privateLinkSubresources = map[string][]string{
+	"azurerm_windows_function_app": {"sites"},
+	"azurerm_linux_function_app":   {"sites"},
+	"azurerm_windows_web_app":      {"sites"},
+	"azurerm_linux_web_app":        {"sites"},
+	"azurerm_eventhub_namespace":   {"namespace"},
+	"azurerm_storage_account":      {"blob", "file", "queue", "table", "web", "dfs"},
+	"azurerm_api_management":       {"Gateway"},
+}
+
+// The privatelink zone that must resolve each sub-resource
+var privateLinkZones = map[string]string{
+	"sites":     "privatelink.azurewebsites.net",
+	"namespace": "privatelink.servicebus.windows.net",
+	"blob":      "privatelink.blob.core.windows.net",
+	"file":      "privatelink.file.core.windows.net",
+	"queue":     "privatelink.queue.core.windows.net",
+	"table":     "privatelink.table.core.windows.net",
+	"web":       "privatelink.web.core.windows.net",
+	"dfs":       "privatelink.dfs.core.windows.net",
+	"gateway":   "privatelink.azure-api.net",
+}
+
+// What a private endpoint links together: its target and sub-resources, DNS zones and address
+type privateEndpointLink struct {
+	targetID     string
+	subresources []string
+	zoneGroupSet bool
+	zoneNames    []string // private_dns_zone_group zones, by name
+	subnetID     string
+	ips          []privateIP
+}
+
+func privateEndpointLinkOf(ri ResourceInstance) privateEndpointLink {
+	a := ri.Attr()
+	link := privateEndpointLink{ips: privateIPsOf(ri)}
+	link.targetID, _ = a.String("private_service_connection.0.private_connection_resource_id")
+	link.subresources, _ = a.Strings("private_service_connection.0.subresource_names")
+	link.subnetID, _ = a.String("subnet_id")
+	groups, _ := a.List("private_dns_zone_group")
+	link.zoneGroupSet = len(groups) > 0
+	for i := range groups {
+		ids, _ := a.Strings(fmt.Sprintf("private_dns_zone_group.%d.private_dns_zone_ids", i))
+		for _, id := range ids {
+			// .../providers/Microsoft.Network/privateDnsZones/<zone>
+			link.zoneNames = append(link.zoneNames, strings.ToLower(id[strings.LastIndex(id, "/")+1:]))
+		}
+	}
+	return link
+}
+
+// Every instance in state with an id, managed or read through a data source
+func instancesByID(tfState *State) map[string]ResourceInstance {
+	byID := map[string]ResourceInstance{}
+	for _, ri := range tfState.Instances() {
+		if id, _ := ri.Attr().String("id"); id != "" {
+			byID[strings.ToLower(id)] = ri
+		}
+	}
+	return byID
+}
+
+// Follow each private endpoint from its target through the sub-resource and DNS
+// zone to the address it takes in its subnet; step is one link of that chain
+func eachPrivateEndpoint(tfState *State, step func(link privateEndpointLink, target *ResourceInstance) CheckResult) CheckResult {
+	byID := instancesByID(tfState)
+	return eachInstance(tfState.InstancesByType("azurerm_private_endpoint"), func(pe ResourceInstance) CheckResult {
+		link := privateEndpointLinkOf(pe)
+		var target *ResourceInstance
+		if ri, ok := byID[strings.ToLower(link.targetID)]; ok {
+			target = &ri
+		}
+		return step(link, target)
+	})
+}
+
+// private_connection_resource_id names a resource in this state
+func checkPrivateEndpointTargets(tfState *State) CheckResult {
+	return eachPrivateEndpoint(tfState, privateEndpointTarget)
+}
+
+func privateEndpointTarget(link privateEndpointLink, target *ResourceInstance) CheckResult {
+	switch {
+	case link.targetID == "":
+		return notApplicable("private_connection_resource_id is not known yet")
+	case target == nil:
+		return fail(fmt.Sprintf("private_connection_resource_id %s does not resolve to a resource in state", link.targetID))
+	}
+	return passWith(1, "targets "+target.Address())
+}
+
+// subresource_names are ones the target's type offers
+func checkPrivateEndpointSubresources(tfState *State) CheckResult {
+	return eachPrivateEndpoint(tfState, privateEndpointSubresources)
+}
+
+func privateEndpointSubresources(link privateEndpointLink, target *ResourceInstance) CheckResult {
+	if target == nil {
+		return notApplicable("target is not in state")
+	}
+	valid, known := privateLinkSubresources[target.Type]
+	if !known {
+		return notApplicable("no sub-resources known for " + target.Type)
+	}
+	if len(link.subresources) == 0 {
+		return fail("subresource_names is empty; " + target.Type + " takes one of " + strings.Join(valid, ", "))
+	}
+	for _, s := range link.subresources {
+		if !containsFold(valid, s) {
+			return fail(fmt.Sprintf("subresource %q is not valid for %s; want one of %s", s, target.Type, strings.Join(valid, ", ")))
+		}
+	}
+	return pass(1)
+}
+
+// The DNS zone group includes the privatelink zone of every sub-resource. The
+// target's type wins over a wrong subresource_names, which check 4 reports.
+func checkPrivateEndpointDNSZones(tfState *State) CheckResult {
+	return eachPrivateEndpoint(tfState, privateEndpointDNSZones)
+}
+
+func privateEndpointDNSZones(link privateEndpointLink, target *ResourceInstance) CheckResult {
+	subresources := link.subresources
+	if target != nil {
+		if valid, known := privateLinkSubresources[target.Type]; known {
+			subresources = nil
+			for _, s := range link.subresources {
+				if containsFold(valid, s) {
+					subresources = append(subresources, s)
+				}
+			}
+			if len(subresources) == 0 {
+				subresources = valid[:1]
+			}
+		}
+	}
+	var want []string
+	for _, s := range subresources {
+		if zone, ok := privateLinkZones[strings.ToLower(s)]; ok {
+			want = append(want, zone)
+		}
+	}
+	if len(want) == 0 {
+		return notApplicable("no privatelink zone known for " + strings.Join(subresources, ", "))
+	}
+	if !link.zoneGroupSet {
+		return fail("no private_dns_zone_group; " + strings.Join(want, ", ") + " will not resolve to the endpoint")
+	}
+	var missing []string
+	for _, zone := range want {
+		if !containsFold(link.zoneNames, zone) {
+			missing = append(missing, zone)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(link.zoneNames)
+		return fail(fmt.Sprintf("private_dns_zone_group is missing %s, has %v", strings.Join(missing, ", "), link.zoneNames))
+	}
+	return pass(1)
+}
+
+// The endpoint's NIC address lies in the endpoint's own subnet
+func checkPrivateEndpointIPs(tfState *State) CheckResult {
+	subnets := map[string]ipamSubnet{}
+	for _, s := range subnetsOf(tfState) {
+		subnets[s.id] = s
+	}
+	return eachPrivateEndpoint(tfState, func(link privateEndpointLink, _ *ResourceInstance) CheckResult {
+		subnet, ok := subnets[strings.ToLower(link.subnetID)]
+		if !ok {
+			return notApplicable("subnet is not in this state")
+		}
+		if len(link.ips) == 0 {
+			return notApplicable("private IP is not known until apply")
+		}
+		for _, ip := range link.ips {
+			addr, err := parsePrefix(ip.addr)
+			if err != nil || !containedIn(addr, subnet.prefixes) {
+				return fail(fmt.Sprintf("private IP %s is outside %s %v", ip.addr, subnet.Address(), subnet.prefixes))
+			}
+		}
+		return passWith(1, "private IP in "+subnet.Address())
+	})
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
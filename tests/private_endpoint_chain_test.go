@@ -0,0 +1,64 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// A function app endpoint wired correctly, an Event Hub endpoint copied from it
+// without adjusting, and one left pointing at a deleted storage account
+const peChainState = `{
+	"version": 4,
+	"resources": [
+		{"module": "module.proc.module.func", "mode": "managed", "type": "azurerm_windows_function_app", "name": "this",
+		 "instances": [{"attributes": {"id": "/s/rg/sites/proc-func"}}]},
+		{"module": "module.epp.module.epp_eventhub", "mode": "managed", "type": "azurerm_eventhub_namespace", "name": "this",
+		 "instances": [{"attributes": {"id": "/s/rg/namespaces/epp-ehns"}}]},
+		{"module": "module.spoke.module.subnet_epp", "mode": "managed", "type": "azurerm_subnet", "name": "subnet",
+		 "instances": [{"attributes": {"id": "/s/epp-snet", "address_prefixes": ["10.110.60.0/24"]}}]},
+		{"module": "module.proc.module.pep", "mode": "managed", "type": "azurerm_private_endpoint", "name": "this",
+		 "instances": [{"attributes": {"subnet_id": "/s/epp-snet",
+		   "private_service_connection": [{"private_connection_resource_id": "/S/RG/SITES/PROC-FUNC", "subresource_names": ["sites"], "private_ip_address": "10.110.60.4"}],
+		   "private_dns_zone_group": [{"private_dns_zone_ids": ["/s/hub-rg/providers/Microsoft.Network/privateDnsZones/privatelink.azurewebsites.net"]}]}}]},
+		{"module": "module.epp.module.epp_eventhub_pep", "mode": "managed", "type": "azurerm_private_endpoint", "name": "this",
+		 "instances": [{"attributes": {"subnet_id": "/s/epp-snet",
+		   "private_service_connection": [{"private_connection_resource_id": "/s/rg/namespaces/epp-ehns", "subresource_names": ["sites"], "private_ip_address": "10.110.61.4"}],
+		   "private_dns_zone_group": [{"private_dns_zone_ids": ["/s/hub-rg/providers/Microsoft.Network/privateDnsZones/privatelink.azurewebsites.net"]}]}}]},
+		{"module": "module.src.module.pep", "mode": "managed", "type": "azurerm_private_endpoint", "name": "this",
+		 "instances": [{"attributes": {"subnet_id": "/s/other-snet",
+		   "private_service_connection": [{"private_connection_resource_id": "/s/rg/storageAccounts/gone", "subresource_names": ["blob"]}]}}]}
+	]
+}`
+
+func TestPrivateEndpointChain(t *testing.T) {
+	tfState, err := parseState([]byte(peChainState))
+	require.NoError(t, err)
+
+	const (
+		proc = "module.proc.module.pep.azurerm_private_endpoint.this"
+		epp  = "module.epp.module.epp_eventhub_pep.azurerm_private_endpoint.this"
+		src  = "module.src.module.pep.azurerm_private_endpoint.this"
+	)
+
+	targets := instanceOutcomes(checkPrivateEndpointTargets(tfState))
+	assert.Equal(t, "pass: targets module.proc.module.func.azurerm_windows_function_app.this", targets[proc])
+	assert.Equal(t, "fail: private_connection_resource_id /s/rg/storageAccounts/gone does not resolve to a resource in state", targets[src])
+
+	subresources := instanceOutcomes(checkPrivateEndpointSubresources(tfState))
+	assert.Equal(t, "pass: ", subresources[proc])
+	assert.Equal(t, `fail: subresource "sites" is not valid for azurerm_eventhub_namespace; want one of namespace`, subresources[epp])
+	assert.Equal(t, "n/a: target is not in state", subresources[src])
+
+	zones := instanceOutcomes(checkPrivateEndpointDNSZones(tfState))
+	assert.Equal(t, "pass: ", zones[proc])
+	assert.Equal(t, "fail: private_dns_zone_group is missing privatelink.servicebus.windows.net, has [privatelink.azurewebsites.net]", zones[epp],
+		"the zone follows the target's type, not the wrong subresource")
+	assert.Equal(t, "fail: no private_dns_zone_group; privatelink.blob.core.windows.net will not resolve to the endpoint", zones[src])
+
+	ips := instanceOutcomes(checkPrivateEndpointIPs(tfState))
+	assert.Equal(t, "pass: private IP in module.spoke.module.subnet_epp.azurerm_subnet.subnet", ips[proc])
+	assert.Equal(t, "fail: private IP 10.110.61.4 is outside module.spoke.module.subnet_epp.azurerm_subnet.subnet [10.110.60.0/24]", ips[epp])
+	assert.Equal(t, "n/a: subnet is not in this state", ips[src])
+}
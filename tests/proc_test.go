@@ -2,49 +2,41 @@ package test
 
 import "strings"
 
-func RunProcTests(tfState map[string]interface{}) []TestCase {
+func RunProcTests(tfState *State) []TestCase {
 	tests := []GenericTest{
-		{"1._Verify_Resource_Group_Existence_and_Properties", "ProcInfraTests", func() (bool, string) {
-			for _, rg := range findResourcesByType(tfState, "azurerm_resource_group") {
+		{"1._Verify_Resource_Group_Existence_and_Properties", "ProcInfraTests", func() CheckResult {
+			for _, rg := range findModuleResourcesByType(tfState, "module.proc", "azurerm_resource_group") {
 				if name, _ := rg["name"].(string); strings.Contains(name, "proc-rg") {
 					if location, _ := rg["location"].(string); location != "" {
-						return true, ""
+						return pass(1)
 					}
 				}
 			}
-			return false, "proc-rg not found or invalid"
+			return fail("proc-rg not found in module.proc or invalid")
 		}},
-		{"2._Verify_Function_App_Instance", "ProcInfraTests", func() (bool, string) {
-			for _, fn := range findResourcesByType(tfState, "azurerm_windows_function_app") {
+		{"2._Verify_Function_App_Instance", "ProcInfraTests", func() CheckResult {
+			for _, fn := range findModuleResourcesByType(tfState, "module.proc", "azurerm_windows_function_app") {
 				if name, _ := fn["name"].(string); strings.Contains(name, "procReady-fapp") {
-					return true, ""
+					return pass(1)
 				}
 			}
-			return false, "procReady-fapp not found"
+			return fail("procReady-fapp not found in module.proc")
 		}},
-		{"3._Verify_Storage_Account_Existence", "ProcInfraTests", func() (bool, string) {
-			for _, sa := range findResourcesByType(tfState, "azurerm_storage_account") {
-				instances, ok := sa["instances"].([]interface{})
-				if !ok {
-					continue
-				}
-				for _, inst := range instances {
-					attributes := inst.(map[string]interface{})["attributes"].(map[string]interface{})
-					name := attributes["name"].(string)
-					if strings.Contains(strings.ToLower(name), "prfpreadystg") {
-						return true, ""
-					}
+		{"3._Verify_Storage_Account_Existence", "ProcInfraTests", func() CheckResult {
+			for _, sa := range findModuleResourcesByType(tfState, "module.proc", "azurerm_storage_account") {
+				if name, _ := sa["name"].(string); strings.Contains(strings.ToLower(name), "prfpreadystg") {
+					return pass(1)
 				}
 			}
-			return false, "prfpreadystg not found"
+			return fail("prfpreadystg not found in module.proc")
 		}},
-		{"4._Verify_Private_Endpoint", "ProcInfraTests", func() (bool, string) {
-			for _, ep := range findResourcesByType(tfState, "azurerm_private_endpoint") {
+		{"4._Verify_Private_Endpoint", "ProcInfraTests", func() CheckResult {
+			for _, ep := range findModuleResourcesByType(tfState, "module.proc", "azurerm_private_endpoint") {
 				if name, _ := ep["name"].(string); strings.Contains(name, "prfpReady-pep") {
-					return true, ""
+					return pass(1)
 				}
 			}
-			return false, "prfpReady-pep not found"
+			return fail("prfpReady-pep not found in module.proc")
 		}},
 	}
 
@@ -0,0 +1,495 @@
+package test
+
+import (
+	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
+)
+
+// Addresses standing in for traffic from outside the VNets: documentation
+// addresses for the Internet and the APIM control plane, and the Azure
+// platform address health probes use. The control plane's addresses are
+// public, so the Internet tag matches them as well.
+var (
+	internetAddr          = netip.MustParseAddr("203.0.113.10")
+	apiManagementAddr     = netip.MustParseAddr("198.51.100.20")
+	azureLoadBalancerAddr = netip.MustParseAddr("168.63.129.16")
+)
+
+// Azure's default security rules, evaluated after every custom rule
+var defaultNSGRules = []NSGRule{
+	defaultRule("AllowVnetInBound", 65000, "Inbound", "Allow", "VirtualNetwork", "VirtualNetwork"),
+	defaultRule("AllowAzureLoadBalancerInBound", 65001, "Inbound", "Allow", "AzureLoadBalancer", "*"),
+	defaultRule("DenyAllInBound", 65500, "Inbound", "Deny", "*", "*"),
+	defaultRule("AllowVnetOutBound", 65000, "Outbound", "Allow", "VirtualNetwork", "VirtualNetwork"),
+	defaultRule("AllowInternetOutBound", 65001, "Outbound", "Allow", "*", "Internet"),
+	defaultRule("DenyAllOutBound", 65500, "Outbound", "Deny", "*", "*"),
+}
+
+func defaultRule(name string, priority int, direction, access, source, destination string) NSGRule {
+	return NSGRule{
+		Name: name, Priority: priority, Direction: direction, Access: access, Protocol: "*",
+		SourcePorts: parsePortRanges([]string{"*"}), DestinationPorts: parsePortRanges([]string{"*"}),
+		SourcePrefixes: []string{source}, DestinationPrefixes: []string{destination},
+	}
+}
+
+// ExpectedFlow is a declared connection and whether NSGs and routing should let it through.
+// Endpoints are "Internet", "AzureLoadBalancer", "subnet:<spoke subnet key>",
+// "vm:<module call>" (the NICs of the VMs under module.<name>) or "ip:<address>".
+type ExpectedFlow struct {
+	Name     string `json:"name"`
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Protocol string `json:"protocol"` // Tcp, Udp, Icmp or *
+	Port     int    `json:"port"`
+	Expect   string `json:"expect"` // "allow" or "deny"
+	Reason   string `json:"reason,omitempty"`
+}
+
+// Network is the part of the state that decides reachability: VNets, subnets,
+// NICs and the NSGs associated with the latter two
+type Network struct {
+	vnets   []*netVNet
+	subnets []*netSubnet
+	nics    []*netNIC
+	rules   map[string][]NSGRule // custom rules by NSG key, sorted by priority
+}
+
+type netVNet struct {
+	id, key      string // key is <resource group>/<name>
+	addressSpace []netip.Prefix
+	peers        []string // lowercased ids of remote VNets
+}
+
+type netSubnet struct {
+	address, id, name, key string // key is the spoke subnet key, e.g. "bastion"
+	vnet                   *netVNet
+	prefixes               []netip.Prefix
+	nsg                    string
+	exposedBy              string // a public endpoint injected into the subnet, e.g. an External APIM's VIP
+}
+
+type netNIC struct {
+	address, id, name string
+	module            string // module call owning the NIC's VM, e.g. "bastion"
+	ip                netip.Addr
+	subnet            *netSubnet
+	nsg               string
+	public            bool // has a public IP attached
+}
+
+// endpoint is one side of a flow: an address and, inside Azure, the NIC or subnet it sits in
+type endpoint struct {
+	name   string
+	addr   netip.Addr
+	subnet *netSubnet
+	nic    *netNIC
+}
+
+// Verdict is the outcome of a simulated flow, with the rule decisions that produced it
+type Verdict struct {
+	Allowed bool
+	Trace   []string
+}
+
+// Build the network model from VNets, subnets, NICs, NSG associations and rules in state
+func buildNetwork(tfState *State) *Network {
+	n := &Network{rules: map[string][]NSGRule{}}
+	for _, r := range collectNSGRules(tfState) {
+		n.rules[r.NSG] = append(n.rules[r.NSG], r)
+	}
+
+	vnetsByKey := map[string]*netVNet{}
+	for _, ri := range tfState.InstancesByType("azurerm_virtual_network") {
+		a := ri.Attr()
+		id, _ := a.String("id")
+		space, _ := a.Strings("address_space")
+		v := &netVNet{id: strings.ToLower(id), key: nsgKey(ri.Attributes["resource_group_name"], ri.Attributes["name"]), addressSpace: parsePrefixes(space)}
+		vnetsByKey[v.key] = v
+		n.vnets = append(n.vnets, v)
+	}
+	for _, ri := range tfState.InstancesByType("azurerm_virtual_network_peering") {
+		v := vnetsByKey[nsgKey(ri.Attributes["resource_group_name"], ri.Attributes["virtual_network_name"])]
+		remote, _ := ri.Attributes["remote_virtual_network_id"].(string)
+		if v != nil && remote != "" {
+			v.peers = append(v.peers, strings.ToLower(remote))
+		}
+	}
+
+	subnetNSG := associations(tfState, "azurerm_subnet_network_security_group_association", "subnet_id")
+	subnetsByID := map[string]*netSubnet{}
+	for _, ri := range tfState.InstancesByType("azurerm_subnet") {
+		a := ri.Attr()
+		id, _ := a.String("id")
+		name, _ := a.String("name")
+		prefixes, _ := a.Strings("address_prefixes")
+		s := &netSubnet{
+			address:  ri.Address(),
+			id:       strings.ToLower(id),
+			name:     name,
+			key:      spokeSubnetKey(ri),
+			vnet:     vnetsByKey[nsgKey(ri.Attributes["resource_group_name"], ri.Attributes["virtual_network_name"])],
+			prefixes: parsePrefixes(prefixes),
+			nsg:      subnetNSG[strings.ToLower(id)],
+		}
+		if nsgID, _ := a.String("network_security_group_id"); s.nsg == "" && nsgID != "" {
+			s.nsg = nsgKeyFromID(nsgID)
+		}
+		subnetsByID[s.id] = s
+		n.subnets = append(n.subnets, s)
+	}
+
+	// APIM injected in External mode, or given a public IP, answers from the Internet
+	// on a VIP in its subnet that no NIC represents
+	for _, ri := range tfState.InstancesByType("azurerm_api_management") {
+		a := ri.Attr()
+		subnetID, _ := a.String("virtual_network_configuration.0.subnet_id")
+		vnetType, _ := a.String("virtual_network_type")
+		publicIP, _ := a.String("public_ip_address_id")
+		if s := subnetsByID[strings.ToLower(subnetID)]; s != nil && (vnetType == "External" || publicIP != "") {
+			s.exposedBy = ri.Address()
+		}
+	}
+
+	vmModules := map[string]string{}
+	for _, vmType := range []string{"azurerm_windows_virtual_machine", "azurerm_linux_virtual_machine", "azurerm_virtual_machine"} {
+		for _, ri := range tfState.InstancesByType(vmType) {
+			nicIDs, _ := ri.Attr().Strings("network_interface_ids")
+			for _, id := range nicIDs {
+				if segments := moduleSegments(ri.Module); len(segments) > 0 {
+					vmModules[strings.ToLower(id)] = segments[0]
+				}
+			}
+		}
+	}
+
+	nicNSG := associations(tfState, "azurerm_network_interface_security_group_association", "network_interface_id")
+	for _, ri := range tfState.InstancesByType("azurerm_network_interface") {
+		a := ri.Attr()
+		id, _ := a.String("id")
+		name, _ := a.String("name")
+		subnetID, _ := a.String("ip_configuration.0.subnet_id")
+		ip, _ := a.String("ip_configuration.0.private_ip_address")
+		publicIP, _ := a.String("ip_configuration.0.public_ip_address_id")
+		nic := &netNIC{
+			address: ri.Address(),
+			id:      strings.ToLower(id),
+			name:    name,
+			module:  vmModules[strings.ToLower(id)],
+			subnet:  subnetsByID[strings.ToLower(subnetID)],
+			nsg:     nicNSG[strings.ToLower(id)],
+			public:  publicIP != "",
+		}
+		nic.ip, _ = netip.ParseAddr(ip)
+		if !nic.ip.IsValid() && nic.subnet != nil && len(nic.subnet.prefixes) > 0 {
+			nic.ip = firstHost(nic.subnet.prefixes[0]) // dynamic address not yet assigned, e.g. in a plan
+		}
+		n.nics = append(n.nics, nic)
+	}
+	return n
+}
+
+// NSG key by the id of the resource it is associated with
+func associations(tfState *State, resourceType, idAttr string) map[string]string {
+	assoc := map[string]string{}
+	for _, ri := range tfState.InstancesByType(resourceType) {
+		id, _ := ri.Attributes[idAttr].(string)
+		nsgID, _ := ri.Attributes["network_security_group_id"].(string)
+		if id != "" && nsgID != "" {
+			assoc[strings.ToLower(id)] = nsgKeyFromID(nsgID)
+		}
+	}
+	return assoc
+}
+
+// .../resourceGroups/<rg>/providers/Microsoft.Network/networkSecurityGroups/<name> -> <rg>/<name>
+func nsgKeyFromID(id string) string {
+	parts := strings.Split(id, "/")
+	var group, name string
+	for i := 0; i+1 < len(parts); i++ {
+		switch strings.ToLower(parts[i]) {
+		case "resourcegroups":
+			group = parts[i+1]
+		case "networksecuritygroups":
+			name = parts[i+1]
+		}
+	}
+	return nsgKey(group, name)
+}
+
+// Key of a spoke subnet from its module call, module.spoke.module.subnet_<key>
+func spokeSubnetKey(ri ResourceInstance) string {
+	segments := moduleSegments(ri.Module)
+	if len(segments) < 2 || !strings.HasPrefix(segments[1], "subnet_") {
+		return ""
+	}
+	return strings.TrimPrefix(segments[1], "subnet_")
+}
+
+func parsePrefixes(cidrs []string) []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, c := range cidrs {
+		if p, err := parsePrefix(c); err == nil {
+			prefixes = append(prefixes, p)
+		}
+	}
+	return prefixes
+}
+
+// Azure reserves the first four addresses of a subnet; .4 is the first a NIC gets
+func firstHost(p netip.Prefix) netip.Addr {
+	addr := p.Addr()
+	for i := 0; i < 4; i++ {
+		addr = addr.Next()
+	}
+	return addr
+}
+
+// Resolve an endpoint spec to the addresses it stands for
+func (n *Network) resolve(spec string) ([]endpoint, error) {
+	kind, value, _ := strings.Cut(spec, ":")
+	var found []endpoint
+	switch strings.ToLower(kind) {
+	case "internet":
+		return []endpoint{{name: "Internet", addr: internetAddr}}, nil
+	case "azureloadbalancer":
+		return []endpoint{{name: "AzureLoadBalancer", addr: azureLoadBalancerAddr}}, nil
+	case "ip":
+		addr, err := netip.ParseAddr(value)
+		if err != nil {
+			return nil, fmt.Errorf("endpoint %q: %w", spec, err)
+		}
+		return []endpoint{n.locate(spec, addr)}, nil
+	case "subnet":
+		for _, s := range n.subnets {
+			if (s.key == value || strings.EqualFold(s.name, value)) && len(s.prefixes) > 0 {
+				found = append(found, endpoint{name: s.address, addr: firstHost(s.prefixes[0]), subnet: s})
+			}
+		}
+	case "vm":
+		for _, nic := range n.nics {
+			if nic.module == value || strings.EqualFold(strings.TrimSuffix(nic.name, "-nic"), value) {
+				found = append(found, endpoint{name: nic.address, addr: nic.ip, subnet: nic.subnet, nic: nic})
+			}
+		}
+	default:
+		return nil, fmt.Errorf("endpoint %q: expected Internet, AzureLoadBalancer, subnet:<key>, vm:<module> or ip:<address>", spec)
+	}
+	return found, nil
+}
+
+// The subnet and NIC an address belongs to, if any
+func (n *Network) locate(name string, addr netip.Addr) endpoint {
+	ep := endpoint{name: name, addr: addr}
+	for _, nic := range n.nics {
+		if nic.ip == addr {
+			ep.nic, ep.subnet = nic, nic.subnet
+			return ep
+		}
+	}
+	for _, s := range n.subnets {
+		for _, p := range s.prefixes {
+			if p.Contains(addr) {
+				ep.subnet = s
+				return ep
+			}
+		}
+	}
+	return ep
+}
+
+// Simulate a flow: routing first, then the source's outbound NSGs (NIC, then
+// subnet) and the destination's inbound NSGs (subnet, then NIC)
+func (n *Network) Evaluate(from, to endpoint, protocol string, port int) Verdict {
+	v := Verdict{Allowed: true}
+	if reason, routed := n.routable(from, to); !routed {
+		return Verdict{Trace: []string{reason}}
+	}
+
+	type hop struct {
+		direction, nsg, where string
+		vnet                  *netVNet
+	}
+	var hops []hop
+	if from.nic != nil && from.nic.nsg != "" {
+		hops = append(hops, hop{"Outbound", from.nic.nsg, "NIC " + from.nic.name, vnetOf(from)})
+	}
+	if from.subnet != nil && from.subnet.nsg != "" {
+		hops = append(hops, hop{"Outbound", from.subnet.nsg, "subnet " + from.subnet.name, vnetOf(from)})
+	}
+	if to.subnet != nil && to.subnet.nsg != "" {
+		hops = append(hops, hop{"Inbound", to.subnet.nsg, "subnet " + to.subnet.name, vnetOf(to)})
+	}
+	if to.nic != nil && to.nic.nsg != "" {
+		hops = append(hops, hop{"Inbound", to.nic.nsg, "NIC " + to.nic.name, vnetOf(to)})
+	}
+
+	for _, h := range hops {
+		rule := n.firstMatch(h.nsg, h.direction, h.vnet, from.addr, to.addr, protocol, port)
+		v.Trace = append(v.Trace, fmt.Sprintf("%s at %s (NSG %s): %s rule %s (priority %d)",
+			strings.ToLower(h.direction), h.where, h.nsg, rule.Access, rule.Name, rule.Priority))
+		if !strings.EqualFold(rule.Access, "Allow") {
+			v.Allowed = false
+			return v
+		}
+	}
+	if len(hops) == 0 {
+		v.Trace = append(v.Trace, "no NSG on the path")
+	}
+	return v
+}
+
+// Whether packets from one endpoint can reach the other at all, before NSGs
+func (n *Network) routable(from, to endpoint) (string, bool) {
+	fromVNet, toVNet := vnetOf(from), vnetOf(to)
+	switch {
+	case fromVNet == nil && toVNet == nil:
+		return fmt.Sprintf("neither %s nor %s is in a VNet in state", from.name, to.name), false
+	case toVNet == nil:
+		return "", true // outbound to the Internet or an address outside the VNets
+	case fromVNet == nil:
+		if to.nic == nil && to.subnet.exposedBy != "" {
+			return "", true // the subnet's public endpoint, e.g. APIM's public VIP
+		}
+		if to.nic == nil || !to.nic.public {
+			return fmt.Sprintf("%s has no public IP; it is unreachable from outside the VNet", to.name), false
+		}
+		return "", true
+	case fromVNet == toVNet || n.peered(fromVNet, toVNet):
+		return "", true
+	}
+	return fmt.Sprintf("%s and %s are in VNets that are not peered", from.name, to.name), false
+}
+
+func (n *Network) peered(a, b *netVNet) bool {
+	has := func(v *netVNet, id string) bool {
+		for _, p := range v.peers {
+			if p == id {
+				return true
+			}
+		}
+		return false
+	}
+	return a.id != "" && b.id != "" && has(a, b.id) && has(b, a.id)
+}
+
+func vnetOf(ep endpoint) *netVNet {
+	if ep.subnet == nil {
+		return nil
+	}
+	return ep.subnet.vnet
+}
+
+// The first custom or default rule of an NSG that matches the packet
+func (n *Network) firstMatch(nsg, direction string, vnet *netVNet, src, dst netip.Addr, protocol string, port int) NSGRule {
+	rules := append(append([]NSGRule{}, n.rules[nsg]...), defaultNSGRules...)
+	sort.SliceStable(rules, func(i, j int) bool { return rules[i].Priority < rules[j].Priority })
+	for _, r := range rules {
+		if !strings.EqualFold(r.Direction, direction) || !protocolCovers(r.Protocol, protocol) {
+			continue
+		}
+		if !portsOverlap(r.SourcePorts, []portRange{{1024, 65535}}) || !portsCover(r.DestinationPorts, []portRange{{port, port}}) {
+			continue
+		}
+		if n.prefixesMatch(r.SourcePrefixes, src, vnet) && n.prefixesMatch(r.DestinationPrefixes, dst, vnet) {
+			return r
+		}
+	}
+	return defaultNSGRules[len(defaultNSGRules)-1] // unreachable: the DenyAll rules match everything
+}
+
+func (n *Network) prefixesMatch(prefixes []string, addr netip.Addr, vnet *netVNet) bool {
+	for _, p := range prefixes {
+		if n.prefixMatches(p, addr, vnet) {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether an address falls under a rule prefix or service tag, seen from an NSG in vnet.
+// Tags other than VirtualNetwork, Internet, AzureLoadBalancer and ApiManagement never match.
+func (n *Network) prefixMatches(prefix string, addr netip.Addr, vnet *netVNet) bool {
+	switch {
+	case isAnyPrefix(prefix):
+		return true
+	case strings.EqualFold(prefix, "VirtualNetwork"):
+		return n.inVirtualNetwork(addr, vnet)
+	case strings.EqualFold(prefix, "Internet"):
+		return !addr.IsPrivate() && addr != azureLoadBalancerAddr && !n.inAnyVNet(addr)
+	case strings.EqualFold(prefix, "AzureLoadBalancer"):
+		return addr == azureLoadBalancerAddr
+	case strings.EqualFold(prefix, "ApiManagement"):
+		return addr == apiManagementAddr
+	}
+	p, err := parsePrefix(prefix)
+	return err == nil && p.Contains(addr)
+}
+
+// The VirtualNetwork tag: the VNet's own address space and that of its peers
+func (n *Network) inVirtualNetwork(addr netip.Addr, vnet *netVNet) bool {
+	if vnet == nil {
+		return false
+	}
+	for _, v := range n.vnets {
+		if v != vnet && !n.peered(vnet, v) {
+			continue
+		}
+		for _, p := range v.addressSpace {
+			if p.Contains(addr) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (n *Network) inAnyVNet(addr netip.Addr) bool {
+	for _, v := range n.vnets {
+		for _, p := range v.addressSpace {
+			if p.Contains(addr) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Check a declared flow for every pair of endpoints it resolves to
+func (n *Network) checkFlow(f ExpectedFlow) CheckResult {
+	froms, err := n.resolve(f.From)
+	if err != nil {
+		return fail(err.Error())
+	}
+	tos, err := n.resolve(f.To)
+	if err != nil {
+		return fail(err.Error())
+	}
+	if len(froms) == 0 || len(tos) == 0 {
+		return notApplicable(fmt.Sprintf("%s or %s is not in state", f.From, f.To))
+	}
+
+	wantAllowed := strings.EqualFold(f.Expect, "allow")
+	protocol := f.Protocol
+	if protocol == "" {
+		protocol = "Tcp"
+	}
+	var evidence []string
+	for _, from := range froms {
+		for _, to := range tos {
+			v := n.Evaluate(from, to, protocol, f.Port)
+			verdict := "denied"
+			if v.Allowed {
+				verdict = "allowed"
+			}
+			line := fmt.Sprintf("%s -> %s %s/%d %s: %s", from.name, to.name, protocol, f.Port, verdict, strings.Join(v.Trace, "; "))
+			if v.Allowed != wantAllowed {
+				return fail(fmt.Sprintf("expected %s (%s), got %s", f.Expect, f.Reason, line))
+			}
+			evidence = append(evidence, line)
+		}
+	}
+	return passWith(len(froms)*len(tos), strings.Join(evidence, "\n"))
+}
@@ -0,0 +1,161 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Evaluates the environment's declared flows against NSGs, associations and routing in state
+func RunReachabilityTests(tfState *State, exp *Expectations) []TestCase {
+	network := buildNetwork(tfState)
+	tests := []GenericTest{
+		{"1._Verify_Declared_Network_Flows", "ReachabilityTests", func() CheckResult {
+			if len(exp.Flows) == 0 {
+				return notApplicable("no flows declared for " + exp.Environment)
+			}
+			// One result per flow, named after it
+			res := CheckResult{Pass: true}
+			for _, f := range exp.Flows {
+				r := runCheck(func() CheckResult { return network.checkFlow(f) })
+				res.Instances = append(res.Instances, InstanceResult{Address: f.Name, CheckResult: r})
+				res.Evaluated += r.Evaluated
+				res.Pass = res.Pass && r.Pass
+			}
+			return res
+		}},
+	}
+
+	return executeTestCases(tests)
+}
+
+// A spoke with a bastion subnet and NIC, an APIM subnet and an unpeered VNet
+const reachabilityState = `{
+	"version": 4,
+	"resources": [
+		{"module": "module.spoke.module.spoke_vnet", "mode": "managed", "type": "azurerm_virtual_network", "name": "vnet",
+		 "instances": [{"attributes": {"id": "/subscriptions/s/resourceGroups/spk-rg/providers/Microsoft.Network/virtualNetworks/spoke-vnet",
+		   "name": "spoke-vnet", "resource_group_name": "spk-rg", "address_space": ["10.110.0.0/16"]}}]},
+		{"mode": "managed", "type": "azurerm_virtual_network", "name": "other",
+		 "instances": [{"attributes": {"id": "/subscriptions/s/resourceGroups/oth-rg/providers/Microsoft.Network/virtualNetworks/other-vnet",
+		   "name": "other-vnet", "resource_group_name": "oth-rg", "address_space": ["10.200.0.0/16"]}}]},
+		{"mode": "managed", "type": "azurerm_subnet", "name": "other",
+		 "instances": [{"attributes": {"id": "/subscriptions/s/resourceGroups/oth-rg/providers/Microsoft.Network/virtualNetworks/other-vnet/subnets/other-snet",
+		   "name": "other-snet", "resource_group_name": "oth-rg", "virtual_network_name": "other-vnet", "address_prefixes": ["10.200.1.0/24"]}}]},
+		{"module": "module.spoke.module.subnet_bastion", "mode": "managed", "type": "azurerm_subnet", "name": "subnet",
+		 "instances": [{"attributes": {"id": "/subscriptions/s/resourceGroups/spk-rg/providers/Microsoft.Network/virtualNetworks/spoke-vnet/subnets/bst-snet",
+		   "name": "bst-snet", "resource_group_name": "spk-rg", "virtual_network_name": "spoke-vnet", "address_prefixes": ["10.110.10.0/24"]}}]},
+		{"module": "module.spoke.module.subnet_bastion", "mode": "managed", "type": "azurerm_subnet_network_security_group_association", "name": "subnet_nsg_assoc",
+		 "instances": [{"attributes": {"subnet_id": "/subscriptions/s/resourceGroups/spk-rg/providers/Microsoft.Network/virtualNetworks/spoke-vnet/subnets/bst-snet",
+		   "network_security_group_id": "/subscriptions/s/resourceGroups/spk-rg/providers/Microsoft.Network/networkSecurityGroups/bst-nsg"}}]},
+		{"module": "module.spoke.module.subnet_exp", "mode": "managed", "type": "azurerm_subnet", "name": "subnet",
+		 "instances": [{"attributes": {"id": "/subscriptions/s/resourceGroups/spk-rg/providers/Microsoft.Network/virtualNetworks/spoke-vnet/subnets/exp-snet",
+		   "name": "exp-snet", "resource_group_name": "spk-rg", "virtual_network_name": "spoke-vnet", "address_prefixes": ["10.110.20.0/24"]}}]},
+		{"module": "module.spoke.module.subnet_exp", "mode": "managed", "type": "azurerm_subnet_network_security_group_association", "name": "subnet_nsg_assoc",
+		 "instances": [{"attributes": {"subnet_id": "/subscriptions/s/resourceGroups/spk-rg/providers/Microsoft.Network/virtualNetworks/spoke-vnet/subnets/exp-snet",
+		   "network_security_group_id": "/subscriptions/s/resourceGroups/spk-rg/providers/Microsoft.Network/networkSecurityGroups/exp-nsg"}}]},
+		{"module": "module.spoke.module.nsg_bastion", "mode": "managed", "type": "azurerm_network_security_rule", "name": "rules",
+		 "instances": [
+			{"index_key": "allow_rdp_in", "attributes": {"name": "allow_rdp_in", "priority": 100, "direction": "Inbound", "access": "Allow", "protocol": "Tcp",
+			 "source_port_range": "*", "destination_port_range": "3389", "source_address_prefix": "Internet", "destination_address_prefix": "*",
+			 "resource_group_name": "spk-rg", "network_security_group_name": "bst-nsg"}}
+		 ]},
+		{"module": "module.spoke.module.nsg_exp", "mode": "managed", "type": "azurerm_network_security_rule", "name": "rules",
+		 "instances": [
+			{"index_key": "deny_bastion_ssh", "attributes": {"name": "deny_bastion_ssh", "priority": 100, "direction": "Inbound", "access": "Deny", "protocol": "Tcp",
+			 "source_port_range": "*", "destination_port_range": "22", "source_address_prefix": "10.110.10.0/24", "destination_address_prefix": "*",
+			 "resource_group_name": "spk-rg", "network_security_group_name": "exp-nsg"}}
+		 ]},
+		{"module": "module.bastion.module.bastion_vm", "mode": "managed", "type": "azurerm_network_interface", "name": "this",
+		 "instances": [{"attributes": {"id": "/subscriptions/s/resourceGroups/bst-rg/providers/Microsoft.Network/networkInterfaces/bst-nic", "name": "bst-nic",
+		   "ip_configuration": [{"subnet_id": "/subscriptions/s/resourceGroups/spk-rg/providers/Microsoft.Network/virtualNetworks/spoke-vnet/subnets/bst-snet",
+		     "private_ip_address": "10.110.10.4", "public_ip_address_id": "/subscriptions/s/resourceGroups/bst-rg/providers/Microsoft.Network/publicIPAddresses/bst-pip"}]}}]},
+		{"module": "module.bastion.module.bastion_vm", "mode": "managed", "type": "azurerm_windows_virtual_machine", "name": "this",
+		 "instances": [{"attributes": {"name": "bst", "network_interface_ids": ["/subscriptions/s/resourceGroups/bst-rg/providers/Microsoft.Network/networkInterfaces/bst-nic"]}}]}
+	]
+}`
+
+func TestReachabilitySimulator(t *testing.T) {
+	tfState, err := parseState([]byte(reachabilityState))
+	require.NoError(t, err)
+	network := buildNetwork(tfState)
+
+	check := func(from, to string, port int, expect string) CheckResult {
+		return network.checkFlow(ExpectedFlow{Name: "f", From: from, To: to, Protocol: "Tcp", Port: port, Expect: expect})
+	}
+
+	res := check("Internet", "vm:bastion", 3389, "deny")
+	assert.False(t, res.Pass, "public IP plus an Internet Allow on 3389")
+	assert.Contains(t, res.Message, "inbound at subnet bst-snet (NSG spk-rg/bst-nsg): Allow rule allow_rdp_in (priority 100)")
+
+	res = check("Internet", "vm:bastion", 22, "deny")
+	assert.True(t, res.Pass, res.Message)
+	assert.Contains(t, res.Message, "Deny rule DenyAllInBound (priority 65500)")
+
+	res = check("Internet", "subnet:exp", 443, "deny")
+	assert.True(t, res.Pass)
+	assert.Contains(t, res.Message, "has no public IP")
+
+	res = check("vm:bastion", "subnet:exp", 443, "allow")
+	assert.True(t, res.Pass, res.Message)
+	assert.Contains(t, res.Message, "AllowVnetOutBound")
+	assert.Contains(t, res.Message, "AllowVnetInBound")
+
+	res = check("vm:bastion", "subnet:exp", 22, "allow")
+	assert.False(t, res.Pass)
+	assert.Contains(t, res.Message, "Deny rule deny_bastion_ssh")
+
+	res = check("vm:bastion", "Internet", 443, "allow")
+	assert.True(t, res.Pass, "AllowInternetOutBound")
+
+	res = check("subnet:bastion", "ip:10.200.1.10", 443, "deny")
+	assert.True(t, res.Pass)
+	assert.Contains(t, res.Message, "not peered")
+
+	res = check("subnet:sys", "subnet:exp", 443, "allow")
+	assert.Equal(t, 0, res.Evaluated, "subnet:sys is not in state")
+
+	res = check("vnet:spoke", "subnet:exp", 443, "allow")
+	assert.False(t, res.Pass)
+	assert.Contains(t, res.Message, "expected Internet, AzureLoadBalancer")
+}
+
+func TestReachabilityFollowsPeering(t *testing.T) {
+	tfState, err := parseState([]byte(reachabilityState))
+	require.NoError(t, err)
+	tfState.Resources = append(tfState.Resources,
+		Resource{Mode: ModeManaged, Type: "azurerm_virtual_network_peering", Name: "to_other", Instances: []Instance{{Attributes: map[string]interface{}{
+			"resource_group_name": "spk-rg", "virtual_network_name": "spoke-vnet",
+			"remote_virtual_network_id": "/subscriptions/s/resourceGroups/oth-rg/providers/Microsoft.Network/virtualNetworks/other-vnet"}}}},
+		Resource{Mode: ModeManaged, Type: "azurerm_virtual_network_peering", Name: "from_other", Instances: []Instance{{Attributes: map[string]interface{}{
+			"resource_group_name": "oth-rg", "virtual_network_name": "other-vnet",
+			"remote_virtual_network_id": "/subscriptions/s/resourceGroups/spk-rg/providers/Microsoft.Network/virtualNetworks/spoke-vnet"}}}},
+	)
+	network := buildNetwork(tfState)
+
+	res := network.checkFlow(ExpectedFlow{Name: "f", From: "ip:10.200.1.10", To: "subnet:exp", Port: 443, Expect: "allow"})
+	assert.True(t, res.Pass, res.Message)
+	assert.Contains(t, res.Message, "AllowVnetInBound", "peered address space counts as VirtualNetwork")
+}
+
+func TestReachabilityExternalAPIM(t *testing.T) {
+	tfState, err := parseState([]byte(reachabilityState))
+	require.NoError(t, err)
+	tfState.Resources = append(tfState.Resources,
+		Resource{Module: "module.exp.module.apim", Mode: ModeManaged, Type: "azurerm_api_management", Name: "this", Instances: []Instance{{Attributes: map[string]interface{}{
+			"name": "dev-exp-apim", "virtual_network_type": "External",
+			"virtual_network_configuration": []interface{}{map[string]interface{}{
+				"subnet_id": "/subscriptions/s/resourceGroups/spk-rg/providers/Microsoft.Network/virtualNetworks/spoke-vnet/subnets/exp-snet"}}}}}},
+		Resource{Module: "module.spoke.module.nsg_exp", Mode: ModeManaged, Type: "azurerm_network_security_rule", Name: "https", Instances: []Instance{{Attributes: map[string]interface{}{
+			"name": "allow_https_in", "priority": float64(110), "direction": "Inbound", "access": "Allow", "protocol": "Tcp",
+			"source_port_range": "*", "destination_port_range": "443", "source_address_prefix": "Internet", "destination_address_prefix": "*",
+			"resource_group_name": "spk-rg", "network_security_group_name": "exp-nsg"}}}},
+	)
+	network := buildNetwork(tfState)
+
+	res := network.checkFlow(ExpectedFlow{Name: "f", From: "Internet", To: "subnet:exp", Protocol: "Tcp", Port: 443, Expect: "deny"})
+	assert.False(t, res.Pass, "an External APIM answers from the Internet on its public VIP")
+	assert.NotContains(t, res.Message, "has no public IP")
+	assert.Contains(t, res.Message, "Allow rule allow_https_in (priority 110)")
+}
@@ -0,0 +1,174 @@
+package test
+
+import (
+	"context"
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"tests/config"
+)
+
+func TestJUnitReportShape(t *testing.T) {
+	cases := []TestCase{
+		{Classname: "C", Name: "pass", Status: StatusPass},
+		{Classname: "C", Name: "fail", Status: StatusFail, Failure: &Failure{Message: "boom", Type: "failure"}},
+		{Classname: "C", Name: "skip", Status: StatusSkipped, Skipped: &Skipped{Message: "nothing to check"}},
+		{Classname: "C", Name: "error", Status: StatusError, Error: &Failure{Message: "panic", Type: "panic", Text: "stack"}},
+	}
+	var report TestSuites
+	report.add(newTestSuite("Mod", time.Now(), []Property{{Name: "environment", Value: "dev"}}, cases))
+	report.add(newTestSuite("Other", time.Now(), nil, cases[:1]))
+
+	assert.Equal(t, 5, report.Tests)
+	assert.Equal(t, 1, report.Failures)
+	assert.Equal(t, 1, report.Errors)
+	assert.Equal(t, 1, report.Skipped)
+
+	out, err := xml.Marshal(report)
+	require.NoError(t, err)
+	xmlText := string(out)
+	assert.Contains(t, xmlText, `<testsuites tests="5" failures="1" errors="1" skipped="1"`)
+	assert.Contains(t, xmlText, `<testsuite name="Mod" tests="4" failures="1" errors="1" skipped="1"`)
+	assert.Contains(t, xmlText, `<property name="environment" value="dev"></property>`)
+	assert.Contains(t, xmlText, `<skipped message="nothing to check"></skipped>`)
+	assert.Contains(t, xmlText, `<error message="panic" type="panic">stack</error>`)
+	assert.NotContains(t, xmlText, "<status>")
+}
+
+func TestWriteReportRedactsCredentials(t *testing.T) {
+	config.RegisterSecrets("s3cr3t-client-value")
+
+	cases := executeTestCases([]GenericTest{
+		{"Leaky_Failure", "C", func() CheckResult {
+			return fail("fetching https://acct.blob.core.windows.net/tfstate/x?sp=r&sig=moNU7E%3D failed")
+		}},
+		{"Leaky_Panic", "C", func() CheckResult { panic("token rejected: s3cr3t-client-value") }},
+	})
+	assert.NotContains(t, cases[0].Failure.Message, "moNU7E", "log lines built from results are already clean")
+
+	var report TestSuites
+	report.add(newTestSuite("C", time.Now(), []Property{
+		{Name: "state_url", Value: "https://user:pw@state.example.com/dev"},
+		{Name: "connection", Value: "Endpoint=sb://ns.servicebus.windows.net/;SharedAccessKey=abc123=="},
+	}, cases))
+	path := filepath.Join(t.TempDir(), "redacted.xml")
+	writeReport(t, report, path)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	for _, secret := range []string{"moNU7E", "s3cr3t-client-value", ":pw@", "abc123"} {
+		assert.NotContains(t, string(data), secret)
+	}
+	assert.Contains(t, string(data), "sig=REDACTED")
+}
+
+func TestExecuteTestCasesSkipsVacuousChecks(t *testing.T) {
+	cases := executeTestCases([]GenericTest{
+		{"Nothing_Matched", "C", func() CheckResult { return pass(0) }},
+		{"Not_Applicable", "C", func() CheckResult { return notApplicable("no Windows VMs in this environment") }},
+		{"Checked_Two", "C", func() CheckResult { return pass(2) }},
+		{"Broken", "C", func() CheckResult { return fail("VNet name is empty") }},
+	})
+	require.Len(t, cases, 4)
+
+	assert.Equal(t, StatusSkipped, cases[0].Status)
+	assert.Equal(t, "no resources evaluated", cases[0].Skipped.Message)
+	assert.Equal(t, StatusSkipped, cases[1].Status)
+	assert.Equal(t, "no Windows VMs in this environment", cases[1].Skipped.Message)
+
+	assert.Equal(t, StatusPass, cases[2].Status)
+	assert.Equal(t, "evaluated 2 resource(s)", cases[2].SystemOut)
+	assert.Nil(t, cases[2].Skipped)
+
+	assert.Equal(t, StatusFail, cases[3].Status)
+	assert.Equal(t, "VNet name is empty", cases[3].Failure.Message)
+}
+
+func TestMainInfraSkipsTypesMissingFromState(t *testing.T) {
+	tfState, err := (&FileStateSource{Path: "testdata/minimal.tfstate"}).Load(context.Background())
+	require.NoError(t, err)
+
+	status := map[string]string{}
+	for _, tc := range RunMainInfraTests(tfState, nil) {
+		status[tc.Name] = tc.Status
+	}
+	assert.Equal(t, StatusSkipped, status["6._Validate_VM"], "no azurerm_virtual_machine in state")
+	assert.Equal(t, StatusSkipped, status["5._Validate_NSG_Subnet_Association"])
+}
+
+func TestEachInstanceReportsEveryViolation(t *testing.T) {
+	tfState, err := (&FileStateSource{Path: "testdata/minimal.tfstate"}).Load(context.Background())
+	require.NoError(t, err)
+
+	records := tfState.InstancesByType("azurerm_private_dns_a_record")
+	require.NotEmpty(t, records)
+	cases := executeTestCases([]GenericTest{
+		{"Record_Has_No_IPs", "C", func() CheckResult {
+			return eachInstance(records, func(rec ResourceInstance) CheckResult {
+				return fail("no private IPs")
+			})
+		}},
+	})
+
+	require.Len(t, cases, len(records), "one test case per instance, not one per check")
+	for i, tc := range cases {
+		assert.Equal(t, "Record_Has_No_IPs/"+records[i].Address(), tc.Name)
+		assert.Equal(t, StatusFail, tc.Status)
+	}
+}
+
+// Outcome of each instance of a per-instance check, by address: "pass: ", "fail: "
+// or "n/a: " (nothing evaluated) followed by the instance's message
+func instanceOutcomes(res CheckResult) map[string]string {
+	m := map[string]string{}
+	for _, inst := range res.Instances {
+		status := "pass"
+		if !inst.Pass {
+			status = "fail"
+		} else if inst.Evaluated == 0 {
+			status = "n/a"
+		}
+		m[inst.Address] = status + ": " + inst.Message
+	}
+	return m
+}
+
+func TestExecuteTestCasesRecoversPanics(t *testing.T) {
+	tfState, err := (&FileStateSource{Path: "testdata/minimal.tfstate"}).Load(context.Background())
+	require.NoError(t, err)
+	records := tfState.InstancesByType("azurerm_private_dns_a_record")
+
+	cases := executeTestCases([]GenericTest{
+		{"Bad_Assertion", "C", func() CheckResult {
+			var rg map[string]interface{}
+			_ = rg["name"].(string)
+			return pass(1)
+		}},
+		{"Per_Instance", "C", func() CheckResult {
+			return eachInstance(records, func(rec ResourceInstance) CheckResult {
+				panic("boom")
+			})
+		}},
+		{"Still_Runs", "C", func() CheckResult { return pass(1) }},
+	})
+	require.Len(t, cases, 2+len(records))
+
+	assert.Equal(t, StatusError, cases[0].Status)
+	assert.Nil(t, cases[0].Failure)
+	assert.Contains(t, cases[0].Error.Message, "interface conversion")
+	assert.Contains(t, cases[0].SystemErr, "runtime/debug.Stack")
+	for _, tc := range cases[1 : 1+len(records)] {
+		assert.Equal(t, StatusError, tc.Status, tc.Name)
+	}
+	assert.Equal(t, StatusPass, cases[len(cases)-1].Status)
+
+	suite := newTestSuite("C", time.Now(), nil, cases)
+	assert.Equal(t, 1+len(records), suite.Errors)
+	assert.Zero(t, suite.Failures, "errors are not counted as failures")
+}
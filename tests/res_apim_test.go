@@ -5,36 +5,43 @@ import (
 	"strings"
 )
 
-func RunAPIMTests(tfState map[string]interface{}) []TestCase {
+func RunAPIMTests(tfState *State, exp *Expectations) []TestCase {
 	tests := []GenericTest{
 		{
-			"1._Verify_APIM_Resource_Deployment_and_Configuration", "APIMInfraTests", func() (bool, string) {
+			"1._Verify_APIM_Resource_Deployment_and_Configuration", "APIMInfraTests", func() CheckResult {
 				apims := findResourcesByType(tfState, "azurerm_api_management")
 				for _, apim := range apims {
 					if name, _ := apim["name"].(string); name != "" {
-						return true, ""
+						if sku, _ := apim["sku_name"].(string); sku != exp.APIMSku {
+							return fail(fmt.Sprintf("APIM %s has sku_name %s, expected %s", name, sku, exp.APIMSku))
+						}
+						return pass(1)
 					}
 				}
-				return false, "APIM resource not found or incorrectly configured"
+				return fail("APIM resource not found or incorrectly configured")
 			},
 		},
 		{
-			"2._Verify_App_Insights_and_Log_Analytics_Integration", "APIMInfraTests", func() (bool, string) {
+			"2._Verify_App_Insights_and_Log_Analytics_Integration", "APIMInfraTests", func() CheckResult {
 				appInsights := findResourcesByType(tfState, "azurerm_application_insights")
 				logAnalytics := findResourcesByType(tfState, "azurerm_log_analytics_workspace")
 				if len(appInsights) > 0 && len(logAnalytics) > 0 {
-					return true, ""
+					return pass(len(appInsights) + len(logAnalytics))
 				}
-				return false, "Application Insights or Log Analytics not found"
+				return fail("Application Insights or Log Analytics not found")
 			},
 		},
 		{
-			"3._Verify_Private_DNS_A_Records_for_APIM_and_Prefixes", "APIMInfraTests", func() (bool, string) {
-				dnsRecords := findResourcesByType(tfState, "azurerm_private_dns_a_record")
+			"3._Verify_Private_DNS_A_Records_for_APIM_and_Prefixes", "APIMInfraTests", func() CheckResult {
+				dnsRecords := tfState.InstancesByType("azurerm_private_dns_a_record")
 				expectedPrefixes := []string{"management", "developer", "portal"}
 				found := map[string]bool{}
 				for _, rec := range dnsRecords {
-					name := strings.ToLower(rec["name"].(string))
+					name, err := rec.Attr().String("name")
+					if err != nil {
+						return fail(err.Error())
+					}
+					name = strings.ToLower(name)
 					for _, prefix := range expectedPrefixes {
 						if strings.Contains(name, prefix) {
 							found[prefix] = true
@@ -43,55 +50,55 @@ func RunAPIMTests(tfState map[string]interface{}) []TestCase {
 				}
 				for _, prefix := range expectedPrefixes {
 					if !found[prefix] {
-						return false, fmt.Sprintf("Missing DNS A record containing prefix: %s", prefix)
+						return fail(fmt.Sprintf("Missing DNS A record containing prefix: %s", prefix))
 					}
 				}
-				return true, ""
+				return pass(len(dnsRecords))
 			},
 		},
 		{
-			"4._Verify_AppInsights_Logger_and_Log_Retention", "APIMInfraTests", func() (bool, string) {
-				loggers := findResourcesByType(tfState, "azurerm_api_management_logger")
+			"4._Verify_AppInsights_Logger_and_Log_Retention", "APIMInfraTests", func() CheckResult {
+				loggers := tfState.InstancesByType("azurerm_api_management_logger")
 				for _, logger := range loggers {
-					appInsights, ok := logger["application_insights"].([]interface{})
-					if ok && len(appInsights) > 0 {
-						ai, ok := appInsights[0].(map[string]interface{})
-						if ok {
-							key, keyOk := ai["instrumentation_key"].(string)
-							if keyOk && key != "" {
-								return true, ""
-							}
-						}
+					// The key is sensitive: only its presence is checked, and the evidence masks it
+					if key, err := logger.Attr().String("application_insights.0.instrumentation_key"); err == nil && key != "" {
+						return passWith(1, logger.Attr().Evidence("name", "application_insights.0.instrumentation_key"))
 					}
 				}
-				return false, "Logger not linked with Application Insights (missing instrumentation_key)"
+				return fail("Logger not linked with Application Insights (missing instrumentation_key)")
 			},
 		},
 		{
-			"5._Verify_Terraform_Outputs_for_APIM_ID_Name_PrivateIP_FQDN", "APIMInfraTests", func() (bool, string) {
-				outputsRaw, ok := tfState["outputs"].(map[string]interface{})
-				if !ok {
-					return false, "Outputs missing in state"
+			"5._Verify_Terraform_Outputs_for_APIM_ID_Name_PrivateIP_FQDN", "APIMInfraTests", func() CheckResult {
+				if len(tfState.Outputs) == 0 {
+					return fail("Outputs missing in state")
 				}
 				requiredKeys := []string{"apim_id", "apim_name", "apim_private_ip", "apim_fqdn"}
 
 				for _, key := range requiredKeys {
-					out, exists := outputsRaw[key]
+					val, exists := tfState.OutputValue(key)
 					if !exists {
-						return false, fmt.Sprintf("Missing output key: %s", key)
-					}
-					m, ok := out.(map[string]interface{})
-					if !ok {
-						return false, fmt.Sprintf("Invalid output structure for: %s", key)
+						return fail(fmt.Sprintf("Missing output key: %s", key))
 					}
-					val := m["value"]
 					if val == nil || (fmt.Sprintf("%v", val) == "") {
-						return false, fmt.Sprintf("Invalid or empty output value for: %s", key)
+						return fail(fmt.Sprintf("Invalid or empty output value for: %s", key))
 					}
 				}
-				return true, ""
+				return pass(len(requiredKeys))
 			},
 		},
+		{"6._Verify_APIM_Virtual_Network_Type", "APIMInfraTests", func() CheckResult {
+			return checkAPIMNetworkType(expAPIMs(tfState), exp)
+		}},
+		{"7._Verify_APIM_Injected_Into_Exp_Subnet", "APIMInfraTests", func() CheckResult {
+			return checkAPIMSubnet(tfState)
+		}},
+		{"8._Verify_APIM_Subnet_NSG_Allows_Management_And_Probes", "APIMInfraTests", func() CheckResult {
+			return checkAPIMSubnetNSG(tfState)
+		}},
+		{"9._Verify_APIM_Hostname_A_Records_Point_At_Private_IP", "APIMInfraTests", func() CheckResult {
+			return checkAPIMDNSRecords(tfState)
+		}},
 	}
 
 	return executeTestCases(tests)
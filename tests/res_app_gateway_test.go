@@ -4,65 +4,63 @@ import (
 	"fmt"
 )
 
-func RunAppGatewayTests(tfState map[string]interface{}) []TestCase {
+func RunAppGatewayTests(tfState *State) []TestCase {
 	tests := []GenericTest{
-		{"1._Check_Application_Gateway_Exists", "AppGatewayTests", func() (bool, string) {
+		{"1._Check_Application_Gateway_Exists", "AppGatewayTests", func() CheckResult {
 			gws := findResourcesByType(tfState, "azurerm_application_gateway")
 			if len(gws) == 0 {
-				return false, "Expected at least one Application Gateway"
+				return fail("Expected at least one Application Gateway")
 			}
-			return true, ""
+			return pass(len(gws))
 		}},
-		{"2._Check_AppGW_Has_HTTP2_Enabled", "AppGatewayTests", func() (bool, string) {
+		{"2._Check_AppGW_Has_HTTP2_Enabled", "AppGatewayTests", func() CheckResult {
 			gws := findResourcesByType(tfState, "azurerm_application_gateway")
 			if len(gws) == 0 {
-				return false, "No Application Gateway to check HTTP2"
+				return fail("No Application Gateway to check HTTP2")
 			}
 			if http2, ok := gws[0]["enable_http2"].(bool); !ok || !http2 {
-				return false, "Expected HTTP2 to be enabled"
+				return fail("Expected HTTP2 to be enabled")
 			}
-			return true, ""
+			return pass(1)
 		}},
-		{"3._Check_SKU_Name_And_Tier", "AppGatewayTests", func() (bool, string) {
-			gws := findResourcesByType(tfState, "azurerm_application_gateway")
+		{"3._Check_SKU_Name_And_Tier", "AppGatewayTests", func() CheckResult {
+			gws := tfState.InstancesByType("azurerm_application_gateway")
 			if len(gws) == 0 {
-				return false, "No Application Gateway to check SKU"
+				return fail("No Application Gateway to check SKU")
+			}
+			name, err := gws[0].Attr().String("sku.0.name")
+			if err != nil {
+				return fail(err.Error())
 			}
-			sku, ok := gws[0]["sku"].([]interface{})
-			if !ok || len(sku) == 0 {
-				return false, "SKU block is missing or empty"
+			tier, err := gws[0].Attr().String("sku.0.tier")
+			if err != nil {
+				return fail(err.Error())
 			}
-			skuMap := sku[0].(map[string]interface{})
-			if skuMap["name"] != "Standard_v2" || skuMap["tier"] != "Standard_v2" {
-				return false, fmt.Sprintf("Expected SKU name/tier to be Standard_v2, got: %v / %v", skuMap["name"], skuMap["tier"])
+			if name != "Standard_v2" || tier != "Standard_v2" {
+				return fail(fmt.Sprintf("Expected SKU name/tier to be Standard_v2, got: %v / %v", name, tier))
 			}
-			return true, ""
+			return pass(1)
 		}},
-		{"4._Check_AppGW_IP_Config_Subnet", "AppGatewayTests", func() (bool, string) {
-			gws := findResourcesByType(tfState, "azurerm_application_gateway")
+		{"4._Check_AppGW_IP_Config_Subnet", "AppGatewayTests", func() CheckResult {
+			gws := tfState.InstancesByType("azurerm_application_gateway")
 			if len(gws) == 0 {
-				return false, "No Application Gateway to check IP configuration"
-			}
-			ipConfigs, ok := gws[0]["gateway_ip_configuration"].([]interface{})
-			if !ok || len(ipConfigs) == 0 {
-				return false, "No IP configuration found"
+				return fail("No Application Gateway to check IP configuration")
 			}
-			ipConfig := ipConfigs[0].(map[string]interface{})
-			if ipConfig["subnet_id"] == nil {
-				return false, "Expected subnet_id in IP configuration"
+			if _, err := gws[0].Attr().String("gateway_ip_configuration.0.subnet_id"); err != nil {
+				return fail(err.Error())
 			}
-			return true, ""
+			return pass(1)
 		}},
-		{"5._Check_Tags_Are_Set", "AppGatewayTests", func() (bool, string) {
+		{"5._Check_Tags_Are_Set", "AppGatewayTests", func() CheckResult {
 			gws := findResourcesByType(tfState, "azurerm_application_gateway")
 			if len(gws) == 0 {
-				return false, "No Application Gateway found"
+				return fail("No Application Gateway found")
 			}
 			tags, ok := gws[0]["tags"].(map[string]interface{})
 			if !ok || len(tags) == 0 {
-				return false, "Tags block is missing or empty"
+				return fail("Tags block is missing or empty")
 			}
-			return true, ""
+			return pass(1)
 		}},
 	}
 
@@ -4,56 +4,55 @@ import (
 	"fmt"
 )
 
-func RunLogAnalyticsTests(tfState map[string]interface{}) []TestCase {
+func RunLogAnalyticsTests(tfState *State, exp *Expectations) []TestCase {
 	tests := []GenericTest{
 		{
 			"1._Verify_Log_Analytics_Workspace_Exists_with_Correct_Properties",
 			"LogAnalyticsTests",
-			func() (bool, string) {
+			func() CheckResult {
 				ws := findResourcesByType(tfState, "azurerm_log_analytics_workspace")
 				if len(ws) == 0 {
-					return false, "Expected a Log Analytics Workspace"
+					return fail("Expected a Log Analytics Workspace")
 				}
-				if ws[0]["sku"] != "PerGB2018" || ws[0]["retention_in_days"] != float64(30) {
-					return false, fmt.Sprintf(
-						"Expected sku=PerGB2018 & retention=30, got sku=%v, retention=%v",
-						ws[0]["sku"], ws[0]["retention_in_days"])
+				if ws[0]["sku"] != exp.LogAnalytics.SKU || ws[0]["retention_in_days"] != float64(exp.LogAnalytics.RetentionInDays) {
+					return fail(fmt.Sprintf(
+						"Expected sku=%s & retention=%d, got sku=%v, retention=%v",
+						exp.LogAnalytics.SKU, exp.LogAnalytics.RetentionInDays, ws[0]["sku"], ws[0]["retention_in_days"]))
 				}
-				return true, ""
+				return pass(1)
 			},
 		},
 		{
 			"2._Verify_Tags_on_Log_Analytics_Workspace",
 			"LogAnalyticsTests",
-			func() (bool, string) {
+			func() CheckResult {
 				ws := findResourcesByType(tfState, "azurerm_log_analytics_workspace")
 				if len(ws) == 0 {
-					return false, "Workspace not found"
+					return fail("Workspace not found")
 				}
 				tags, ok := ws[0]["tags"].(map[string]interface{})
-				if !ok || tags["Project"] != "API Ecosystem" {
-					return false, "Missing tag: Project=API Ecosystem"
+				if !ok || tagValue(tags, "Project") != "API Ecosystem" {
+					return fail("Missing tag: Project=API Ecosystem")
 				}
-				return true, ""
+				return pass(1)
 			},
 		},
 		{
 			"3._Verify_Terraform_Outputs_for_Workspace_Name_and_ID",
 			"LogAnalyticsTests",
-			func() (bool, string) {
-				outputs, ok := tfState["outputs"].(map[string]interface{})
-				if !ok {
-					return false, "Outputs block not found"
+			func() CheckResult {
+				if len(tfState.Outputs) == 0 {
+					return fail("Outputs block not found")
 				}
-				idOut, ok1 := outputs["log_analytics_workspace_id"].(map[string]interface{})
-				nameOut, ok2 := outputs["log_analytics_workspace_name"].(map[string]interface{})
-				if !ok1 || idOut["value"] == "" {
-					return false, "Missing or empty log_analytics_workspace_id"
+				idOut, ok1 := tfState.OutputValue("log_analytics_workspace_id")
+				nameOut, ok2 := tfState.OutputValue("log_analytics_workspace_name")
+				if !ok1 || idOut == "" {
+					return fail("Missing or empty log_analytics_workspace_id")
 				}
-				if !ok2 || nameOut["value"] == "" {
-					return false, "Missing or empty log_analytics_workspace_name"
+				if !ok2 || nameOut == "" {
+					return fail("Missing or empty log_analytics_workspace_name")
 				}
-				return true, ""
+				return pass(2)
 			},
 		},
 	}
@@ -4,40 +4,72 @@ import (
 	"strings"
 )
 
-func RunNSGTests(tfState map[string]interface{}) []TestCase {
+func RunNSGTests(tfState *State) []TestCase {
+	// Inline and standalone rules, e.g. the Spoke module's default_nsg_rules, exp_apim_nsg_rules and bst_vm_nsg_rules
+	rules := collectNSGRules(tfState)
+	findings := analyzeNSGRules(rules)
+
 	tests := []GenericTest{
 		{
 			"1._Verify_NSG_Creation_and_Name_Tagging",
 			"NSGTests",
-			func() (bool, string) {
+			func() CheckResult {
 				nsgs := findResourcesByType(tfState, "azurerm_network_security_group")
 				if len(nsgs) == 0 {
-					return false, "No NSG resources found"
+					return fail("No NSG resources found")
 				}
 				for _, nsg := range nsgs {
 					if name, ok := nsg["name"].(string); ok && strings.TrimSpace(name) != "" {
 						tags, ok := nsg["tags"].(map[string]interface{})
 						if !ok || len(tags) == 0 {
-							return false, "NSG has no tags"
+							return fail("NSG has no tags")
 						}
-						return true, ""
+						return pass(1)
 					}
 				}
-				return false, "No NSG with valid name found"
+				return fail("No NSG with valid name found")
 			},
 		},
 		{
 			"2._Verify_NSG_Security_Rules_Configured",
 			"NSGTests",
-			func() (bool, string) {
-				nsgs := findResourcesByType(tfState, "azurerm_network_security_group")
-				for _, nsg := range nsgs {
+			func() CheckResult {
+				return eachInstance(tfState.InstancesByType("azurerm_network_security_group"), func(ri ResourceInstance) CheckResult {
+					nsg := ri.Attributes
 					rules, ok := nsg["security_rule"].([]interface{})
 					if !ok || len(rules) == 0 {
-						return false, "NSG has no security rules configured"
+						return fail("NSG has no security rules configured")
 					}
-				}
-				return true, ""
+					return pass(1)
+				})
+			},
+		},
+		{
+			"3._Verify_No_Management_Ports_Open_To_Internet",
+			"NSGTests",
+			func() CheckResult {
+				return nsgFindingsOf(rules, findings, FindingManagementExposed)
+			},
+		},
+		{
+			"4._Verify_NSG_Rule_Priorities_Unique",
+			"NSGTests",
+			func() CheckResult {
+				return nsgFindingsOf(rules, findings, FindingDuplicatePriority)
+			},
+		},
+		{
+			"5._Verify_No_Shadowed_NSG_Rules",
+			"NSGTests",
+			func() CheckResult {
+				return nsgFindingsOf(rules, findings, FindingShadowed)
+			},
+		},
+		{
+			"6._Verify_No_Allow_Rules_Contradicting_Deny",
+			"NSGTests",
+			func() CheckResult {
+				return nsgFindingsOf(rules, findings, FindingContradicts)
 			},
 		},
 	}
@@ -5,78 +5,70 @@ import (
 	"strings"
 )
 
-func RunDNSTests(tfState map[string]interface{}) []TestCase {
+func RunDNSTests(tfState *State) []TestCase {
 
-	dnsRecords := findResourcesByType(tfState, "azurerm_private_dns_a_record")
+	dnsRecords := tfState.InstancesByType("azurerm_private_dns_a_record")
 
 	tests := []GenericTest{
 		{
 			"1._Validate_Main_APIM_A_Record_Creation",
 			"DNSRecordTests",
-			func() (bool, string) {
+			func() CheckResult {
 				for _, rec := range dnsRecords {
-					if name, ok := rec["name"].(string); ok && strings.Contains(name, "apim") {
-						return true, ""
+					if name, ok := rec.Attributes["name"].(string); ok && strings.Contains(name, "apim") {
+						return pass(1)
 					}
 				}
-				return false, "Main APIM A record not found"
+				return fail("Main APIM A record not found")
 			},
 		},
 		{
 			"2._Validate_Extra_Prefix_DNS_Records_for_each",
 			"DNSRecordTests",
-			func() (bool, string) {
+			func() CheckResult {
 				foundKeys := map[string]bool{}
 				detectedNames := []string{}
 
 				for _, rec := range dnsRecords {
-					name, hasName := rec["name"].(string)
-					indexKey, hasIndexKey := rec["index_key"].(string)
-
-					if hasName {
+					if name, ok := rec.Attributes["name"].(string); ok {
 						detectedNames = append(detectedNames, name)
 					}
 
-					if hasIndexKey && indexKey != "" {
-						foundKeys[indexKey] = true
-					} else if hasName {
-						// If index_key missing, try to extract prefix dynamically from name.
-						// Assuming the prefix is last dot-separated part, or you can customize parsing here.
-						parts := strings.Split(name, ".")
-						prefix := parts[len(parts)-1]
-						foundKeys[prefix] = true
+					// Only the for_each-driven prefix records carry an index key
+					if key := rec.Key(); key != "" {
+						foundKeys[key] = true
 					}
 				}
 
 				if len(foundKeys) == 0 {
-					return false, fmt.Sprintf("No A records with extra prefixes found (for_each logic likely broken). Found names: %v", detectedNames)
+					return fail(fmt.Sprintf("No A records with extra prefixes found (for_each logic likely broken). Found names: %v", detectedNames))
 				}
 
-				return true, fmt.Sprintf("Found A records for prefixes/index_keys: %v", foundKeys)
+				return passWith(len(foundKeys), fmt.Sprintf("Found A records for prefixes/index_keys: %v", foundKeys))
 			},
 		},
 		{
 			"3._Validate_Empty_Prefix_Not_Provisioned",
 			"DNSRecordTests",
-			func() (bool, string) {
-				for _, rec := range dnsRecords {
-					if name, ok := rec["name"].(string); ok && strings.TrimSpace(name) == "" {
-						return false, "Empty DNS record prefix should not be created"
+			func() CheckResult {
+				return eachInstance(dnsRecords, func(rec ResourceInstance) CheckResult {
+					if name, ok := rec.Attributes["name"].(string); ok && strings.TrimSpace(name) == "" {
+						return fail("Empty DNS record prefix should not be created")
 					}
-				}
-				return true, ""
+					return pass(1)
+				})
 			},
 		},
 		{
 			"4._Validate_Private_IPs_Exist_In_Records",
 			"DNSRecordTests",
-			func() (bool, string) {
-				for _, rec := range dnsRecords {
-					if ips, ok := rec["records"].([]interface{}); !ok || len(ips) == 0 {
-						return false, fmt.Sprintf("Record '%v' has no private IPs", rec["name"])
+			func() CheckResult {
+				return eachInstance(dnsRecords, func(rec ResourceInstance) CheckResult {
+					if ips, ok := rec.Attributes["records"].([]interface{}); !ok || len(ips) == 0 {
+						return fail(fmt.Sprintf("Record '%v' has no private IPs", rec.Attributes["name"]))
 					}
-				}
-				return true, ""
+					return pass(1)
+				})
 			},
 		},
 	}
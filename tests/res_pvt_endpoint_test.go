@@ -4,37 +4,49 @@ import (
 	"strings"
 )
 
-func RunPrivateEndpointTests(tfState map[string]interface{}) []TestCase {
+func RunPrivateEndpointTests(tfState *State) []TestCase {
 	tests := []GenericTest{
 		{
 			"1._Verify_Private_Endpoint_Exists_and_Has_Correct_Connection",
 			"PrivateEndpointTests",
-			func() (bool, string) {
+			func() CheckResult {
 				peps := findResourcesByType(tfState, "azurerm_private_endpoint")
 				if len(peps) == 0 {
-					return false, "No private endpoint found"
+					return fail("No private endpoint found")
 				}
 				for _, pep := range peps {
 					if conns, ok := pep["private_service_connection"].([]interface{}); ok && len(conns) > 0 {
-						return true, ""
+						return pass(1)
 					}
 				}
-				return false, "No private service connection found in any private endpoint"
+				return fail("No private service connection found in any private endpoint")
 			},
 		},
 		{
 			"2._Verify_Private_Endpoint_Has_Correct_Subnet_Reference",
 			"PrivateEndpointTests",
-			func() (bool, string) {
+			func() CheckResult {
 				peps := findResourcesByType(tfState, "azurerm_private_endpoint")
 				for _, pep := range peps {
 					if subnetID, ok := pep["subnet_id"].(string); ok && strings.Contains(subnetID, "subnet") {
-						return true, ""
+						return pass(1)
 					}
 				}
-				return false, "No valid subnet_id found for private endpoint"
+				return fail("No valid subnet_id found for private endpoint")
 			},
 		},
+		{"3._Verify_Private_Endpoint_Target_Resolves", "PrivateEndpointTests", func() CheckResult {
+			return checkPrivateEndpointTargets(tfState)
+		}},
+		{"4._Verify_Subresource_Names_Valid_For_Target", "PrivateEndpointTests", func() CheckResult {
+			return checkPrivateEndpointSubresources(tfState)
+		}},
+		{"5._Verify_DNS_Zone_Group_Uses_Privatelink_Zone", "PrivateEndpointTests", func() CheckResult {
+			return checkPrivateEndpointDNSZones(tfState)
+		}},
+		{"6._Verify_Private_Endpoint_IP_In_Its_Subnet", "PrivateEndpointTests", func() CheckResult {
+			return checkPrivateEndpointIPs(tfState)
+		}},
 	}
 
 	return executeTestCases(tests)
@@ -1,39 +1,40 @@
 package test
 
-func RunSubnetTests(tfState map[string]interface{}) []TestCase {
+func RunSubnetTests(tfState *State) []TestCase {
 	tests := []GenericTest{
 		{
 			"1._Verify_Subnet_Created_with_Name_and_Prefix",
 			"SubnetTests",
-			func() (bool, string) {
-				subnets := findResourcesByType(tfState, "azurerm_subnet")
+			func() CheckResult {
+				subnets := tfState.InstancesByType("azurerm_subnet")
 				if len(subnets) == 0 {
-					return false, "No subnets found"
+					return fail("No subnets found")
 				}
-				for _, s := range subnets {
+				return eachInstance(subnets, func(ri ResourceInstance) CheckResult {
+					s := ri.Attributes
 					if name, ok := s["name"].(string); !ok || name == "" {
-						return false, "Subnet missing name"
+						return fail("Subnet missing name")
 					}
 					prefixes, ok := s["address_prefixes"].([]interface{})
 					if !ok || len(prefixes) == 0 {
-						return false, "Missing address_prefixes"
+						return fail("Missing address_prefixes")
 					}
-				}
-				return true, ""
+					return pass(1)
+				})
 			},
 		},
 		{
 			"2._Verify_Subnet_NSG_Association_Exists",
 			"SubnetTests",
-			func() (bool, string) {
-				subnets := findResourcesByType(tfState, "azurerm_subnet")
-				for _, s := range subnets {
+			func() CheckResult {
+				return eachInstance(tfState.InstancesByType("azurerm_subnet"), func(ri ResourceInstance) CheckResult {
+					s := ri.Attributes
 					if s["network_security_group_id"] == nil {
 						name, _ := s["name"].(string)
-						return false, "Subnet " + name + " has no NSG associated"
+						return fail("Subnet " + name + " has no NSG associated")
 					}
-				}
-				return true, ""
+					return pass(1)
+				})
 			},
 		},
 	}
@@ -1,24 +1,24 @@
 package test
 
-func RunSubnetWithDelegationTests(tfState map[string]interface{}) []TestCase {
+func RunSubnetWithDelegationTests(tfState *State) []TestCase {
 	tests := []GenericTest{
 		{
 			"1._Verify_Subnet_With_Delegation_Exists",
 			"SubnetDelegationTests",
-			func() (bool, string) {
+			func() CheckResult {
 				subnets := findResourcesByType(tfState, "azurerm_subnet")
 				for _, s := range subnets {
 					if _, ok := s["delegation"]; ok {
-						return true, ""
+						return pass(1)
 					}
 				}
-				return false, "No subnet found with delegation"
+				return fail("No subnet found with delegation")
 			},
 		},
 		{
 			"2._Verify_Delegation_Config_Contains_Microsoft_Web_ServerFarms",
 			"SubnetDelegationTests",
-			func() (bool, string) {
+			func() CheckResult {
 				subnets := findResourcesByType(tfState, "azurerm_subnet")
 				for _, s := range subnets {
 					if delegs, ok := s["delegation"].([]interface{}); ok {
@@ -29,7 +29,7 @@ func RunSubnetWithDelegationTests(tfState map[string]interface{}) []TestCase {
 										for _, c := range config {
 											if cMap, ok := c.(map[string]interface{}); ok {
 												if cMap["name"] == "Microsoft.Web/serverFarms" {
-													return true, ""
+													return pass(1)
 												}
 											}
 										}
@@ -39,7 +39,7 @@ func RunSubnetWithDelegationTests(tfState map[string]interface{}) []TestCase {
 						}
 					}
 				}
-				return false, "No valid service delegation found for Microsoft.Web/serverFarms"
+				return fail("No valid service delegation found for Microsoft.Web/serverFarms")
 			},
 		},
 	}
@@ -1,105 +1,230 @@
 package test
 
 import (
-	"encoding/json"
+	"context"
 	"encoding/xml"
 	"flag"
 	"fmt"
-	"io"
-	"net/http"
 	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strconv"
+	"strings"
 	"testing"
-)
+	"time"
 
-// CLI flags
-var (
-	envFlag         = flag.String("env", "", "Environment name (e.g. dev, prod)")
-	remoteStateFlag = flag.String("remoteStateURL", "", "Remote Terraform state URL")
+	"tests/config"
 )
 
-// Config structure for test settings
-type Config struct {
-	Environment    string `json:"environment"`
-	RemoteStateURL string `json:"remote_state_url"`
+// CLI flags: -config, -profile, -env, -remoteStateURL, -stateFile, -planFile and -allowConfigCredentials
+var configFlags = config.RegisterFlags(flag.CommandLine)
+
+// Load the layered test configuration; see config.Load for precedence
+func LoadTestConfig(t *testing.T) *config.Config {
+	cfg, err := config.Load(configFlags, os.Getenv)
+	if err != nil {
+		fatalf(t, "❌ Invalid test configuration: %v", err)
+	}
+	for _, w := range cfg.Warnings {
+		logf(t, "⚠️ %s", w)
+	}
+	return cfg
+}
+
+// t.Logf with credentials masked
+func logf(t *testing.T, format string, args ...interface{}) {
+	t.Helper()
+	t.Log(config.Redact(fmt.Sprintf(format, args...)))
 }
 
-// Load test configuration from flag, env, or config file fallback
-func LoadTestConfig(t *testing.T) *Config {
-	flag.Parse()
+// t.Fatalf with credentials masked; errors from state sources can echo URLs and tokens
+func fatalf(t *testing.T, format string, args ...interface{}) {
+	t.Helper()
+	t.Fatal(config.Redact(fmt.Sprintf(format, args...)))
+}
 
-	cfg := &Config{}
+// Pick the state source: a local file wins over a URL, which wins over the configured backend
+func stateSourceFromConfig(cfg *config.Config) (StateSource, error) {
+	if cfg.StateFile == "" && cfg.RemoteStateURL == "" && cfg.Backend == nil {
+		return nil, fmt.Errorf("no state source configured; use -stateFile, -remoteStateURL, TF_REMOTE_STATE_URL or a backend block in config.json")
+	}
+	return stateSource(cfg.StateFile, cfg.RemoteStateURL, cfg.Backend)
+}
 
-	// Optional: load from config.json
-	data, err := os.ReadFile("config.json")
-	if err == nil {
-		_ = json.Unmarshal(data, cfg)
+func stateSource(stateFile, remoteStateURL string, backend *config.Backend) (StateSource, error) {
+	switch {
+	case stateFile != "":
+		return &FileStateSource{Path: stateFile}, nil
+	case remoteStateURL != "":
+		return &HTTPStateSource{Address: remoteStateURL}, nil
 	}
 
-	// Override from flags or env
-	if *envFlag != "" {
-		cfg.Environment = *envFlag
-	} else if env := os.Getenv("TEST_ENV"); env != "" {
-		cfg.Environment = env
+	switch backend.Type {
+	case "azurerm":
+		cred, err := blobCredentialFromEnv()
+		if err != nil {
+			return nil, fmt.Errorf("azurerm backend: %w", err)
+		}
+		return &AzureBlobStateSource{
+			StorageAccount: backend.StorageAccountName,
+			Container:      backend.ContainerName,
+			Key:            backend.Key,
+			Endpoint:       os.Getenv("ARM_BLOB_ENDPOINT"),
+			Credential:     cred,
+		}, nil
+	case "http":
+		return &HTTPStateSource{
+			Address:  backend.Address,
+			Username: os.Getenv("TF_HTTP_USERNAME"),
+			Password: os.Getenv("TF_HTTP_PASSWORD"),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported backend type %q", backend.Type)
 	}
+}
 
-	if *remoteStateFlag != "" {
-		cfg.RemoteStateURL = *remoteStateFlag
-	} else if url := os.Getenv("TF_REMOTE_STATE_URL"); url != "" {
-		cfg.RemoteStateURL = url
+// Load and parse the Terraform state from the configured source
+func loadTFState(t *testing.T, cfg *config.Config) (*State, StateSource) {
+	source, err := stateSourceFromConfig(cfg)
+	if err != nil {
+		fatalf(t, "❌ Failed to configure Terraform state source: %v", err)
 	}
 
-	if cfg.RemoteStateURL == "" {
-		t.Fatal("❌ Missing remote state URL. Use -remoteStateURL or set TF_REMOTE_STATE_URL.")
+	tfState, err := source.Load(context.Background())
+	if err != nil {
+		fatalf(t, "❌ Failed to load Terraform state from %s: %v", source.Describe(), err)
 	}
+	// Values marked sensitive in state are masked in every message and report from here on
+	config.RegisterSecrets(tfState.SensitiveValues()...)
+	return tfState, source
+}
 
-	return cfg
+// NamedState is a state read alongside the environment's own, e.g. the hub's
+type NamedState struct {
+	Name  string
+	State *State
 }
 
-// Download and parse remote Terraform state
-func loadRemoteTFState(t *testing.T, url string) map[string]interface{} {
-	resp, err := http.Get(url)
-	if err != nil {
-		t.Fatalf("❌ Failed to fetch remote Terraform state: %v", err)
+// Load every related_states entry of the configuration
+func loadRelatedStates(t *testing.T, cfg *config.Config) []NamedState {
+	var related []NamedState
+	for _, ref := range cfg.RelatedStates {
+		source, err := stateSource(ref.StateFile, ref.RemoteStateURL, ref.Backend)
+		if err != nil {
+			fatalf(t, "❌ Failed to configure related state %q: %v", ref.Name, err)
+		}
+		tfState, err := source.Load(context.Background())
+		if err != nil {
+			fatalf(t, "❌ Failed to load related state %q from %s: %v", ref.Name, source.Describe(), err)
+		}
+		config.RegisterSecrets(tfState.SensitiveValues()...)
+		related = append(related, NamedState{Name: ref.Name, State: tfState})
 	}
-	defer resp.Body.Close()
+	return related
+}
+
+// Suite properties identifying exactly which state a report was produced from
+func stateProperties(cfg *config.Config, tfState *State, location string) []Property {
+	return []Property{
+		{Name: "environment", Value: cfg.Environment},
+		{Name: "state_serial", Value: strconv.FormatInt(tfState.Serial, 10)},
+		{Name: "state_lineage", Value: tfState.Lineage},
+		{Name: "terraform_version", Value: tfState.TerraformVersion},
+		{Name: "state_url", Value: config.RedactURL(location)},
+	}
+}
+
+// Suite properties for reports produced from a plan file
+func planProperties(cfg *config.Config, plan *Plan) []Property {
+	return []Property{
+		{Name: "environment", Value: cfg.Environment},
+		{Name: "terraform_version", Value: plan.TerraformVersion},
+		{Name: "plan_file", Value: cfg.PlanFile},
+	}
+}
 
-	body, err := io.ReadAll(resp.Body)
+// Load and parse the plan JSON named by -planFile or TF_PLAN_FILE
+func loadTFPlan(t *testing.T, cfg *config.Config) *Plan {
+	plan, err := loadPlanFile(cfg.PlanFile)
 	if err != nil {
-		t.Fatalf("❌ Failed to read state body: %v", err)
+		fatalf(t, "❌ Failed to load Terraform plan %s: %v", cfg.PlanFile, err)
 	}
+	config.RegisterSecrets(plan.SensitiveValues()...)
+	return plan
+}
 
-	var tfState map[string]interface{}
-	if err := json.Unmarshal(body, &tfState); err != nil {
-		t.Fatalf("❌ Failed to parse Terraform state: %v", err)
+// Load the expectations for the environment selected by -env, TEST_ENV or config.json
+func loadEnvExpectations(t *testing.T, cfg *config.Config) *Expectations {
+	exp, err := loadExpectationsFile(cfg.Expectations, cfg.Environment)
+	if err != nil {
+		fatalf(t, "❌ Failed to load expectations for %q: %v", cfg.Environment, err)
 	}
-	return tfState
+	return exp
 }
 
-// Return all resources of a given type from the Terraform state
-func findResourcesByType(tfState map[string]interface{}, resourceType string) []map[string]interface{} {
-	var results []map[string]interface{}
-	resources, ok := tfState["resources"].([]interface{})
-	if !ok {
-		return results
+// Return the attributes of all managed resources of a given type from the Terraform state
+func findResourcesByType(tfState *State, resourceType string) []map[string]interface{} {
+	return attributesOf(tfState.InstancesByType(resourceType))
+}
+
+// Return the attributes of all managed resources of a given type owned by a module call, e.g. module.exp
+func findModuleResourcesByType(tfState *State, module, resourceType string) []map[string]interface{} {
+	return attributesOf(findModuleInstancesByType(tfState, module, resourceType))
+}
+
+// Return all managed instances of a given type owned by a module call and its children
+func findModuleInstancesByType(tfState *State, module, resourceType string) []ResourceInstance {
+	return tfState.Query(Query{
+		Module:      moduleSegments(module),
+		Descendants: true,
+		Mode:        ModeManaged,
+		Type:        resourceType,
+	})
+}
+
+// Return all managed instances of any of the given types, type by type
+func findInstancesByTypes(tfState *State, resourceTypes ...string) []ResourceInstance {
+	var instances []ResourceInstance
+	for _, t := range resourceTypes {
+		instances = append(instances, tfState.InstancesByType(t)...)
 	}
+	return instances
+}
 
-	for _, res := range resources {
-		rm, ok := res.(map[string]interface{})
-		if !ok || rm["type"] != resourceType {
-			continue
-		}
-		instances, ok := rm["instances"].([]interface{})
-		if !ok {
-			continue
+// Types this repo deploys with a managed identity: system-assigned on the VM,
+// function apps and APIM, or a standalone user-assigned identity
+var identityTypes = []string{
+	"azurerm_windows_virtual_machine",
+	"azurerm_windows_function_app",
+	"azurerm_api_management",
+	"azurerm_user_assigned_identity",
+}
+
+// Check a resource's managed identity: a user-assigned identity has a client id,
+// anything else an identity block with a principal once applied
+func checkManagedIdentity(ri ResourceInstance) CheckResult {
+	a := ri.Attr()
+	if ri.Type == "azurerm_user_assigned_identity" {
+		if id, _ := a.String("client_id"); id == "" {
+			return fail("Managed Identity missing client ID")
 		}
-		for _, inst := range instances {
-			im, ok := inst.(map[string]interface{})
-			if !ok {
-				continue
-			}
-			if attrs, ok := im["attributes"].(map[string]interface{}); ok {
-				results = append(results, attrs)
-			}
+		return pass(1)
+	}
+	identityType, err := a.String("identity.0.type")
+	if err != nil || identityType == "" {
+		return fail("No managed identity")
+	}
+	if principal, _ := a.String("identity.0.principal_id"); principal == "" && strings.Contains(identityType, "SystemAssigned") {
+		return notApplicable("principal_id is not known until apply")
+	}
+	return pass(1)
+}
+
+func attributesOf(instances []ResourceInstance) []map[string]interface{} {
+	var results []map[string]interface{}
+	for _, ri := range instances {
+		if ri.Attributes != nil {
+			results = append(results, ri.Attributes)
 		}
 	}
 	return results
@@ -109,45 +234,145 @@ func findResourcesByType(tfState map[string]interface{}, resourceType string) []
 type GenericTest struct {
 	Name     string
 	Class    string
-	Validate func() (bool, string)
+	Validate func() CheckResult
+}
+
+// CheckResult is the outcome of one check. Evaluated counts the resources the
+// check actually looked at, so a check that matched nothing is reported as
+// skipped instead of passing vacuously.
+type CheckResult struct {
+	Pass      bool
+	Message   string
+	Evaluated int
+	Instances []InstanceResult // per-instance outcomes; each becomes its own test case
+	Panic     string           // set when the check panicked instead of returning
+	Stack     string
+}
+
+// InstanceResult is the outcome of a check against one resource instance
+type InstanceResult struct {
+	Address string
+	CheckResult
+}
+
+// The check held for all evaluated resources
+func pass(evaluated int) CheckResult {
+	return CheckResult{Pass: true, Evaluated: evaluated}
+}
+
+// The check held; msg is kept in the report's system-out
+func passWith(evaluated int, msg string) CheckResult {
+	return CheckResult{Pass: true, Message: msg, Evaluated: evaluated}
+}
+
+// The check did not hold
+func fail(msg string) CheckResult {
+	return CheckResult{Pass: false, Message: msg}
+}
+
+// Nothing the check applies to exists, e.g. no resources of the type in state
+func notApplicable(msg string) CheckResult {
+	return CheckResult{Pass: true, Message: msg}
+}
+
+// Run check against every instance, collecting all violations instead of stopping at the first
+func eachInstance(instances []ResourceInstance, check func(ri ResourceInstance) CheckResult) CheckResult {
+	res := CheckResult{Pass: true}
+	for _, ri := range instances {
+		r := runCheck(func() CheckResult { return check(ri) })
+		res.Instances = append(res.Instances, InstanceResult{Address: ri.Address(), CheckResult: r})
+		res.Evaluated += r.Evaluated
+		res.Pass = res.Pass && r.Pass
+	}
+	return res
+}
+
+// Run a check, turning a panic into an error result so the rest of the suite still runs
+func runCheck(check func() CheckResult) (res CheckResult) {
+	defer func() {
+		if r := recover(); r != nil {
+			res = CheckResult{Panic: fmt.Sprint(r), Stack: string(debug.Stack())}
+		}
+	}()
+	return check()
 }
 
 // Convert GenericTest into standardized TestCase for reporting
 func executeTestCases(tests []GenericTest) []TestCase {
 	var cases []TestCase
 	for _, tc := range tests {
-		pass, msg := tc.Validate()
-		status := "PASS"
-		var fail *Failure
-		if !pass {
-			status = "FAIL"
-			fail = &Failure{Message: msg, Type: "failure"}
+		started := time.Now()
+		res := runCheck(tc.Validate)
+		elapsed := time.Since(started).Seconds()
+		if len(res.Instances) == 0 {
+			cases = append(cases, newCheckCase(tc.Class, tc.Name, elapsed, res))
+			continue
+		}
+		// One test case per instance, named <check>/<address>; the check's time is shared out evenly
+		for _, inst := range res.Instances {
+			cases = append(cases, newCheckCase(tc.Class, tc.Name+"/"+inst.Address, elapsed/float64(len(res.Instances)), inst.CheckResult))
 		}
-		cases = append(cases, TestCase{
-			Classname: tc.Class,
-			Name:      tc.Name,
-			Status:    status,
-			Failure:   fail,
-		})
 	}
 	return cases
 }
 
+func newCheckCase(class, name string, elapsed float64, res CheckResult) TestCase {
+	result := TestCase{
+		Classname: class,
+		Name:      name,
+		Time:      elapsed,
+		Status:    StatusPass,
+	}
+	res.Message = config.Redact(res.Message)
+	switch {
+	case res.Panic != "":
+		result.Status = StatusError
+		result.Error = &Failure{Message: "check panicked: " + config.Redact(res.Panic), Type: "panic"}
+		result.SystemErr = config.Redact(res.Stack)
+	case !res.Pass:
+		result.Status = StatusFail
+		result.Failure = &Failure{Message: res.Message, Type: "failure"}
+	case res.Evaluated == 0:
+		msg := res.Message
+		if msg == "" {
+			msg = "no resources evaluated"
+		}
+		result.Status = StatusSkipped
+		result.Skipped = &Skipped{Message: msg}
+	default:
+		result.SystemOut = fmt.Sprintf("evaluated %d resource(s)", res.Evaluated)
+		if res.Message != "" {
+			result.SystemOut += "\n" + res.Message
+		}
+	}
+	return result
+}
+
+// Fail the test once a report exceeds the profile's thresholds
+func checkThresholds(t *testing.T, cfg *config.Config, report TestSuites) {
+	if limit := cfg.Thresholds.MaxFailures; limit != nil && report.Failures > *limit {
+		t.Errorf("❌ %d failed checks exceed the %q threshold of %d", report.Failures, cfg.Profile, *limit)
+	}
+	if limit := cfg.Thresholds.MaxErrors; limit != nil && report.Errors > *limit {
+		t.Errorf("❌ %d checks that could not run exceed the %q threshold of %d", report.Errors, cfg.Profile, *limit)
+	}
+}
+
 // Write combined JUnit-style XML report
-func writeReport(t *testing.T, suite TestSuite, path string) {
-	if err := os.MkdirAll("reports", 0755); err != nil {
-		t.Fatalf("❌ Failed to create report directory: %v", err)
+func writeReport(t *testing.T, suites TestSuites, path string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		fatalf(t, "❌ Failed to create report directory: %v", err)
 	}
 	file, err := os.Create(path)
 	if err != nil {
-		t.Fatalf("❌ Unable to create report file: %v", err)
+		fatalf(t, "❌ Unable to create report file: %v", err)
 	}
 	defer file.Close()
 
 	xmlWriter := xml.NewEncoder(file)
 	xmlWriter.Indent("", "  ")
-	if err := xmlWriter.Encode(suite); err != nil {
-		t.Fatalf("❌ Failed to encode XML: %v", err)
+	if err := xmlWriter.Encode(suites.redacted()); err != nil {
+		fatalf(t, "❌ Failed to encode XML: %v", err)
 	}
 
 	fmt.Println("📄 Combined report written to", path)
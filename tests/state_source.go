@@ -0,0 +1,264 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"tests/config"
+)
+
+// StateSource loads a Terraform state from wherever an environment keeps it
+type StateSource interface {
+	Load(ctx context.Context) (*State, error)
+	// Describe names the location without any credential, for logs and reports
+	Describe() string
+}
+
+// FileStateSource reads a raw .tfstate or `terraform show -json` output from disk
+type FileStateSource struct {
+	Path string
+}
+
+func (s *FileStateSource) Load(ctx context.Context) (*State, error) {
+	data, err := os.ReadFile(s.Path)
+	if err != nil {
+		return nil, err
+	}
+	return parseStateDocument(data)
+}
+
+func (s *FileStateSource) Describe() string {
+	return "file://" + s.Path
+}
+
+// AzureBlobStateSource reads state the way the azurerm backend stores it:
+// one blob per workspace, addressed by storage account, container and key
+type AzureBlobStateSource struct {
+	StorageAccount string
+	Container      string
+	Key            string
+	Endpoint       string // defaults to https://<StorageAccount>.blob.core.windows.net
+	Credential     BlobCredential
+	Client         *http.Client
+}
+
+// BlobCredential authorizes a blob request
+type BlobCredential interface {
+	Authorize(ctx context.Context, req *http.Request) error
+}
+
+// Azure Storage REST API version sent with every blob request
+const blobAPIVersion = "2023-11-03"
+
+func (s *AzureBlobStateSource) blobURL() string {
+	endpoint := s.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://%s.blob.core.windows.net", s.StorageAccount)
+	}
+	return fmt.Sprintf("%s/%s/%s", strings.TrimSuffix(endpoint, "/"), s.Container, s.Key)
+}
+
+func (s *AzureBlobStateSource) Load(ctx context.Context) (*State, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.blobURL(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("x-ms-version", blobAPIVersion)
+	if s.Credential == nil {
+		return nil, fmt.Errorf("no credential for %s", s.Describe())
+	}
+	if err := s.Credential.Authorize(ctx, req); err != nil {
+		return nil, fmt.Errorf("authorizing %s: %w", s.Describe(), err)
+	}
+
+	body, err := doStateRequest(clientOrDefault(s.Client), req, s.Describe())
+	if err != nil {
+		return nil, err
+	}
+	return parseState(body)
+}
+
+func (s *AzureBlobStateSource) Describe() string {
+	return s.blobURL()
+}
+
+// SASCredential appends a shared access signature to the request URL
+type SASCredential struct {
+	Token string
+}
+
+func (c *SASCredential) Authorize(ctx context.Context, req *http.Request) error {
+	sas, err := url.ParseQuery(strings.TrimPrefix(c.Token, "?"))
+	if err != nil {
+		return fmt.Errorf("malformed SAS token: %w", err)
+	}
+	query := req.URL.Query()
+	for k, v := range sas {
+		query[k] = v
+	}
+	req.URL.RawQuery = query.Encode()
+	return nil
+}
+
+// ClientSecretCredential gets a bearer token for Azure Storage with the
+// OAuth2 client credentials grant against Microsoft Entra ID
+type ClientSecretCredential struct {
+	TenantID      string
+	ClientID      string
+	ClientSecret  string
+	AuthorityHost string // defaults to https://login.microsoftonline.com
+	Client        *http.Client
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// Scope requested for blob data access
+const storageScope = "https://storage.azure.com/.default"
+
+func (c *ClientSecretCredential) Authorize(ctx context.Context, req *http.Request) error {
+	token, err := c.accessToken(ctx)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+func (c *ClientSecretCredential) accessToken(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.token != "" && time.Now().Before(c.expires) {
+		return c.token, nil
+	}
+
+	authority := c.AuthorityHost
+	if authority == "" {
+		authority = "https://login.microsoftonline.com"
+	}
+	tokenURL := fmt.Sprintf("%s/%s/oauth2/v2.0/token", strings.TrimSuffix(authority, "/"), c.TenantID)
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {c.ClientID},
+		"client_secret": {c.ClientSecret},
+		"scope":         {storageScope},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := clientOrDefault(c.Client).Do(req)
+	if err != nil {
+		return "", fmt.Errorf("token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var payload struct {
+		AccessToken      string `json:"access_token"`
+		ExpiresIn        int64  `json:"expires_in"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		return "", fmt.Errorf("token response (HTTP %d) is not JSON: %w", resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK || payload.AccessToken == "" {
+		return "", fmt.Errorf("token request rejected (HTTP %d): %s %s", resp.StatusCode, payload.Error, payload.ErrorDescription)
+	}
+
+	c.token = payload.AccessToken
+	// Refresh a minute early so a token never expires mid-request
+	c.expires = time.Now().Add(time.Duration(payload.ExpiresIn)*time.Second - time.Minute)
+	return c.token, nil
+}
+
+// Pick a blob credential from the same ARM_* variables the azurerm backend reads
+func blobCredentialFromEnv() (BlobCredential, error) {
+	if sas := os.Getenv("ARM_SAS_TOKEN"); sas != "" {
+		return &SASCredential{Token: sas}, nil
+	}
+	tenant, client, secret := os.Getenv("ARM_TENANT_ID"), os.Getenv("ARM_CLIENT_ID"), os.Getenv("ARM_CLIENT_SECRET")
+	if tenant != "" && client != "" && secret != "" {
+		return &ClientSecretCredential{
+			TenantID:      tenant,
+			ClientID:      client,
+			ClientSecret:  secret,
+			AuthorityHost: os.Getenv("AZURE_AUTHORITY_HOST"),
+		}, nil
+	}
+	return nil, fmt.Errorf("set ARM_SAS_TOKEN, or ARM_TENANT_ID, ARM_CLIENT_ID and ARM_CLIENT_SECRET")
+}
+
+// HTTPStateSource reads state through the Terraform HTTP backend protocol (GET on the address)
+type HTTPStateSource struct {
+	Address  string
+	Username string
+	Password string
+	Client   *http.Client
+}
+
+func (s *HTTPStateSource) Load(ctx context.Context) (*State, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.Address, nil)
+	if err != nil {
+		return nil, err
+	}
+	if s.Username != "" || s.Password != "" {
+		req.SetBasicAuth(s.Username, s.Password)
+	}
+
+	body, err := doStateRequest(clientOrDefault(s.Client), req, s.Describe())
+	if err != nil {
+		return nil, err
+	}
+	return parseStateDocument(body)
+}
+
+func (s *HTTPStateSource) Describe() string {
+	return config.RedactURL(s.Address)
+}
+
+func doStateRequest(client *http.Client, req *http.Request, location string) ([]byte, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching %s: %w", location, redactURLError(err))
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", location, err)
+	}
+	switch {
+	case resp.StatusCode == http.StatusNoContent, resp.StatusCode == http.StatusNotFound:
+		return nil, fmt.Errorf("no state at %s (HTTP %d)", location, resp.StatusCode)
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("fetching %s: HTTP %d", location, resp.StatusCode)
+	}
+	return body, nil
+}
+
+// url.Error embeds the full request URL, SAS signature included
+func redactURLError(err error) error {
+	if urlErr, ok := err.(*url.Error); ok {
+		return urlErr.Err
+	}
+	return err
+}
+
+func clientOrDefault(client *http.Client) *http.Client {
+	if client != nil {
+		return client
+	}
+	return &http.Client{Timeout: 60 * time.Second}
+}
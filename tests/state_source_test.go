@@ -0,0 +1,142 @@
+package test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileStateSourceReadsRawAndShowFormats(t *testing.T) {
+	raw, err := (&FileStateSource{Path: "testdata/minimal.tfstate"}).Load(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "3f2a7c1e-5b9d-4e8a-9c61-0d2f8e7b4a10", raw.Lineage)
+
+	show, err := (&FileStateSource{Path: "testdata/show.json"}).Load(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "1.11.4", show.TerraformVersion)
+
+	records, err := show.Select("module.exp.module.apim.azurerm_private_dns_a_record.apim_dns_records")
+	require.NoError(t, err)
+	require.Len(t, records, 2, "for_each instances are regrouped under one resource")
+	assert.Equal(t, `module.exp.module.apim.azurerm_private_dns_a_record.apim_dns_records["management"]`, records[0].Address())
+
+	vms := show.InstancesByType("azurerm_windows_virtual_machine")
+	require.Len(t, vms, 1)
+	assert.Equal(t, "module.bastion.module.bastion_vm", vms[0].Module)
+	require.Len(t, vms[0].SensitiveAttributes, 1)
+	assert.Equal(t, `"admin_password"`, string(vms[0].SensitiveAttributes[0][0].Value))
+}
+
+func TestAzureBlobStateSourceWithClientSecret(t *testing.T) {
+	state, err := os.ReadFile("testdata/minimal.tfstate")
+	require.NoError(t, err)
+
+	var tokenCalls int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/tenant-id/oauth2/v2.0/token", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&tokenCalls, 1)
+		assert.NoError(t, r.ParseForm())
+		assert.Equal(t, "client_credentials", r.PostForm.Get("grant_type"))
+		assert.Equal(t, "https://storage.azure.com/.default", r.PostForm.Get("scope"))
+		if r.PostForm.Get("client_secret") != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error":"invalid_client","error_description":"bad secret"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"access_token":"token-123","expires_in":3600}`))
+	})
+	mux.HandleFunc("/tfstate/Dev/global.tfstate", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token-123" || r.Header.Get("x-ms-version") == "" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		_, _ = w.Write(state)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	cred := &ClientSecretCredential{TenantID: "tenant-id", ClientID: "client", ClientSecret: "s3cret", AuthorityHost: server.URL}
+	source := &AzureBlobStateSource{
+		StorageAccount: "agidamainuaentfsa",
+		Container:      "tfstate",
+		Key:            "Dev/global.tfstate",
+		Endpoint:       server.URL,
+		Credential:     cred,
+	}
+	for i := 0; i < 2; i++ {
+		loaded, err := source.Load(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, int64(42), loaded.Serial)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&tokenCalls), "token is cached until it expires")
+
+	source.Credential = &ClientSecretCredential{TenantID: "tenant-id", ClientID: "client", ClientSecret: "wrong", AuthorityHost: server.URL}
+	_, err = source.Load(context.Background())
+	assert.ErrorContains(t, err, "invalid_client")
+}
+
+func TestAzureBlobStateSourceWithSAS(t *testing.T) {
+	state, err := os.ReadFile("testdata/minimal.tfstate")
+	require.NoError(t, err)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("sig") != "abc" || r.URL.Query().Get("sp") != "r" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		_, _ = w.Write(state)
+	}))
+	defer server.Close()
+
+	source := &AzureBlobStateSource{
+		Container:  "tfstate",
+		Key:        "Dev/global.tfstate",
+		Endpoint:   server.URL,
+		Credential: &SASCredential{Token: "?sp=r&sig=abc"},
+	}
+	loaded, err := source.Load(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "1.11.4", loaded.TerraformVersion)
+	assert.NotContains(t, source.Describe(), "sig=")
+
+	source.Credential = &SASCredential{Token: "sp=r&sig=wrong"}
+	_, err = source.Load(context.Background())
+	assert.ErrorContains(t, err, "HTTP 403")
+}
+
+func TestHTTPStateSourceFollowsBackendProtocol(t *testing.T) {
+	state, err := os.ReadFile("testdata/minimal.tfstate")
+	require.NoError(t, err)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		switch {
+		case r.Method != http.MethodGet:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		case !ok || user != "terratest" || pass != "pw":
+			w.WriteHeader(http.StatusUnauthorized)
+		case r.URL.Path == "/empty":
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			_, _ = w.Write(state)
+		}
+	}))
+	defer server.Close()
+
+	source := &HTTPStateSource{Address: server.URL + "/state/dev", Username: "terratest", Password: "pw"}
+	loaded, err := source.Load(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, loaded.Resources, 4)
+
+	_, err = (&HTTPStateSource{Address: server.URL + "/empty", Username: "terratest", Password: "pw"}).Load(context.Background())
+	assert.ErrorContains(t, err, "no state")
+
+	_, err = (&HTTPStateSource{Address: server.URL + "/state/dev"}).Load(context.Background())
+	assert.ErrorContains(t, err, "HTTP 401")
+}
@@ -4,40 +4,52 @@ import (
 	"strings"
 )
 
-func RunSysTests(tfState map[string]interface{}) []TestCase {
+func RunSysTests(tfState *State) []TestCase {
 	tests := []GenericTest{
-		{"1._Verify_System_Resource_Group_Existence_and_Properties", "SystemInfraTests", func() (bool, string) {
-			rgs := findResourcesByType(tfState, "azurerm_resource_group")
+		{"1._Verify_System_Resource_Group_Existence_and_Properties", "SystemInfraTests", func() CheckResult {
+			rgs := findModuleInstancesByType(tfState, "module.sys", "azurerm_resource_group")
 			for _, rg := range rgs {
-				if strings.Contains(rg["name"].(string), "sys-rg") {
-					if loc, _ := rg["location"].(string); loc != "" {
-						return true, ""
+				name, err := rg.Attr().String("name")
+				if err != nil {
+					return fail(err.Error())
+				}
+				if strings.Contains(name, "sys-rg") {
+					if loc, _ := rg.Attr().String("location"); loc != "" {
+						return pass(1)
 					}
 				}
 			}
-			return false, "sys-rg not found or invalid"
+			return fail("sys-rg not found in module.sys or invalid")
 		}},
-		{"2._Verify_Azure_Function_App_Existence_and_Configuration", "SystemInfraTests", func() (bool, string) {
-			fapps := findResourcesByType(tfState, "azurerm_windows_function_app")
+		{"2._Verify_Azure_Function_App_Existence_and_Configuration", "SystemInfraTests", func() CheckResult {
+			fapps := findModuleInstancesByType(tfState, "module.sys", "azurerm_windows_function_app")
 			for _, fa := range fapps {
-				if strings.Contains(fa["name"].(string), "sysReady-fapp") {
-					if loc, _ := fa["location"].(string); loc != "" {
-						return true, ""
+				name, err := fa.Attr().String("name")
+				if err != nil {
+					return fail(err.Error())
+				}
+				if strings.Contains(name, "sysReady-fapp") {
+					if loc, _ := fa.Attr().String("location"); loc != "" {
+						return pass(1)
 					}
 				}
 			}
-			return false, "sysReady-fapp not found or invalid"
+			return fail("sysReady-fapp not found in module.sys or invalid")
 		}},
-		{"3._Verify_Private_Endpoint_Existence_and_Configuration", "SystemInfraTests", func() (bool, string) {
-			peps := findResourcesByType(tfState, "azurerm_private_endpoint")
+		{"3._Verify_Private_Endpoint_Existence_and_Configuration", "SystemInfraTests", func() CheckResult {
+			peps := findModuleInstancesByType(tfState, "module.sys", "azurerm_private_endpoint")
 			for _, pep := range peps {
-				if strings.Contains(strings.ToLower(pep["name"].(string)), "sysready-fapp-pep") {
-					if conn, ok := pep["private_service_connection"].([]interface{}); ok && len(conn) > 0 {
-						return true, ""
+				name, err := pep.Attr().String("name")
+				if err != nil {
+					return fail(err.Error())
+				}
+				if strings.Contains(strings.ToLower(name), "sysready-fapp-pep") {
+					if conn, err := pep.Attr().List("private_service_connection"); err == nil && len(conn) > 0 {
+						return pass(1)
 					}
 				}
 			}
-			return false, "sysfappReady-pep not found or misconfigured"
+			return fail("sysfappReady-pep not found in module.sys or misconfigured")
 		}},
 	}
 
@@ -0,0 +1,275 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Tag keys every resource carries, the values each may take, and which ones a
+// resource may leave to its resource group
+const tagPolicyPath = "tags/policy.json"
+
+// TagPolicy is the tagging standard. Keys are case-sensitive: a Project tag
+// spelled project is a violation, and so is carrying both.
+type TagPolicy struct {
+	Required      []string            `json:"required"`
+	AllowedValues map[string][]string `json:"allowed_values"` // keys without an entry take any value
+	Inherited     []string            `json:"inherited"`      // required keys satisfied by the resource group's tag
+}
+
+// Kinds of tag violation, one check each
+const (
+	tagMissing      = "missing"
+	tagValueInvalid = "value"
+	tagCase         = "case"
+)
+
+type tagViolation struct {
+	kind    string
+	message string
+}
+
+// A resource group's tags, for the resources in it to inherit
+type resourceGroupTags struct {
+	address string
+	tags    map[string]interface{}
+}
+
+// The group's tag key, exactly as spelled; nil when rg is nil
+func (rg *resourceGroupTags) inherited(key string) interface{} {
+	if rg == nil {
+		return nil
+	}
+	return rg.tags[key]
+}
+
+func loadTagPolicy(path string) (*TagPolicy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	policy := &TagPolicy{}
+	if err := json.Unmarshal(data, policy); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := policy.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return policy, nil
+}
+
+// Check that no two keys differ only in case and only required keys are inherited
+func (p *TagPolicy) validate() error {
+	seen := map[string]string{}
+	for _, key := range p.keys() {
+		if other, ok := seen[strings.ToLower(key)]; ok {
+			return fmt.Errorf("tag keys %s and %s differ only in case", other, key)
+		}
+		seen[strings.ToLower(key)] = key
+	}
+	for _, key := range p.Inherited {
+		if !contains(p.Required, key) {
+			return fmt.Errorf("inherited tag %s is not required", key)
+		}
+	}
+	return nil
+}
+
+// Every key the policy names, sorted
+func (p *TagPolicy) keys() []string {
+	keys := append([]string(nil), p.Required...)
+	for key := range p.AllowedValues {
+		if !contains(keys, key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Values key may take; an environment's expectations pin Environment to its own name
+func (p *TagPolicy) allowed(key string, exp *Expectations) []string {
+	if key == "Environment" && exp != nil && exp.Environment != "" {
+		return []string{exp.Environment}
+	}
+	return p.AllowedValues[key]
+}
+
+// Value of a tag, by its exact key or else the one key that matches it ignoring case.
+// Checks use this so they do not depend on which spelling a merge kept.
+func tagValue(tags map[string]interface{}, key string) string {
+	if v, ok := tags[key].(string); ok {
+		return v
+	}
+	var found []string
+	for k, v := range tags {
+		if s, ok := v.(string); ok && strings.EqualFold(k, key) {
+			found = append(found, s)
+		}
+	}
+	if len(found) == 1 {
+		return found[0]
+	}
+	return ""
+}
+
+// Keys of tags that match key ignoring case but not exactly, sorted
+func miscasedKeys(tags map[string]interface{}, key string) []string {
+	var keys []string
+	for k := range tags {
+		if k != key && strings.EqualFold(k, key) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Managed instances with a tags argument, whether or not any are set
+func taggableInstances(tfState *State) []ResourceInstance {
+	var instances []ResourceInstance
+	for _, ri := range tfState.Instances() {
+		if _, ok := ri.Attributes["tags"]; ok && ri.Mode == ModeManaged {
+			instances = append(instances, ri)
+		}
+	}
+	return instances
+}
+
+// Resource groups in state, managed or read through a data source, by lowercased name
+func resourceGroupsByName(tfState *State) map[string]resourceGroupTags {
+	groups := map[string]resourceGroupTags{}
+	for _, ri := range tfState.Instances() {
+		if ri.Type != "azurerm_resource_group" {
+			continue
+		}
+		name, _ := ri.Attr().String("name")
+		tags, _ := ri.Attributes["tags"].(map[string]interface{})
+		groups[strings.ToLower(name)] = resourceGroupTags{address: ri.Address(), tags: tags}
+	}
+	return groups
+}
+
+// Everything wrong with one resource's tags. rg is the resource group it may
+// inherit from, nil for a resource group itself or one whose group is not in state.
+func (p *TagPolicy) violations(ri ResourceInstance, rg *resourceGroupTags, exp *Expectations) []tagViolation {
+	tags, _ := ri.Attributes["tags"].(map[string]interface{})
+	var violations []tagViolation
+
+	for _, key := range p.Required {
+		if v, ok := tags[key].(string); ok && v != "" {
+			continue
+		}
+		if v, ok := rg.inherited(key).(string); ok && v != "" && contains(p.Inherited, key) {
+			continue
+		}
+		msg := "missing " + key
+		if variants := miscasedKeys(tags, key); len(variants) > 0 {
+			msg += " (found " + strings.Join(variants, ", ") + ")"
+		}
+		if rg != nil && contains(p.Inherited, key) {
+			msg += "; not inherited either, " + rg.address + " does not carry it"
+		}
+		violations = append(violations, tagViolation{tagMissing, msg})
+	}
+
+	for _, key := range p.keys() {
+		allowed := p.allowed(key, exp)
+		v := tagValue(tags, key)
+		if len(allowed) == 0 || v == "" || contains(allowed, v) {
+			continue
+		}
+		msg := fmt.Sprintf("%s=%q is not one of %s", key, v, strings.Join(allowed, ", "))
+		if containsFold(allowed, v) {
+			msg += " (values are case-sensitive)"
+		}
+		violations = append(violations, tagViolation{tagValueInvalid, msg})
+	}
+
+	byFold := map[string][]string{}
+	for k := range tags {
+		byFold[strings.ToLower(k)] = append(byFold[strings.ToLower(k)], k)
+	}
+	for _, key := range p.keys() {
+		variants := miscasedKeys(tags, key)
+		switch {
+		case len(variants) == 0:
+		case len(byFold[strings.ToLower(key)]) > len(variants):
+			violations = append(violations, tagViolation{tagCase, fmt.Sprintf("%s collides with %s; which one survives depends on merge order", key, strings.Join(variants, ", "))})
+		default:
+			violations = append(violations, tagViolation{tagCase, fmt.Sprintf("%s should be spelled %s", strings.Join(variants, ", "), key)})
+		}
+	}
+	return violations
+}
+
+// Call check with the violations of every taggable resource
+func eachTaggable(tfState *State, exp *Expectations, policy *TagPolicy, check func(violations []tagViolation) CheckResult) CheckResult {
+	groups := resourceGroupsByName(tfState)
+	return eachInstance(taggableInstances(tfState), func(ri ResourceInstance) CheckResult {
+		var rg *resourceGroupTags
+		if name, _ := ri.Attr().String("resource_group_name"); ri.Type != "azurerm_resource_group" && name != "" {
+			if g, ok := groups[strings.ToLower(name)]; ok {
+				rg = &g
+			}
+		}
+		return check(policy.violations(ri, rg, exp))
+	})
+}
+
+// Fail each resource with violations of the given kind
+func checkTags(tfState *State, exp *Expectations, policy *TagPolicy, kind string) CheckResult {
+	return eachTaggable(tfState, exp, policy, func(violations []tagViolation) CheckResult {
+		var problems []string
+		for _, v := range violations {
+			if v.kind == kind {
+				problems = append(problems, v.message)
+			}
+		}
+		if len(problems) > 0 {
+			return fail(strings.Join(problems, "; "))
+		}
+		return pass(1)
+	})
+}
+
+// Every required key is set on the resource or, where the policy allows, its resource group
+func checkRequiredTags(tfState *State, exp *Expectations, policy *TagPolicy) CheckResult {
+	return checkTags(tfState, exp, policy, tagMissing)
+}
+
+// Tag values are ones the policy allows
+func checkAllowedTagValues(tfState *State, exp *Expectations, policy *TagPolicy) CheckResult {
+	return checkTags(tfState, exp, policy, tagValueInvalid)
+}
+
+// No resource carries a policy key in another case, alone or beside the right spelling
+func checkTagCase(tfState *State, exp *Expectations, policy *TagPolicy) CheckResult {
+	return checkTags(tfState, exp, policy, tagCase)
+}
+
+// Every violation per resource, in one list. A listing only; checks 1 to 3 fail on them.
+func checkTagViolations(tfState *State, exp *Expectations, policy *TagPolicy) CheckResult {
+	return eachTaggable(tfState, exp, policy, func(violations []tagViolation) CheckResult {
+		if len(violations) == 0 {
+			return passWith(1, "compliant")
+		}
+		lines := make([]string, len(violations))
+		for i, v := range violations {
+			lines[i] = v.kind + ": " + v.message
+		}
+		return passWith(1, fmt.Sprintf("%d violation(s): %s", len(violations), strings.Join(lines, "; ")))
+	})
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,114 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Holds every taggable resource to the tag policy: required keys, allowed values and key case
+func RunTagPolicyTests(tfState *State, exp *Expectations) []TestCase {
+	policy, err := loadTagPolicy(tagPolicyPath)
+	withPolicy := func(check func(*State, *Expectations, *TagPolicy) CheckResult) func() CheckResult {
+		return func() CheckResult {
+			if err != nil {
+				return fail("tag policy: " + err.Error())
+			}
+			return check(tfState, exp, policy)
+		}
+	}
+	tests := []GenericTest{
+		{"1._Verify_Required_Tags_Or_Inherited_From_Resource_Group", "TagPolicyTests", withPolicy(checkRequiredTags)},
+		{"2._Verify_Tag_Values_Are_Allowed", "TagPolicyTests", withPolicy(checkAllowedTagValues)},
+		{"3._Verify_No_Tag_Key_Case_Collisions", "TagPolicyTests", withPolicy(checkTagCase)},
+		{"4._Report_Tag_Violations_By_Resource", "TagPolicyTests", withPolicy(checkTagViolations)},
+	}
+
+	return executeTestCases(tests)
+}
+
+// The exp resource group carries Project twice, as the resource-group module's
+// merge with the environment's lowercase project leaves it. APIM leaves
+// Environment and Owner to its group; the NSG's group is not in state.
+const tagPolicyState = `{
+	"version": 4,
+	"resources": [
+		{"module": "module.exp.module.rg", "mode": "managed", "type": "azurerm_resource_group", "name": "this",
+		 "instances": [{"attributes": {"name": "dev-exp-rg", "tags": {"Name": "dev-exp-rg", "Project": "", "project": "API Ecosystem", "Environment": "dev", "Owner": "CloudTeam"}}}]},
+		{"module": "module.exp.module.apim", "mode": "managed", "type": "azurerm_api_management", "name": "this",
+		 "instances": [{"attributes": {"name": "dev-exp-apim", "resource_group_name": "DEV-EXP-RG", "tags": {"Name": "dev-exp-apim", "Project": "api ecosystem"}}}]},
+		{"module": "module.spoke.module.nsg_exp", "mode": "managed", "type": "azurerm_network_security_group", "name": "this",
+		 "instances": [{"attributes": {"name": "exp-nsg", "resource_group_name": "hub-rg", "tags": {"Name": "exp-nsg", "Project": "API Ecosystem", "environment": "qa"}}}]},
+		{"module": "module.spoke.module.subnet_exp", "mode": "managed", "type": "azurerm_subnet", "name": "this",
+		 "instances": [{"attributes": {"name": "exp", "resource_group_name": "dev-exp-rg"}}]}
+	]
+}`
+
+func TestTagPolicy(t *testing.T) {
+	tfState, err := parseState([]byte(tagPolicyState))
+	require.NoError(t, err)
+	policy, err := loadTagPolicy(tagPolicyPath)
+	require.NoError(t, err)
+	exp := &Expectations{Environment: "dev"}
+
+	const (
+		rg   = "module.exp.module.rg.azurerm_resource_group.this"
+		apim = "module.exp.module.apim.azurerm_api_management.this"
+		nsg  = "module.spoke.module.nsg_exp.azurerm_network_security_group.this"
+	)
+
+	required := instanceOutcomes(checkRequiredTags(tfState, exp, policy))
+	assert.Len(t, required, 3, "a resource without a tags argument is not taggable")
+	assert.Equal(t, "fail: missing Project (found project)", required[rg])
+	assert.Equal(t, "pass: ", required[apim], "Environment and Owner are inherited from dev-exp-rg")
+	assert.Equal(t, "fail: missing Environment (found environment); missing Owner", required[nsg])
+
+	values := instanceOutcomes(checkAllowedTagValues(tfState, exp, policy))
+	assert.Equal(t, "pass: ", values[rg])
+	assert.Equal(t, `fail: Project="api ecosystem" is not one of API Ecosystem (values are case-sensitive)`, values[apim])
+	assert.Equal(t, `fail: Environment="qa" is not one of dev`, values[nsg], "expectations pin Environment to the environment")
+
+	cases := instanceOutcomes(checkTagCase(tfState, exp, policy))
+	assert.Equal(t, "fail: Project collides with project; which one survives depends on merge order", cases[rg])
+	assert.Equal(t, "pass: ", cases[apim])
+	assert.Equal(t, "fail: environment should be spelled Environment", cases[nsg])
+
+	report := instanceOutcomes(checkTagViolations(tfState, exp, policy))
+	assert.Equal(t, `pass: 4 violation(s): missing: missing Environment (found environment); missing: missing Owner; value: Environment="qa" is not one of dev; case: environment should be spelled Environment`, report[nsg])
+}
+
+func TestTagPolicyInheritance(t *testing.T) {
+	tfState, err := parseState([]byte(tagPolicyState))
+	require.NoError(t, err)
+	policy := &TagPolicy{Required: []string{"Name", "Owner"}}
+
+	res := checkRequiredTags(tfState, nil, policy)
+	for _, inst := range res.Instances {
+		if inst.Address == "module.exp.module.apim.azurerm_api_management.this" {
+			assert.Equal(t, "missing Owner", inst.Message, "only keys the policy marks inherited come from the group")
+		}
+	}
+
+	policy.Inherited = []string{"Owner"}
+	res = checkRequiredTags(tfState, nil, policy)
+	for _, inst := range res.Instances {
+		if inst.Address == "module.spoke.module.nsg_exp.azurerm_network_security_group.this" {
+			assert.Equal(t, "missing Owner", inst.Message, "hub-rg is not in state to inherit from")
+		}
+	}
+}
+
+func TestTagPolicyValidation(t *testing.T) {
+	assert.EqualError(t, (&TagPolicy{Required: []string{"Project"}, AllowedValues: map[string][]string{"project": {"x"}}}).validate(),
+		"tag keys Project and project differ only in case")
+	assert.EqualError(t, (&TagPolicy{Required: []string{"Name"}, Inherited: []string{"Owner"}}).validate(),
+		"inherited tag Owner is not required")
+}
+
+func TestTagValue(t *testing.T) {
+	assert.Equal(t, "exact", tagValue(map[string]interface{}{"Project": "exact", "project": "other"}, "Project"))
+	assert.Equal(t, "only", tagValue(map[string]interface{}{"project": "only"}, "Project"))
+	assert.Equal(t, "", tagValue(map[string]interface{}{"project": "a", "PROJECT": "b"}, "Project"), "ambiguous without the exact key")
+	assert.Equal(t, "", tagValue(nil, "Project"))
+}
@@ -0,0 +1,219 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclparse"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/zclconf/go-cty/cty"
+	ctyjson "github.com/zclconf/go-cty/cty/json"
+)
+
+// Root of the Terraform environments, one directory per environment (e.g. Dev)
+const environmentsDir = "../Azure/terraform/Environments"
+
+// EnvironmentConfig is what an environment's root module passes to its module calls
+type EnvironmentConfig struct {
+	Dir     string
+	Modules map[string]*EnvModule
+}
+
+// EnvModule is one module block of the root module. Inputs holds every argument
+// that evaluates to a constant once var.* is resolved from terraform.tfvars and
+// variable defaults, with the called module's own defaults filled in; arguments
+// wired to other modules' outputs are left out.
+type EnvModule struct {
+	Name   string
+	Source string
+	Inputs map[string]interface{}
+}
+
+// Find Environments/<env> for an environment name, ignoring case (dev -> Dev)
+func findEnvironmentDir(env string) (string, error) {
+	entries, err := os.ReadDir(environmentsDir)
+	if err != nil {
+		return "", err
+	}
+	for _, e := range entries {
+		if e.IsDir() && strings.EqualFold(e.Name(), env) {
+			return filepath.Join(environmentsDir, e.Name()), nil
+		}
+	}
+	return "", fmt.Errorf("no Terraform environment %q under %s: %w", env, environmentsDir, os.ErrNotExist)
+}
+
+// Parse the module blocks of an environment's root module
+func loadEnvironmentConfig(dir string) (*EnvironmentConfig, error) {
+	parser := hclparse.NewParser()
+
+	vars, err := variableDefaults(parser, dir)
+	if err != nil {
+		return nil, err
+	}
+	tfvars, err := parseTFVars(parser, filepath.Join(dir, "terraform.tfvars"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for name, v := range tfvars {
+		vars[name] = v
+	}
+	ctx := &hcl.EvalContext{Variables: map[string]cty.Value{"var": cty.ObjectVal(vars)}}
+
+	blocks, err := tfBlocks(parser, dir, "module")
+	if err != nil {
+		return nil, err
+	}
+	cfg := &EnvironmentConfig{Dir: dir, Modules: map[string]*EnvModule{}}
+	for _, block := range blocks {
+		mod := &EnvModule{Name: block.Labels[0], Inputs: map[string]interface{}{}}
+		for name, attr := range block.Body.Attributes {
+			if name == "depends_on" || name == "providers" {
+				continue
+			}
+			val, diags := attr.Expr.Value(ctx)
+			if diags.HasErrors() {
+				continue // refers to another module's outputs
+			}
+			if name == "source" {
+				mod.Source = val.AsString()
+				continue
+			}
+			if mod.Inputs[name], err = ctyToGo(val); err != nil {
+				return nil, fmt.Errorf("module.%s.%s: %w", mod.Name, name, err)
+			}
+		}
+
+		if isLocalSource(mod.Source) {
+			defaults, err := variableDefaults(parser, filepath.Join(dir, mod.Source))
+			if err != nil {
+				return nil, fmt.Errorf("module.%s: %w", mod.Name, err)
+			}
+			for name, v := range defaults {
+				if _, set := mod.Inputs[name]; !set {
+					if mod.Inputs[name], err = ctyToGo(v); err != nil {
+						return nil, fmt.Errorf("module.%s.%s: %w", mod.Name, name, err)
+					}
+				}
+			}
+		}
+		cfg.Modules[mod.Name] = mod
+	}
+	return cfg, nil
+}
+
+// Constant string input of a module call
+func (m *EnvModule) String(name string) (string, bool) {
+	s, ok := m.Inputs[name].(string)
+	return s, ok
+}
+
+// Numeric input of a module call; Terraform converts "8" to a number the same way
+func (m *EnvModule) Int(name string) (int, bool) {
+	switch v := m.Inputs[name].(type) {
+	case float64:
+		return int(v), true
+	case string:
+		n, err := strconv.Atoi(v)
+		return n, err == nil
+	}
+	return 0, false
+}
+
+// Names of the string inputs ending in suffix, sorted, e.g. every *_subnet_cidr
+func (m *EnvModule) InputsWithSuffix(suffix string) []string {
+	var names []string
+	for name, v := range m.Inputs {
+		if _, ok := v.(string); ok && strings.HasSuffix(name, suffix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func isLocalSource(source string) bool {
+	return strings.HasPrefix(source, "./") || strings.HasPrefix(source, "../")
+}
+
+// Blocks of one type across all .tf files of a directory
+func tfBlocks(parser *hclparse.Parser, dir, blockType string) ([]*hclsyntax.Block, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.tf"))
+	if err != nil {
+		return nil, err
+	}
+	var blocks []*hclsyntax.Block
+	for _, path := range files {
+		file, diags := parser.ParseHCLFile(path)
+		if diags.HasErrors() {
+			return nil, diags
+		}
+		for _, block := range file.Body.(*hclsyntax.Body).Blocks {
+			if block.Type == blockType && len(block.Labels) == 1 {
+				blocks = append(blocks, block)
+			}
+		}
+	}
+	return blocks, nil
+}
+
+// Default of every variable declared in dir that has one
+func variableDefaults(parser *hclparse.Parser, dir string) (map[string]cty.Value, error) {
+	blocks, err := tfBlocks(parser, dir, "variable")
+	if err != nil {
+		return nil, err
+	}
+	defaults := map[string]cty.Value{}
+	for _, block := range blocks {
+		attr, ok := block.Body.Attributes["default"]
+		if !ok {
+			continue
+		}
+		val, diags := attr.Expr.Value(nil)
+		if diags.HasErrors() {
+			return nil, diags
+		}
+		defaults[block.Labels[0]] = val
+	}
+	return defaults, nil
+}
+
+func parseTFVars(parser *hclparse.Parser, path string) (map[string]cty.Value, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	file, diags := parser.ParseHCLFile(path)
+	if diags.HasErrors() {
+		return nil, diags
+	}
+	attrs, diags := file.Body.JustAttributes()
+	if diags.HasErrors() {
+		return nil, diags
+	}
+	vars := map[string]cty.Value{}
+	for name, attr := range attrs {
+		val, diags := attr.Expr.Value(nil)
+		if diags.HasErrors() {
+			return nil, diags
+		}
+		vars[name] = val
+	}
+	return vars, nil
+}
+
+// Plain Go value in the same shape encoding/json gives the state attributes
+func ctyToGo(val cty.Value) (interface{}, error) {
+	data, err := ctyjson.Marshal(val, val.Type())
+	if err != nil {
+		return nil, err
+	}
+	var v interface{}
+	err = json.Unmarshal(data, &v)
+	return v, err
+}
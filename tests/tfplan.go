@@ -0,0 +1,185 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Plan is the `terraform show -json <planfile>` document
+type Plan struct {
+	FormatVersion    string                  `json:"format_version"`
+	TerraformVersion string                  `json:"terraform_version"`
+	PlannedValues    *ShowValues             `json:"planned_values"`
+	ResourceChanges  []ResourceChange        `json:"resource_changes"`
+	OutputChanges    map[string]Change       `json:"output_changes"`
+	PriorState       *showState              `json:"prior_state"`
+	Configuration    *PlanConfiguration      `json:"configuration"`
+	Variables        map[string]PlanVariable `json:"variables"`
+}
+
+type PlanVariable struct {
+	Value interface{} `json:"value"`
+}
+
+// ResourceChange describes the planned action for one resource instance
+type ResourceChange struct {
+	Address         string      `json:"address"`
+	PreviousAddress string      `json:"previous_address,omitempty"`
+	ModuleAddress   string      `json:"module_address,omitempty"`
+	Mode            string      `json:"mode"`
+	Type            string      `json:"type"`
+	Name            string      `json:"name"`
+	Index           interface{} `json:"index,omitempty"`
+	ProviderName    string      `json:"provider_name"`
+	Change          Change      `json:"change"`
+	ActionReason    string      `json:"action_reason,omitempty"`
+}
+
+// Change holds the before/after objects of a planned action
+type Change struct {
+	Actions         Actions         `json:"actions"`
+	Before          interface{}     `json:"before"`
+	After           interface{}     `json:"after"`
+	AfterUnknown    interface{}     `json:"after_unknown,omitempty"`
+	BeforeSensitive json.RawMessage `json:"before_sensitive,omitempty"`
+	AfterSensitive  json.RawMessage `json:"after_sensitive,omitempty"`
+	ReplacePaths    [][]interface{} `json:"replace_paths,omitempty"`
+}
+
+// Actions is the action list of a change, e.g. ["create"] or ["delete", "create"]
+type Actions []string
+
+// PlanConfiguration is the module tree Terraform evaluated to build the plan
+type PlanConfiguration struct {
+	RootModule ConfigModule `json:"root_module"`
+}
+
+type ConfigModule struct {
+	Resources   []ConfigResource      `json:"resources,omitempty"`
+	ModuleCalls map[string]ModuleCall `json:"module_calls,omitempty"`
+}
+
+type ConfigResource struct {
+	Address     string                     `json:"address"`
+	Mode        string                     `json:"mode"`
+	Type        string                     `json:"type"`
+	Name        string                     `json:"name"`
+	Expressions map[string]json.RawMessage `json:"expressions,omitempty"`
+}
+
+type ModuleCall struct {
+	Source      string                     `json:"source"`
+	Expressions map[string]json.RawMessage `json:"expressions,omitempty"`
+	Module      ConfigModule               `json:"module"`
+}
+
+// Load a plan JSON file written by `terraform show -json tfplan.binary`
+func loadPlanFile(path string) (*Plan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parsePlan(data)
+}
+
+func parsePlan(data []byte) (*Plan, error) {
+	var plan Plan
+	if err := json.Unmarshal(data, &plan); err != nil {
+		return nil, err
+	}
+	if plan.FormatVersion == "" {
+		return nil, fmt.Errorf("not a plan JSON document (missing format_version); run `terraform show -json` on the plan file")
+	}
+	return &plan, nil
+}
+
+// PlannedState exposes planned_values as a State so state checks run unchanged
+// against the plan. Values only known after apply are absent.
+func (p *Plan) PlannedState() *State {
+	if p.PlannedValues == nil {
+		return &State{Version: 4, TerraformVersion: p.TerraformVersion}
+	}
+	state := p.PlannedValues.ToState()
+	state.TerraformVersion = p.TerraformVersion
+	return state
+}
+
+// PriorStateAsState returns the state the plan was computed against, nil for a first apply
+func (p *Plan) PriorStateAsState() *State {
+	if p.PriorState == nil || p.PriorState.Values == nil {
+		return nil
+	}
+	state := p.PriorState.Values.ToState()
+	state.TerraformVersion = p.PriorState.TerraformVersion
+	return state
+}
+
+// ChangesWith returns the managed resource changes whose actions satisfy match
+func (p *Plan) ChangesWith(match func(Actions) bool) []ResourceChange {
+	var results []ResourceChange
+	for _, rc := range p.ResourceChanges {
+		if rc.Mode == ModeManaged && match(rc.Change.Actions) {
+			results = append(results, rc)
+		}
+	}
+	return results
+}
+
+// Instance gives the change the same address shape as a state instance so Query can match it
+func (rc ResourceChange) Instance() ResourceInstance {
+	return ResourceInstance{
+		Resource: &Resource{Module: rc.ModuleAddress, Mode: rc.Mode, Type: rc.Type, Name: rc.Name, Provider: rc.ProviderName},
+		Instance: &Instance{IndexKey: rc.Index},
+	}
+}
+
+// AfterAttrs returns the planned object as an attribute accessor; unknown values are absent
+func (rc ResourceChange) AfterAttrs() Attrs {
+	after, _ := rc.Change.After.(map[string]interface{})
+	return Attrs{Address: rc.Address, Values: after, Sensitive: sensitivePaths(rc.Change.AfterSensitive)}
+}
+
+func (a Actions) is(actions ...string) bool {
+	if len(a) != len(actions) {
+		return false
+	}
+	for i := range a {
+		if a[i] != actions[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (a Actions) NoOp() bool   { return a.is("no-op") }
+func (a Actions) Read() bool   { return a.is("read") }
+func (a Actions) Create() bool { return a.is("create") }
+func (a Actions) Update() bool { return a.is("update") }
+func (a Actions) Delete() bool { return a.is("delete") }
+
+// Replace is true for both delete-before-create and create-before-destroy
+func (a Actions) Replace() bool {
+	return a.is("delete", "create") || a.is("create", "delete")
+}
+
+// Destroys is true when the existing object goes away, by delete or replace
+func (a Actions) Destroys() bool {
+	return a.Delete() || a.Replace()
+}
+
+// Writes is true when the plan creates or modifies an object in place or by replacement
+func (a Actions) Writes() bool {
+	return a.Create() || a.Update() || a.Replace()
+}
+
+func (a Actions) String() string {
+	switch {
+	case a.Replace():
+		return "replace"
+	case len(a) == 1:
+		return a[0]
+	default:
+		return fmt.Sprint([]string(a))
+	}
+}
@@ -0,0 +1,65 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePlanAndActions(t *testing.T) {
+	plan, err := loadPlanFile("testdata/plan.json")
+	require.NoError(t, err)
+
+	assert.Len(t, plan.ChangesWith(Actions.Replace), 1)
+	assert.Len(t, plan.ChangesWith(Actions.Delete), 1)
+	assert.Len(t, plan.ChangesWith(Actions.Destroys), 2)
+	assert.Len(t, plan.ChangesWith(Actions.Writes), 3)
+	assert.Empty(t, plan.ChangesWith(Actions.Read), "data source reads are not managed changes")
+
+	vnet := plan.ChangesWith(Actions.Replace)[0]
+	assert.Equal(t, "replace", vnet.Change.Actions.String())
+	assert.True(t, Query{Module: []string{"spoke"}, Descendants: true, Type: "azurerm_virtual_network"}.Matches(vnet.Instance()))
+
+	planned := plan.PlannedState()
+	apims, err := planned.Select("module.exp.module.apim.azurerm_api_management.*")
+	require.NoError(t, err)
+	require.Len(t, apims, 1)
+	sku, err := apims[0].Attr().String("sku_name")
+	require.NoError(t, err)
+	assert.Equal(t, "Developer_1", sku)
+
+	prior := plan.PriorStateAsState()
+	require.NotNil(t, prior)
+	assert.Len(t, prior.InstancesByType("azurerm_eventhub_namespace"), 1)
+
+	assert.Contains(t, plan.Configuration.RootModule.ModuleCalls["exp"].Module.ModuleCalls, "apim")
+
+	_, err = parsePlan([]byte(`{"version": 4}`))
+	assert.Error(t, err)
+}
+
+func TestRunPlanTestsReportsDestructiveAndFlagsOpenChanges(t *testing.T) {
+	plan, err := loadPlanFile("testdata/plan.json")
+	require.NoError(t, err)
+
+	results := map[string]TestCase{}
+	for _, tc := range RunPlanTests(plan, nil) {
+		results[tc.Name] = tc
+	}
+	// Destructive changes are the guardrails' call; the plan checks only list them
+	assert.Equal(t, "PASS", results["1._Report_Managed_Resources_Deleted"].Status)
+	assert.Contains(t, results["1._Report_Managed_Resources_Deleted"].SystemOut, "azurerm_eventhub_namespace")
+	assert.Equal(t, "PASS", results["2._Report_Managed_Resources_Replaced"].Status)
+	assert.Equal(t, "FAIL", results["3._Verify_No_Inbound_Allow_From_Internet_Introduced"].Status)
+	assert.Contains(t, results["3._Verify_No_Inbound_Allow_From_Internet_Introduced"].Failure.Message, "port 3389")
+	assert.Equal(t, "PASS", results["4._Verify_Planned_APIM_Network_Type"].Status, "Internal by default")
+
+	external := &Expectations{APIMVirtualNetworkType: "External"}
+	for _, tc := range RunPlanTests(plan, external) {
+		if tc.Name == "4._Verify_Planned_APIM_Network_Type" {
+			require.NotNil(t, tc.Failure)
+			assert.Contains(t, tc.Failure.Message, "expected External")
+		}
+	}
+}
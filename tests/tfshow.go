@@ -0,0 +1,144 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// ShowValues is the "values" representation used by `terraform show -json`
+// for state, and by the plan format for planned_values and prior_state
+type ShowValues struct {
+	Outputs    map[string]ShowOutput `json:"outputs"`
+	RootModule ShowModule            `json:"root_module"`
+}
+
+type ShowOutput struct {
+	Sensitive bool        `json:"sensitive"`
+	Value     interface{} `json:"value"`
+}
+
+type ShowModule struct {
+	Address      string         `json:"address,omitempty"`
+	Resources    []ShowResource `json:"resources"`
+	ChildModules []ShowModule   `json:"child_modules"`
+}
+
+type ShowResource struct {
+	Address         string                 `json:"address"`
+	Mode            string                 `json:"mode"`
+	Type            string                 `json:"type"`
+	Name            string                 `json:"name"`
+	Index           interface{}            `json:"index,omitempty"`
+	ProviderName    string                 `json:"provider_name"`
+	SchemaVersion   int                    `json:"schema_version"`
+	Values          map[string]interface{} `json:"values"`
+	SensitiveValues json.RawMessage        `json:"sensitive_values,omitempty"`
+	DependsOn       []string               `json:"depends_on,omitempty"`
+}
+
+// showState is the document written by `terraform show -json` for a state file
+type showState struct {
+	FormatVersion    string      `json:"format_version"`
+	TerraformVersion string      `json:"terraform_version"`
+	Values           *ShowValues `json:"values"`
+}
+
+// Decode either a raw state v4 file or `terraform show -json` output
+func parseStateDocument(data []byte) (*State, error) {
+	var probe struct {
+		FormatVersion string `json:"format_version"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return nil, err
+	}
+	if probe.FormatVersion == "" {
+		return parseState(data)
+	}
+
+	var show showState
+	if err := json.Unmarshal(data, &show); err != nil {
+		return nil, err
+	}
+	if show.Values == nil {
+		return nil, fmt.Errorf("terraform show output has no values (empty state?)")
+	}
+	state := show.Values.ToState()
+	state.TerraformVersion = show.TerraformVersion
+	return state, nil
+}
+
+// ToState regroups the per-instance values representation into state v4 resources
+// so that the same checks can run against show output, planned values and prior state
+func (v *ShowValues) ToState() *State {
+	state := &State{Version: 4, Outputs: map[string]Output{}}
+	for name, out := range v.Outputs {
+		state.Outputs[name] = Output{Value: out.Value, Sensitive: out.Sensitive}
+	}
+
+	index := map[string]int{}
+	var walk func(m ShowModule)
+	walk = func(m ShowModule) {
+		for _, r := range m.Resources {
+			res := Resource{Module: m.Address, Mode: r.Mode, Type: r.Type, Name: r.Name, Provider: r.ProviderName}
+			key := res.Address()
+			i, ok := index[key]
+			if !ok {
+				state.Resources = append(state.Resources, res)
+				i = len(state.Resources) - 1
+				index[key] = i
+			}
+			state.Resources[i].Instances = append(state.Resources[i].Instances, Instance{
+				IndexKey:            r.Index,
+				SchemaVersion:       r.SchemaVersion,
+				Attributes:          r.Values,
+				SensitiveAttributes: sensitivePaths(r.SensitiveValues),
+				Dependencies:        r.DependsOn,
+			})
+		}
+		for _, child := range m.ChildModules {
+			walk(child)
+		}
+	}
+	walk(v.RootModule)
+	return state
+}
+
+// Convert a sensitive_values mirror object ({"admin_password": true}) into attribute paths
+func sensitivePaths(raw json.RawMessage) []AttributePath {
+	if len(raw) == 0 {
+		return nil
+	}
+	var mirror interface{}
+	if err := json.Unmarshal(raw, &mirror); err != nil {
+		return nil
+	}
+
+	var paths []AttributePath
+	var walk func(node interface{}, prefix AttributePath)
+	walk = func(node interface{}, prefix AttributePath) {
+		switch n := node.(type) {
+		case bool:
+			if n && len(prefix) > 0 {
+				paths = append(paths, append(AttributePath{}, prefix...))
+			}
+		case map[string]interface{}:
+			names := make([]string, 0, len(n))
+			for name := range n {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				step, _ := json.Marshal(name)
+				walk(n[name], append(prefix, PathStep{Type: "get_attr", Value: step}))
+			}
+		case []interface{}:
+			for i, child := range n {
+				step, _ := json.Marshal(map[string]interface{}{"value": i, "type": "number"})
+				walk(child, append(prefix, PathStep{Type: "index", Value: step}))
+			}
+		}
+	}
+	walk(mirror, nil)
+	return paths
+}
@@ -0,0 +1,159 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Resource modes as written by Terraform in the state "mode" field
+const (
+	ModeManaged = "managed"
+	ModeData    = "data"
+)
+
+// State is the Terraform state file format, version 4
+type State struct {
+	Version          int               `json:"version"`
+	TerraformVersion string            `json:"terraform_version"`
+	Serial           int64             `json:"serial"`
+	Lineage          string            `json:"lineage"`
+	Outputs          map[string]Output `json:"outputs"`
+	Resources        []Resource        `json:"resources"`
+}
+
+// Output is a root module output value
+type Output struct {
+	Value     interface{}     `json:"value"`
+	Type      json.RawMessage `json:"type,omitempty"`
+	Sensitive bool            `json:"sensitive,omitempty"`
+}
+
+// Resource is one resource block; count/for_each expand into Instances
+type Resource struct {
+	Module    string     `json:"module,omitempty"`
+	Mode      string     `json:"mode"`
+	Type      string     `json:"type"`
+	Name      string     `json:"name"`
+	Provider  string     `json:"provider"`
+	Instances []Instance `json:"instances"`
+}
+
+// Instance is a single object tracked for a resource block
+type Instance struct {
+	IndexKey            interface{}            `json:"index_key,omitempty"`
+	SchemaVersion       int                    `json:"schema_version"`
+	Attributes          map[string]interface{} `json:"attributes"`
+	SensitiveAttributes []AttributePath        `json:"sensitive_attributes,omitempty"`
+	Dependencies        []string               `json:"dependencies,omitempty"`
+}
+
+// AttributePath is a path into an instance's attributes, e.g. admin_password
+// or application_insights[0].instrumentation_key
+type AttributePath []PathStep
+
+// PathStep is one step of an AttributePath: "get_attr" with a string value or
+// "index" with a {"value": ..., "type": ...} value
+type PathStep struct {
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value"`
+}
+
+// ResourceInstance pairs an instance with the resource block that owns it
+type ResourceInstance struct {
+	*Resource
+	*Instance
+}
+
+// Decode a state v4 document
+func parseState(data []byte) (*State, error) {
+	var state State
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	if state.Version != 4 {
+		return nil, fmt.Errorf("unsupported state version %d, expected 4", state.Version)
+	}
+	return &state, nil
+}
+
+// Address of the resource block, e.g. module.exp.module.apim.azurerm_api_management.this
+func (r *Resource) Address() string {
+	var b strings.Builder
+	if r.Module != "" {
+		b.WriteString(r.Module)
+		b.WriteString(".")
+	}
+	if r.Mode == ModeData {
+		b.WriteString("data.")
+	}
+	b.WriteString(r.Type)
+	b.WriteString(".")
+	b.WriteString(r.Name)
+	return b.String()
+}
+
+// Address of the instance including its index key, e.g. ...apim_dns_records["portal"]
+func (ri ResourceInstance) Address() string {
+	return ri.Resource.Address() + formatIndexKey(ri.IndexKey)
+}
+
+// Key returns the for_each key or count index as a string, "" when the block has neither
+func (ri ResourceInstance) Key() string {
+	switch k := ri.IndexKey.(type) {
+	case nil:
+		return ""
+	case string:
+		return k
+	case float64:
+		return strconv.FormatInt(int64(k), 10)
+	default:
+		return fmt.Sprint(k)
+	}
+}
+
+func formatIndexKey(key interface{}) string {
+	switch k := key.(type) {
+	case nil:
+		return ""
+	case string:
+		return "[" + strconv.Quote(k) + "]"
+	case float64:
+		return "[" + strconv.FormatInt(int64(k), 10) + "]"
+	default:
+		return fmt.Sprintf("[%v]", k)
+	}
+}
+
+// Instances flattens every resource in the state into its instances
+func (s *State) Instances() []ResourceInstance {
+	var results []ResourceInstance
+	for i := range s.Resources {
+		res := &s.Resources[i]
+		for j := range res.Instances {
+			results = append(results, ResourceInstance{Resource: res, Instance: &res.Instances[j]})
+		}
+	}
+	return results
+}
+
+// InstancesByType returns every managed instance whose resource type matches
+func (s *State) InstancesByType(resourceType string) []ResourceInstance {
+	var results []ResourceInstance
+	for _, ri := range s.Instances() {
+		if ri.Mode == ModeManaged && ri.Type == resourceType {
+			results = append(results, ri)
+		}
+	}
+	return results
+}
+
+// OutputValue returns the value of a root module output
+func (s *State) OutputValue(name string) (interface{}, bool) {
+	out, ok := s.Outputs[name]
+	if !ok {
+		return nil, false
+	}
+	return out.Value, true
+}
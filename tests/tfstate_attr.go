@@ -0,0 +1,171 @@
+package test
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Attrs reads typed values out of an instance's attributes by dotted path,
+// e.g. "sku.0.name" or "site_config.0.application_stack.0.dotnet_version"
+type Attrs struct {
+	Address   string
+	Values    map[string]interface{}
+	Sensitive []AttributePath // paths Terraform marked sensitive; see Masked and Evidence
+}
+
+// AttrError explains which segment of an attribute path could not be resolved
+type AttrError struct {
+	Address string
+	Path    string
+	Segment string
+	Reason  string
+}
+
+func (e *AttrError) Error() string {
+	if e.Segment == "" || e.Segment == e.Path {
+		return fmt.Sprintf("%s: attribute %q %s", e.Address, e.Path, e.Reason)
+	}
+	return fmt.Sprintf("%s: attribute %q: segment %q %s", e.Address, e.Path, e.Segment, e.Reason)
+}
+
+// Attr returns a path accessor over the instance's attributes
+func (ri ResourceInstance) Attr() Attrs {
+	return Attrs{Address: ri.Address(), Values: ri.Attributes, Sensitive: ri.SensitiveAttributes}
+}
+
+// Get resolves a path to its raw value; a null value is reported as missing
+func (a Attrs) Get(path string) (interface{}, error) {
+	var current interface{} = a.Values
+	for _, segment := range strings.Split(path, ".") {
+		switch node := current.(type) {
+		case map[string]interface{}:
+			value, ok := node[segment]
+			if !ok {
+				return nil, a.fail(path, segment, "is missing")
+			}
+			current = value
+		case []interface{}:
+			index, err := strconv.Atoi(segment)
+			if err != nil {
+				return nil, a.fail(path, segment, "is not a list index")
+			}
+			if index < 0 || index >= len(node) {
+				return nil, a.fail(path, segment, fmt.Sprintf("is out of range (list has %d elements)", len(node)))
+			}
+			current = node[index]
+		case nil:
+			return nil, a.fail(path, segment, "is missing (parent is null)")
+		default:
+			return nil, a.fail(path, segment, fmt.Sprintf("cannot be read from a %T", node))
+		}
+	}
+	if current == nil {
+		return nil, a.fail(path, "", "is null")
+	}
+	return current, nil
+}
+
+// Has reports whether a path resolves to a non-null value
+func (a Attrs) Has(path string) bool {
+	_, err := a.Get(path)
+	return err == nil
+}
+
+func (a Attrs) String(path string) (string, error) {
+	value, err := a.Get(path)
+	if err != nil {
+		return "", err
+	}
+	s, ok := value.(string)
+	if !ok {
+		return "", a.typeMismatch(path, "string", value)
+	}
+	return s, nil
+}
+
+func (a Attrs) Bool(path string) (bool, error) {
+	value, err := a.Get(path)
+	if err != nil {
+		return false, err
+	}
+	b, ok := value.(bool)
+	if !ok {
+		return false, a.typeMismatch(path, "bool", value)
+	}
+	return b, nil
+}
+
+func (a Attrs) Int(path string) (int, error) {
+	value, err := a.Get(path)
+	if err != nil {
+		return 0, err
+	}
+	f, ok := value.(float64)
+	if !ok || f != math.Trunc(f) {
+		return 0, a.typeMismatch(path, "integer", value)
+	}
+	return int(f), nil
+}
+
+func (a Attrs) Float(path string) (float64, error) {
+	value, err := a.Get(path)
+	if err != nil {
+		return 0, err
+	}
+	f, ok := value.(float64)
+	if !ok {
+		return 0, a.typeMismatch(path, "number", value)
+	}
+	return f, nil
+}
+
+func (a Attrs) List(path string) ([]interface{}, error) {
+	value, err := a.Get(path)
+	if err != nil {
+		return nil, err
+	}
+	l, ok := value.([]interface{})
+	if !ok {
+		return nil, a.typeMismatch(path, "list", value)
+	}
+	return l, nil
+}
+
+// Strings reads a list of strings such as address_prefixes
+func (a Attrs) Strings(path string) ([]string, error) {
+	list, err := a.List(path)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]string, 0, len(list))
+	for i, item := range list {
+		s, ok := item.(string)
+		if !ok {
+			return nil, a.typeMismatch(path+"."+strconv.Itoa(i), "string", item)
+		}
+		results = append(results, s)
+	}
+	return results, nil
+}
+
+func (a Attrs) Map(path string) (map[string]interface{}, error) {
+	value, err := a.Get(path)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, a.typeMismatch(path, "map", value)
+	}
+	return m, nil
+}
+
+func (a Attrs) fail(path, segment, reason string) *AttrError {
+	return &AttrError{Address: a.Address, Path: path, Segment: segment, Reason: reason}
+}
+
+func (a Attrs) typeMismatch(path, want string, got interface{}) *AttrError {
+	return a.fail(path, "", fmt.Sprintf("is %T, expected %s", got, want))
+}
@@ -0,0 +1,181 @@
+package test
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Query selects resource instances by address. Empty fields match anything;
+// Module, Type, Name and Key accept path.Match glob patterns; a keyed module
+// segment such as x["a"] matches its key exactly, or any key as x[*].
+type Query struct {
+	Module      []string // module path segments, e.g. {"exp", "apim"} for module.exp.module.apim
+	Descendants bool     // also match resources in modules nested below Module
+	Mode        string   // ModeManaged, ModeData or "" for both
+	Type        string
+	Name        string
+	Key         string
+}
+
+// Select returns the instances matching an address pattern such as
+// module.exp.module.apim.azurerm_api_management.* or module.spoke.**
+func (s *State) Select(pattern string) ([]ResourceInstance, error) {
+	q, err := ParseAddressPattern(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return s.Query(q), nil
+}
+
+// Query returns the instances matching q, in state order
+func (s *State) Query(q Query) []ResourceInstance {
+	var results []ResourceInstance
+	for _, ri := range s.Instances() {
+		if q.Matches(ri) {
+			results = append(results, ri)
+		}
+	}
+	return results
+}
+
+// InModule returns the managed instances owned by a module call and its children
+func (s *State) InModule(module string) []ResourceInstance {
+	return s.Query(Query{Module: moduleSegments(module), Descendants: true, Mode: ModeManaged})
+}
+
+// Matches reports whether a single instance satisfies the query
+func (q Query) Matches(ri ResourceInstance) bool {
+	if q.Mode != "" && ri.Mode != q.Mode {
+		return false
+	}
+	if !globMatch(q.Type, ri.Type) || !globMatch(q.Name, ri.Name) || !globMatch(q.Key, ri.Key()) {
+		return false
+	}
+	return q.matchesModule(moduleSegments(ri.Module))
+}
+
+func (q Query) matchesModule(segments []string) bool {
+	if q.Module == nil {
+		return true
+	}
+	if len(segments) < len(q.Module) || (!q.Descendants && len(segments) != len(q.Module)) {
+		return false
+	}
+	for i, want := range q.Module {
+		if !moduleSegmentMatch(want, segments[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Match a module segment such as x["a"]. The name is a glob; the key is compared
+// exactly, since path.Match would read its brackets as a character class. A bare
+// "*" matches any call, keyed or not, and a key of "*" any key of that call.
+func moduleSegmentMatch(pattern, segment string) bool {
+	if pattern == "*" {
+		return true
+	}
+	wantName, wantKey := splitIndexKey(pattern)
+	name, key := splitIndexKey(segment)
+	return globMatch(wantName, name) && (wantKey == key || wantKey == "*" && key != "")
+}
+
+func globMatch(pattern, value string) bool {
+	if pattern == "" || pattern == "*" {
+		return true
+	}
+	ok, err := path.Match(pattern, value)
+	return err == nil && ok
+}
+
+// ParseAddressPattern turns a resource address pattern into a Query.
+// A trailing "**" after the module path selects everything below it and a
+// leading "**." matches the resource in any module; otherwise the pattern must
+// end in <type>.<name>[<key>], with "data." for data sources.
+func ParseAddressPattern(pattern string) (Query, error) {
+	tokens := splitAddress(pattern)
+	q := Query{Module: []string{}}
+
+	i := 0
+	if len(tokens) > 1 && tokens[0] == "**" {
+		if tokens[1] == "module" {
+			return Query{}, fmt.Errorf("address pattern %q: a leading ** already matches any module path", pattern)
+		}
+		q.Module = nil
+		i++
+	}
+	for i < len(tokens) && tokens[i] == "module" {
+		if i+1 >= len(tokens) {
+			return Query{}, fmt.Errorf("address pattern %q: module keyword without a name", pattern)
+		}
+		q.Module = append(q.Module, tokens[i+1])
+		i += 2
+	}
+
+	if i == len(tokens)-1 && tokens[i] == "**" {
+		q.Descendants = true
+		q.Mode = ModeManaged
+		return q, nil
+	}
+
+	q.Mode = ModeManaged
+	if i < len(tokens) && tokens[i] == "data" {
+		q.Mode = ModeData
+		i++
+	}
+	if len(tokens)-i != 2 {
+		return Query{}, fmt.Errorf("address pattern %q: expected <type>.<name> after the module path", pattern)
+	}
+
+	q.Type = tokens[i]
+	q.Name, q.Key = splitIndexKey(tokens[i+1])
+	return q, nil
+}
+
+// Split module.a["x"].azurerm_subnet.this on dots outside of brackets
+func splitAddress(address string) []string {
+	var tokens []string
+	depth, start := 0, 0
+	inQuote := false
+	for i, r := range address {
+		switch {
+		case r == '"' && depth > 0:
+			inQuote = !inQuote
+		case r == '[' && !inQuote:
+			depth++
+		case r == ']' && !inQuote:
+			depth--
+		case r == '.' && depth == 0:
+			tokens = append(tokens, address[start:i])
+			start = i + 1
+		}
+	}
+	if address != "" {
+		tokens = append(tokens, address[start:])
+	}
+	return tokens
+}
+
+// Split name["key"] or name[0] into its name and unquoted key
+func splitIndexKey(token string) (string, string) {
+	open := strings.Index(token, "[")
+	if open < 0 || !strings.HasSuffix(token, "]") {
+		return token, ""
+	}
+	key := token[open+1 : len(token)-1]
+	return token[:open], strings.Trim(key, `"`)
+}
+
+// Turn module.exp.module.apim into {"exp", "apim"}; keyed calls keep their key, e.g. `x["a"]`
+func moduleSegments(module string) []string {
+	segments := []string{}
+	tokens := splitAddress(module)
+	for i := 0; i+1 < len(tokens); i += 2 {
+		if tokens[i] == "module" {
+			segments = append(segments, tokens[i+1])
+		}
+	}
+	return segments
+}
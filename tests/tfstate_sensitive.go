@@ -0,0 +1,185 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Placeholder for a sensitive value in evidence, as `terraform show` prints it
+const sensitiveMask = "(sensitive value)"
+
+// String renders the path in the dotted form Attrs takes, e.g. application_insights.0.instrumentation_key
+func (p AttributePath) String() string {
+	segments := make([]string, 0, len(p))
+	for _, step := range p {
+		segments = append(segments, step.segment())
+	}
+	return strings.Join(segments, ".")
+}
+
+func (s PathStep) segment() string {
+	if s.Type == "get_attr" {
+		var name string
+		_ = json.Unmarshal(s.Value, &name)
+		return name
+	}
+	var index struct {
+		Value interface{} `json:"value"`
+	}
+	_ = json.Unmarshal(s.Value, &index)
+	switch v := index.Value.(type) {
+	case float64:
+		return strconv.FormatInt(int64(v), 10)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// IsSensitive reports whether a dotted path is marked sensitive, or lies under
+// a marked block such as a whole application_insights list
+func (a Attrs) IsSensitive(path string) bool {
+	for _, p := range a.Sensitive {
+		marked := p.String()
+		if path == marked || strings.HasPrefix(path, marked+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// Masked returns a copy of the attributes with every sensitive value replaced,
+// safe to keep as evidence in messages and reports
+func (a Attrs) Masked() map[string]interface{} {
+	masked, _ := maskSensitive(a.Values, "", a.IsSensitive).(map[string]interface{})
+	return masked
+}
+
+// Evidence formats paths as "path = value" for a check message, masking sensitive values
+func (a Attrs) Evidence(paths ...string) string {
+	var lines []string
+	for _, path := range paths {
+		switch value, err := a.Get(path); {
+		case err != nil:
+			lines = append(lines, path+" = (missing)")
+		case a.IsSensitive(path):
+			lines = append(lines, path+" = "+sensitiveMask)
+		default:
+			lines = append(lines, fmt.Sprintf("%s = %v", path, value))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func maskSensitive(node interface{}, path string, sensitive func(string) bool) interface{} {
+	if path != "" && sensitive(path) {
+		return sensitiveMask
+	}
+	join := func(segment string) string {
+		if path == "" {
+			return segment
+		}
+		return path + "." + segment
+	}
+	switch n := node.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(n))
+		for k, v := range n {
+			out[k] = maskSensitive(v, join(k), sensitive)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(n))
+		for i, v := range n {
+			out[i] = maskSensitive(v, join(strconv.Itoa(i)), sensitive)
+		}
+		return out
+	default:
+		return node
+	}
+}
+
+// SensitiveValues collects the values behind every sensitive attribute and
+// sensitive output, so they can be masked wherever a check happens to echo them
+func (s *State) SensitiveValues() []string {
+	var values []string
+	for _, ri := range s.Instances() {
+		values = append(values, ri.Attr().sensitiveValues()...)
+	}
+	for _, out := range s.Outputs {
+		if out.Sensitive {
+			values = append(values, leafStrings(out.Value)...)
+		}
+	}
+	return uniqueSorted(values)
+}
+
+// SensitiveValues covers the planned and prior values plus both sides of every change
+func (p *Plan) SensitiveValues() []string {
+	values := p.PlannedState().SensitiveValues()
+	if prior := p.PriorStateAsState(); prior != nil {
+		values = append(values, prior.SensitiveValues()...)
+	}
+	for _, rc := range p.ResourceChanges {
+		before, _ := rc.Change.Before.(map[string]interface{})
+		values = append(values, Attrs{Values: before, Sensitive: sensitivePaths(rc.Change.BeforeSensitive)}.sensitiveValues()...)
+		values = append(values, rc.AfterAttrs().sensitiveValues()...)
+	}
+	for _, oc := range p.OutputChanges {
+		if string(oc.BeforeSensitive) == "true" {
+			values = append(values, leafStrings(oc.Before)...)
+		}
+		if string(oc.AfterSensitive) == "true" {
+			values = append(values, leafStrings(oc.After)...)
+		}
+	}
+	return uniqueSorted(values)
+}
+
+func (a Attrs) sensitiveValues() []string {
+	var values []string
+	for _, p := range a.Sensitive {
+		if value, err := a.Get(p.String()); err == nil {
+			values = append(values, leafStrings(value)...)
+		}
+	}
+	return values
+}
+
+// String and numeric leaves of a value; booleans are never worth masking
+func leafStrings(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case float64:
+		return []string{strconv.FormatFloat(v, 'f', -1, 64)}
+	case map[string]interface{}:
+		var leaves []string
+		for _, child := range v {
+			leaves = append(leaves, leafStrings(child)...)
+		}
+		return leaves
+	case []interface{}:
+		var leaves []string
+		for _, child := range v {
+			leaves = append(leaves, leafStrings(child)...)
+		}
+		return leaves
+	}
+	return nil
+}
+
+func uniqueSorted(values []string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, v := range values {
+		if v != "" && !seen[v] {
+			seen[v] = true
+			out = append(out, v)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
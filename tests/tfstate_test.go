@@ -0,0 +1,195 @@
+package test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"tests/config"
+)
+
+func TestParseStateKeepsEnvelope(t *testing.T) {
+	data, err := os.ReadFile("testdata/minimal.tfstate")
+	require.NoError(t, err)
+
+	state, err := parseState(data)
+	require.NoError(t, err)
+	assert.Equal(t, int64(42), state.Serial)
+	assert.Equal(t, "1.11.4", state.TerraformVersion)
+
+	var addresses []string
+	for _, ri := range state.Instances() {
+		addresses = append(addresses, ri.Address())
+	}
+	assert.Equal(t, []string{
+		"module.exp.azurerm_resource_group.exp",
+		`module.exp.module.apim.azurerm_private_dns_a_record.apim_dns_records["portal"]`,
+		"module.exp.module.apim.module.log_analytics_workspace.data.azurerm_client_config.current",
+		"module.bastion.azurerm_windows_virtual_machine.this[0]",
+	}, addresses)
+
+	records := state.InstancesByType("azurerm_private_dns_a_record")
+	require.Len(t, records, 1)
+	assert.Equal(t, "portal", records[0].Key())
+	assert.Equal(t, []string{"module.exp.azurerm_resource_group.exp"}, records[0].Dependencies)
+
+	vms := state.InstancesByType("azurerm_windows_virtual_machine")
+	require.Len(t, vms, 1)
+	assert.Equal(t, "0", vms[0].Key())
+	require.Len(t, vms[0].SensitiveAttributes, 1)
+
+	apimID, ok := state.OutputValue("apim_id")
+	assert.True(t, ok)
+	assert.Contains(t, apimID, "agida-dev-uaen-exp-apim")
+}
+
+func TestParseStateRejectsOtherVersions(t *testing.T) {
+	_, err := parseState([]byte(`{"version": 3}`))
+	assert.Error(t, err)
+}
+
+func TestSelectByAddressPattern(t *testing.T) {
+	data, err := os.ReadFile("testdata/minimal.tfstate")
+	require.NoError(t, err)
+	state, err := parseState(data)
+	require.NoError(t, err)
+
+	cases := map[string][]string{
+		"module.exp.module.apim.azurerm_private_dns_a_record.*": {
+			`module.exp.module.apim.azurerm_private_dns_a_record.apim_dns_records["portal"]`,
+		},
+		`module.exp.module.*.azurerm_private_dns_a_record.apim_dns_records["portal"]`: {
+			`module.exp.module.apim.azurerm_private_dns_a_record.apim_dns_records["portal"]`,
+		},
+		"module.exp.**": {
+			"module.exp.azurerm_resource_group.exp",
+			`module.exp.module.apim.azurerm_private_dns_a_record.apim_dns_records["portal"]`,
+		},
+		"module.exp.module.apim.module.log_analytics_workspace.data.azurerm_client_config.*": {
+			"module.exp.module.apim.module.log_analytics_workspace.data.azurerm_client_config.current",
+		},
+		"module.bastion.azurerm_windows_virtual_machine.this[0]": {
+			"module.bastion.azurerm_windows_virtual_machine.this[0]",
+		},
+		"azurerm_resource_group.*": nil,
+	}
+	for pattern, want := range cases {
+		got, err := state.Select(pattern)
+		require.NoError(t, err, pattern)
+		var addresses []string
+		for _, ri := range got {
+			addresses = append(addresses, ri.Address())
+		}
+		assert.Equal(t, want, addresses, pattern)
+	}
+
+	_, err = state.Select("module.exp.azurerm_resource_group")
+	assert.Error(t, err)
+}
+
+func TestAttrsPathErrors(t *testing.T) {
+	attrs := Attrs{
+		Address: "module.x.azurerm_application_gateway.this",
+		Values: map[string]interface{}{
+			"name":         nil,
+			"enable_http2": true,
+			"sku":          []interface{}{map[string]interface{}{"name": "Standard_v2", "capacity": float64(2)}},
+		},
+	}
+
+	name, err := attrs.String("sku.0.name")
+	require.NoError(t, err)
+	assert.Equal(t, "Standard_v2", name)
+
+	capacity, err := attrs.Int("sku.0.capacity")
+	require.NoError(t, err)
+	assert.Equal(t, 2, capacity)
+
+	http2, err := attrs.Bool("enable_http2")
+	require.NoError(t, err)
+	assert.True(t, http2)
+
+	_, err = attrs.String("sku.1.name")
+	assert.EqualError(t, err, `module.x.azurerm_application_gateway.this: attribute "sku.1.name": segment "1" is out of range (list has 1 elements)`)
+
+	_, err = attrs.String("sku.0.tier")
+	assert.EqualError(t, err, `module.x.azurerm_application_gateway.this: attribute "sku.0.tier": segment "tier" is missing`)
+
+	_, err = attrs.String("name")
+	assert.EqualError(t, err, `module.x.azurerm_application_gateway.this: attribute "name" is null`)
+
+	_, err = attrs.String("enable_http2")
+	assert.EqualError(t, err, `module.x.azurerm_application_gateway.this: attribute "enable_http2" is bool, expected string`)
+
+	var attrErr *AttrError
+	_, err = attrs.List("sku.0.name.x")
+	require.ErrorAs(t, err, &attrErr)
+	assert.Equal(t, "x", attrErr.Segment)
+}
+
+func TestSensitiveAttributesAreMasked(t *testing.T) {
+	state, err := parseState([]byte(`{
+		"version": 4,
+		"outputs": {"apim_key": {"value": "output-secret-value", "sensitive": true}, "apim_name": {"value": "apim-dev"}},
+		"resources": [
+			{"module": "module.bastion", "mode": "managed", "type": "azurerm_windows_virtual_machine", "name": "this",
+			 "instances": [{"attributes": {"name": "vm-bastion", "admin_username": "azureuser", "admin_password": "P@ssw0rd-1234"},
+			                "sensitive_attributes": [[{"type": "get_attr", "value": "admin_password"}]]}]},
+			{"module": "module.exp.module.apim", "mode": "managed", "type": "azurerm_api_management_logger", "name": "this",
+			 "instances": [{"attributes": {"name": "appinsights", "application_insights": [{"instrumentation_key": "1111-2222-3333-4444"}]},
+			                "sensitive_attributes": [[{"type": "get_attr", "value": "application_insights"}, {"type": "index", "value": {"value": 0, "type": "number"}}, {"type": "get_attr", "value": "instrumentation_key"}]]}]}
+		]
+	}`))
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"1111-2222-3333-4444", "P@ssw0rd-1234", "output-secret-value"}, state.SensitiveValues())
+
+	vm := state.InstancesByType("azurerm_windows_virtual_machine")[0].Attr()
+	assert.True(t, vm.IsSensitive("admin_password"))
+	assert.False(t, vm.IsSensitive("admin_username"))
+	assert.Equal(t, "(sensitive value)", vm.Masked()["admin_password"])
+	assert.Equal(t, "azureuser", vm.Masked()["admin_username"])
+	assert.Equal(t, "P@ssw0rd-1234", vm.Values["admin_password"], "Masked does not modify the state")
+
+	logger := state.InstancesByType("azurerm_api_management_logger")[0].Attr()
+	assert.Equal(t, "name = appinsights\napplication_insights.0.instrumentation_key = (sensitive value)",
+		logger.Evidence("name", "application_insights.0.instrumentation_key"))
+
+	// Checks that echo a sensitive value anyway are masked once the state's values are registered
+	config.RegisterSecrets(state.SensitiveValues()...)
+	cases := executeTestCases([]GenericTest{
+		{"Leaky", "C", func() CheckResult { return fail("instrumentation_key 1111-2222-3333-4444 is not rotated") }},
+	})
+	assert.Equal(t, "instrumentation_key REDACTED is not rotated", cases[0].Failure.Message)
+}
+
+func TestSelectKeyedModuleInstances(t *testing.T) {
+	state, err := parseState([]byte(`{
+		"version": 4,
+		"resources": [
+			{"module": "module.x[\"a\"]", "mode": "managed", "type": "azurerm_subnet", "name": "s", "instances": [{"attributes": {}}]},
+			{"module": "module.x[\"b\"]", "mode": "managed", "type": "azurerm_subnet", "name": "s", "instances": [{"attributes": {}}]},
+			{"module": "module.x", "mode": "managed", "type": "azurerm_subnet", "name": "s", "instances": [{"attributes": {}}]}
+		]
+	}`))
+	require.NoError(t, err)
+
+	cases := map[string][]string{
+		`module.x["a"].azurerm_subnet.s`: {`module.x["a"].azurerm_subnet.s`},
+		`module.x[*].azurerm_subnet.s`:   {`module.x["a"].azurerm_subnet.s`, `module.x["b"].azurerm_subnet.s`},
+		`module.*.azurerm_subnet.s`:      {`module.x["a"].azurerm_subnet.s`, `module.x["b"].azurerm_subnet.s`, "module.x.azurerm_subnet.s"},
+		"module.x.azurerm_subnet.s":      {"module.x.azurerm_subnet.s"},
+		`module.x["c"].**`:               nil,
+	}
+	for pattern, want := range cases {
+		got, err := state.Select(pattern)
+		require.NoError(t, err, pattern)
+		var addresses []string
+		for _, ri := range got {
+			addresses = append(addresses, ri.Address())
+		}
+		assert.Equal(t, want, addresses, pattern)
+	}
+}
@@ -1,24 +1,138 @@
 package test
 
-import "encoding/xml"
+import (
+	"encoding/xml"
+	"time"
+
+	"tests/config"
+)
+
+// Test case outcomes; only the JUnit elements below reach the XML report
+const (
+	StatusPass    = "PASS"
+	StatusFail    = "FAIL"
+	StatusSkipped = "SKIPPED"
+	StatusError   = "ERROR"
+)
 
 type TestCase struct {
 	Classname string   `xml:"classname,attr"`
 	Name      string   `xml:"name,attr"`
+	Time      float64  `xml:"time,attr"`
 	Failure   *Failure `xml:"failure,omitempty"`
-	Status    string   `xml:"status"`
+	Error     *Failure `xml:"error,omitempty"`
+	Skipped   *Skipped `xml:"skipped,omitempty"`
+	SystemOut string   `xml:"system-out,omitempty"`
+	SystemErr string   `xml:"system-err,omitempty"`
+	Status    string   `xml:"-"`
 }
 
+// Failure is used for both <failure> (assertion did not hold) and <error> (check could not run)
 type Failure struct {
 	Message string `xml:"message,attr"`
 	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+type Skipped struct {
+	Message string `xml:"message,attr,omitempty"`
+}
+
+type Property struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
 }
 
 type TestSuite struct {
-	XMLName   xml.Name   `xml:"testsuite"`
-	Tests     int        `xml:"tests,attr"`
-	Failures  int        `xml:"failures,attr"`
-	Errors    int        `xml:"errors,attr"`
-	Time      float64    `xml:"time,attr"`
-	TestCases []TestCase `xml:"testcase"`
+	XMLName    xml.Name   `xml:"testsuite"`
+	Name       string     `xml:"name,attr"`
+	Tests      int        `xml:"tests,attr"`
+	Failures   int        `xml:"failures,attr"`
+	Errors     int        `xml:"errors,attr"`
+	Skipped    int        `xml:"skipped,attr"`
+	Time       float64    `xml:"time,attr"`
+	Timestamp  string     `xml:"timestamp,attr,omitempty"`
+	Properties []Property `xml:"properties>property,omitempty"`
+	TestCases  []TestCase `xml:"testcase"`
+}
+
+type TestSuites struct {
+	XMLName  xml.Name    `xml:"testsuites"`
+	Name     string      `xml:"name,attr,omitempty"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Errors   int         `xml:"errors,attr"`
+	Skipped  int         `xml:"skipped,attr"`
+	Time     float64     `xml:"time,attr"`
+	Suites   []TestSuite `xml:"testsuite"`
+}
+
+// Build a suite from finished test cases, tallying each outcome separately
+func newTestSuite(name string, started time.Time, props []Property, cases []TestCase) TestSuite {
+	suite := TestSuite{
+		Name:       name,
+		Timestamp:  started.UTC().Format("2006-01-02T15:04:05"),
+		Time:       time.Since(started).Seconds(),
+		Properties: props,
+	}
+	for _, tc := range cases {
+		suite.Tests++
+		switch tc.Status {
+		case StatusFail:
+			suite.Failures++
+		case StatusError:
+			suite.Errors++
+		case StatusSkipped:
+			suite.Skipped++
+		}
+	}
+	suite.TestCases = cases
+	return suite
+}
+
+// Append a suite and roll its totals up into the root element
+func (s *TestSuites) add(suite TestSuite) {
+	s.Suites = append(s.Suites, suite)
+	s.Tests += suite.Tests
+	s.Failures += suite.Failures
+	s.Errors += suite.Errors
+	s.Skipped += suite.Skipped
+	s.Time += suite.Time
+}
+
+// Copy of the report with credentials masked in every name, message, output and property
+func (s TestSuites) redacted() TestSuites {
+	out := s
+	out.Name = config.Redact(s.Name)
+	out.Suites = make([]TestSuite, len(s.Suites))
+	for i, suite := range s.Suites {
+		suite.Name = config.Redact(suite.Name)
+		props := make([]Property, len(suite.Properties))
+		for j, p := range suite.Properties {
+			props[j] = Property{Name: p.Name, Value: config.Redact(p.Value)}
+		}
+		suite.Properties = props
+		cases := make([]TestCase, len(suite.TestCases))
+		for j, tc := range suite.TestCases {
+			tc.Name = config.Redact(tc.Name)
+			tc.Failure = tc.Failure.redacted()
+			tc.Error = tc.Error.redacted()
+			if tc.Skipped != nil {
+				tc.Skipped = &Skipped{Message: config.Redact(tc.Skipped.Message)}
+			}
+			tc.SystemOut = config.Redact(tc.SystemOut)
+			tc.SystemErr = config.Redact(tc.SystemErr)
+			cases[j] = tc
+		}
+		suite.TestCases = cases
+		out.Suites[i] = suite
+	}
+	return out
+}
+
+func (f *Failure) redacted() *Failure {
+	if f == nil {
+		return nil
+	}
+	return &Failure{Message: config.Redact(f.Message), Type: f.Type, Text: config.Redact(f.Text)}
 }